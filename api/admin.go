@@ -0,0 +1,349 @@
+package api
+
+import (
+	"crypto/subtle"
+	"flag"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/chihkaiyu/ratelimiter/base/ctx"
+	"github.com/chihkaiyu/ratelimiter/service/accesslist"
+	"github.com/chihkaiyu/ratelimiter/service/penalty"
+	"github.com/chihkaiyu/ratelimiter/service/ratelimiter"
+	"github.com/chihkaiyu/ratelimiter/service/ratelimiter/rule"
+)
+
+const (
+	defaultTopConsumers = 10
+)
+
+var (
+	adminToken = flag.String("admin_token", "", "the bearer token of admin API, admin API is disabled if it's empty")
+)
+
+// AdminAuth authenticates the requests of admin API by bearer token
+func AdminAuth() gin.HandlerFunc {
+	return bearerAuth(adminToken)
+}
+
+// bearerAuth authenticates the requests by bearer token, all requests are rejected if the token is empty
+func bearerAuth(expected *string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if *expected == "" || subtle.ConstantTimeCompare([]byte(token), []byte(*expected)) != 1 {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// Admin serves admin API
+type Admin struct {
+	limiter    ratelimiter.Service
+	penalty    penalty.Service
+	accessList accesslist.Service
+}
+
+func NewAdmin(limiter ratelimiter.Service, penalty penalty.Service, accessList accesslist.Service) *Admin {
+	return &Admin{
+		limiter:    limiter,
+		penalty:    penalty,
+		accessList: accessList,
+	}
+}
+
+// ListBans lists the banned clients
+func (a *Admin) ListBans(c *gin.Context) {
+	context := c.MustGet("ctx").(ctx.CTX)
+
+	bans, err := a.penalty.List(context)
+	if err != nil {
+		context.WithField("err", err).Error("penalty.List failed")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+
+	result := []gin.H{}
+	for _, ban := range bans {
+		result = append(result, gin.H{
+			"key":               ban.Key,
+			"remaining_seconds": ban.Remaining.Seconds(),
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"bans": result})
+}
+
+// LiftBan lifts the ban of a client
+func (a *Admin) LiftBan(c *gin.Context) {
+	context := c.MustGet("ctx").(ctx.CTX)
+	key := c.Param("key")
+
+	if err := a.penalty.Lift(context, key); err != nil {
+		context.WithField("err", err).Error("penalty.Lift failed")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// ListRules lists the rules
+func (a *Admin) ListRules(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"rules": a.limiter.Rules()})
+}
+
+// SetRule creates or updates a rule
+func (a *Admin) SetRule(c *gin.Context) {
+	context := c.MustGet("ctx").(ctx.CTX)
+
+	r := rule.Rule{}
+	if err := c.ShouldBindJSON(&r); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	r.Name = c.Param("name")
+	if err := r.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := a.limiter.SetRule(context, r); err != nil {
+		context.WithField("err", err).Error("limiter.SetRule failed")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, r)
+}
+
+// DeleteRule deletes a rule
+func (a *Admin) DeleteRule(c *gin.Context) {
+	context := c.MustGet("ctx").(ctx.CTX)
+
+	err := a.limiter.DeleteRule(context, c.Param("name"))
+	if err == ratelimiter.ErrRuleNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		context.WithField("err", err).Error("limiter.DeleteRule failed")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// queryDescriptor describes the request to inspect by query string, e.g. ?ip=10.0.0.1
+type queryDescriptor struct {
+	c *gin.Context
+}
+
+func (d queryDescriptor) Value(key string) (string, bool) {
+	value := d.c.Query(key)
+	return value, value != ""
+}
+
+// InspectKey returns the state of every level of a rule for the keys in query string
+func (a *Admin) InspectKey(c *gin.Context) {
+	context := c.MustGet("ctx").(ctx.CTX)
+
+	decisions, err := a.limiter.Inspect(context, c.Param("name"), queryDescriptor{c: c})
+	if err == ratelimiter.ErrRuleNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		context.WithField("err", err).Error("limiter.Inspect failed")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+
+	result := []gin.H{}
+	for _, d := range decisions {
+		result = append(result, decisionBody(d))
+	}
+	c.JSON(http.StatusOK, gin.H{"levels": result})
+}
+
+// ResetKey resets the counters of a rule for the keys in query string
+func (a *Admin) ResetKey(c *gin.Context) {
+	context := c.MustGet("ctx").(ctx.CTX)
+
+	if len(c.Request.URL.Query()) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no key to reset"})
+		return
+	}
+
+	err := a.limiter.Reset(context, c.Param("name"), queryDescriptor{c: c})
+	if err == ratelimiter.ErrRuleNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		context.WithField("err", err).Error("limiter.Reset failed")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// TopConsumers lists the keys making most requests to a level of a rule
+func (a *Admin) TopConsumers(c *gin.Context) {
+	context := c.MustGet("ctx").(ctx.CTX)
+
+	n, err := strconv.Atoi(c.DefaultQuery("n", strconv.Itoa(defaultTopConsumers)))
+	if err != nil || n <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid n"})
+		return
+	}
+
+	consumers, err := a.limiter.TopConsumers(context, c.Param("name"), c.Param("level"), n)
+	if err == ratelimiter.ErrRuleNotFound || err == ratelimiter.ErrLevelNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		context.WithField("err", err).Error("limiter.TopConsumers failed")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+
+	result := []gin.H{}
+	for _, consumer := range consumers {
+		result = append(result, gin.H{
+			"key":      consumer.Key,
+			"requests": consumer.Requests,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"consumers": result})
+}
+
+// ListOverrides lists the overrides
+func (a *Admin) ListOverrides(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"overrides": a.limiter.Overrides()})
+}
+
+// SetOverride creates or updates an override
+func (a *Admin) SetOverride(c *gin.Context) {
+	context := c.MustGet("ctx").(ctx.CTX)
+
+	o := rule.Override{}
+	if err := c.ShouldBindJSON(&o); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := o.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := a.limiter.SetOverride(context, o)
+	if err == ratelimiter.ErrRuleNotFound || err == ratelimiter.ErrLevelNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		context.WithField("err", err).Error("limiter.SetOverride failed")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, o)
+}
+
+// DeleteOverride deletes the override given by query string, e.g. ?rule=api&level=user&pattern=tenant-*
+func (a *Admin) DeleteOverride(c *gin.Context) {
+	context := c.MustGet("ctx").(ctx.CTX)
+
+	err := a.limiter.DeleteOverride(context, c.Query("rule"), c.Query("level"), c.Query("pattern"))
+	if err == ratelimiter.ErrOverrideNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		context.WithField("err", err).Error("limiter.DeleteOverride failed")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// accessListEntry is the body of adding an access list entry
+type accessListEntry struct {
+	Kind  accesslist.Kind `json:"kind" binding:"required"`
+	Entry string          `json:"entry" binding:"required"`
+}
+
+// ListAccessList lists the entries of allowlist or denylist
+func (a *Admin) ListAccessList(c *gin.Context) {
+	entries, err := a.accessList.Entries(accesslist.List(c.Param("list")))
+	if err == accesslist.ErrUnknownList {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, entries)
+}
+
+// AddAccessListEntry adds an IP, CIDR or API key into allowlist or denylist
+func (a *Admin) AddAccessListEntry(c *gin.Context) {
+	context := c.MustGet("ctx").(ctx.CTX)
+
+	e := accessListEntry{}
+	if err := c.ShouldBindJSON(&e); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := a.accessList.Add(context, accesslist.List(c.Param("list")), e.Kind, e.Entry)
+	if writeAccessListError(c, err) {
+		return
+	}
+	if err != nil {
+		context.WithField("err", err).Error("accessList.Add failed")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, e)
+}
+
+// RemoveAccessListEntry removes the entry given by query string, e.g. ?kind=ip&entry=10.0.0.0/8
+func (a *Admin) RemoveAccessListEntry(c *gin.Context) {
+	context := c.MustGet("ctx").(ctx.CTX)
+
+	err := a.accessList.Remove(context, accesslist.List(c.Param("list")), accesslist.Kind(c.Query("kind")), c.Query("entry"))
+	if writeAccessListError(c, err) {
+		return
+	}
+	if err != nil {
+		context.WithField("err", err).Error("accessList.Remove failed")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// writeAccessListError responds the errors caused by the request, returns whether it's responded
+func writeAccessListError(c *gin.Context, err error) bool {
+	switch err {
+	case accesslist.ErrUnknownList:
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case accesslist.ErrUnknownKind, accesslist.ErrInvalidIP:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,169 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"github.com/chihkaiyu/ratelimiter/base/ctx"
+	"github.com/chihkaiyu/ratelimiter/service/ratelimiter"
+)
+
+const (
+	maxBatchSize = 100
+)
+
+var (
+	decisionToken = flag.String("decision_token", "", "the bearer token of decision API, decision API is disabled if it's empty")
+)
+
+// DecisionAuth authenticates the requests of decision API by bearer token
+func DecisionAuth() gin.HandlerFunc {
+	return bearerAuth(decisionToken)
+}
+
+// DecisionEnabled returns true if decision_token is set, the decision services aren't served otherwise
+func DecisionEnabled() bool {
+	return *decisionToken != ""
+}
+
+// DecisionAPI serves the decisions of the rate limiter over HTTP JSON for the services not embedding it
+type DecisionAPI struct {
+	limiter ratelimiter.Limiter
+}
+
+func NewDecisionAPI(limiter ratelimiter.Limiter) *DecisionAPI {
+	return &DecisionAPI{
+		limiter: limiter,
+	}
+}
+
+type acquireRequest struct {
+	Rule string `json:"rule"`
+	// Key is the key of the levels not in Entries
+	Key string `json:"key"`
+	// Entries are the keys by the key of the levels, e.g. {"tenant": "acme", "user": "bob"}
+	Entries map[string]string `json:"entries"`
+	// Cost is the number of permits to acquire, 1 if it's zero. A cost more than the limit of a level
+	// is denied without being counted.
+	Cost int `json:"cost"`
+}
+
+func (r acquireRequest) Value(key string) (string, bool) {
+	if value, ok := r.Entries[key]; ok {
+		return value, true
+	}
+
+	return r.Key, r.Key != ""
+}
+
+func (r acquireRequest) validate() string {
+	if r.Rule == "" {
+		return "rule is required"
+	}
+	if r.Key == "" && len(r.Entries) == 0 {
+		return "key or entries is required"
+	}
+	if r.Cost < 0 {
+		return "cost must not be negative"
+	}
+
+	return ""
+}
+
+// Acquire acquires the permits of a request, or a batch of requests in a JSON array. A single
+// request responds the decision, and a batch responds the decisions in the same order.
+func (a *DecisionAPI) Acquire(c *gin.Context) {
+	context := c.MustGet("ctx").(ctx.CTX)
+
+	body, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !bytes.HasPrefix(bytes.TrimSpace(body), []byte("[")) {
+		req := acquireRequest{}
+		if err := json.Unmarshal(body, &req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if msg := req.validate(); msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		code, result := a.acquire(context, req)
+		c.JSON(code, result)
+		return
+	}
+
+	reqs := []acquireRequest{}
+	if err := json.Unmarshal(body, &reqs); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(reqs) == 0 || len(reqs) > maxBatchSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("batch size must be between 1 and %d", maxBatchSize)})
+		return
+	}
+	for _, req := range reqs {
+		if msg := req.validate(); msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+	}
+
+	// the requests in a batch are acquired independently, and the failed ones respond the error
+	results := []gin.H{}
+	for _, req := range reqs {
+		_, result := a.acquire(context, req)
+		results = append(results, result)
+	}
+	c.JSON(http.StatusOK, results)
+}
+
+// acquire returns the status code and the body of a request
+func (a *DecisionAPI) acquire(context ctx.CTX, req acquireRequest) (int, gin.H) {
+	cost := req.Cost
+	if cost == 0 {
+		cost = 1
+	}
+
+	decision, err := a.limiter.AcquireN(context, req.Rule, req, cost)
+	if err == ratelimiter.ErrRuleNotFound {
+		return http.StatusNotFound, gin.H{"rule": req.Rule, "error": err.Error()}
+	}
+	if err != nil {
+		context.WithFields(logrus.Fields{
+			"err":  err,
+			"rule": req.Rule,
+		}).Error("limiter.AcquireN failed")
+		return http.StatusServiceUnavailable, gin.H{"rule": req.Rule, "error": "rate limiter unavailable"}
+	}
+
+	return http.StatusOK, decisionBody(decision)
+}
+
+// decisionBody returns the JSON body of the decision
+func decisionBody(d ratelimiter.Decision) gin.H {
+	return gin.H{
+		"rule":                d.Rule,
+		"level":               d.Level,
+		"key":                 d.Key,
+		"strategy":            d.Strategy,
+		"allowed":             d.Allowed,
+		"would_block":         d.WouldBlock,
+		"reason":              d.Reason(),
+		"count":               d.Count,
+		"limit":               d.Limit,
+		"remaining":           d.Remaining,
+		"retry_after_seconds": d.RetryAfter.Seconds(),
+		"reset_after_seconds": d.ResetAfter.Seconds(),
+	}
+}
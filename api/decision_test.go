@@ -0,0 +1,170 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/chihkaiyu/ratelimiter/service/ratelimiter"
+	"github.com/chihkaiyu/ratelimiter/service/ratelimiter/strategy"
+)
+
+type decisionSuite struct {
+	suite.Suite
+	limiter *mockLimiter
+	router  *gin.Engine
+}
+
+func TestDecisionSuite(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	suite.Run(t, new(decisionSuite))
+}
+
+func (s *decisionSuite) SetupTest() {
+	s.limiter = new(mockLimiter)
+	s.router = gin.New()
+	s.router.POST("/acquire", AddContext(), DecisionAuth(), NewDecisionAPI(s.limiter).Acquire)
+	*decisionToken = "secret"
+}
+
+func (s *decisionSuite) TearDownTest() {
+	s.limiter.AssertExpectations(s.T())
+	*decisionToken = ""
+}
+
+func (s *decisionSuite) post(body string) (int, interface{}) {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/acquire", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer secret")
+	s.router.ServeHTTP(w, req)
+
+	var result interface{}
+	s.Require().NoError(json.Unmarshal(w.Body.Bytes(), &result))
+	return w.Code, result
+}
+
+func (s *decisionSuite) TestAcquire() {
+	denied := ratelimiter.Decision{
+		Decision: strategy.Decision{Allowed: false, Count: 11, Limit: 10, RetryAfter: 2 * time.Second},
+		Rule:     "api",
+		Level:    "user",
+		Key:      "bob",
+	}
+	s.limiter.On("AcquireN", "api", acquireRequest{Rule: "api", Key: "bob", Cost: 5}, 5).Return(denied, nil).Once()
+
+	code, result := s.post(`{"rule": "api", "key": "bob", "cost": 5}`)
+	s.Equal(http.StatusOK, code)
+	body := result.(map[string]interface{})
+	s.Equal(false, body["allowed"])
+	s.Equal("user", body["level"])
+	s.Equal(float64(2), body["retry_after_seconds"])
+	s.Equal("user limit of rule api exceeded", body["reason"])
+}
+
+func (s *decisionSuite) TestAcquireBatch() {
+	allowed := ratelimiter.Decision{Decision: strategy.Decision{Allowed: true, Count: 1, Limit: 10, Remaining: 9}, Rule: "api"}
+	s.limiter.On("AcquireN", "api", mock.Anything, 1).Return(allowed, nil).Once()
+	s.limiter.On("AcquireN", "not-exist", mock.Anything, 1).Return(ratelimiter.Decision{}, ratelimiter.ErrRuleNotFound).Once()
+	s.limiter.On("AcquireN", "broken", mock.Anything, 1).Return(ratelimiter.Decision{}, fmt.Errorf("redis down")).Once()
+
+	code, result := s.post(`[
+		{"rule": "api", "entries": {"tenant": "acme", "user": "bob"}},
+		{"rule": "not-exist", "key": "bob"},
+		{"rule": "broken", "key": "bob"}
+	]`)
+	s.Equal(http.StatusOK, code)
+	body := result.([]interface{})
+	s.Require().Len(body, 3)
+	s.Equal(true, body[0].(map[string]interface{})["allowed"])
+	s.Equal("rule not found", body[1].(map[string]interface{})["error"])
+	s.Equal("rate limiter unavailable", body[2].(map[string]interface{})["error"])
+}
+
+func (s *decisionSuite) TestAcquireError() {
+	s.limiter.On("AcquireN", "not-exist", mock.Anything, 1).Return(ratelimiter.Decision{}, ratelimiter.ErrRuleNotFound).Once()
+	s.limiter.On("AcquireN", "broken", mock.Anything, 1).Return(ratelimiter.Decision{}, fmt.Errorf("redis down")).Once()
+
+	tests := []struct {
+		Desc    string
+		Body    string
+		ExpCode int
+	}{
+		{
+			Desc:    "invalid json",
+			Body:    `{"rule": `,
+			ExpCode: http.StatusBadRequest,
+		},
+		{
+			Desc:    "no key",
+			Body:    `{"rule": "api"}`,
+			ExpCode: http.StatusBadRequest,
+		},
+		{
+			Desc:    "negative cost",
+			Body:    `{"rule": "api", "key": "bob", "cost": -1}`,
+			ExpCode: http.StatusBadRequest,
+		},
+		{
+			Desc:    "invalid request in batch",
+			Body:    `[{"rule": "api", "key": "bob"}, {"key": "bob"}]`,
+			ExpCode: http.StatusBadRequest,
+		},
+		{
+			Desc:    "empty batch",
+			Body:    `[]`,
+			ExpCode: http.StatusBadRequest,
+		},
+		{
+			Desc:    "rule not found",
+			Body:    `{"rule": "not-exist", "key": "bob"}`,
+			ExpCode: http.StatusNotFound,
+		},
+		{
+			Desc:    "limiter failed",
+			Body:    `{"rule": "broken", "key": "bob"}`,
+			ExpCode: http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, t := range tests {
+		code, _ := s.post(t.Body)
+		s.Equal(t.ExpCode, code, t.Desc)
+	}
+}
+
+func (s *decisionSuite) TestUnauthorized() {
+	for _, token := range []string{"", "Bearer wrong"} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/acquire", strings.NewReader(`{"rule": "api", "key": "bob"}`))
+		req.Header.Set("Authorization", token)
+		s.router.ServeHTTP(w, req)
+		s.Equal(http.StatusUnauthorized, w.Code, token)
+	}
+
+	// disabled without the token
+	*decisionToken = ""
+	code, _ := s.post(`{"rule": "api", "key": "bob"}`)
+	s.Equal(http.StatusUnauthorized, code)
+}
+
+func (s *decisionSuite) TestAcquireRequestValue() {
+	req := acquireRequest{Key: "bob", Entries: map[string]string{"tenant": "acme"}}
+
+	value, ok := req.Value("tenant")
+	s.True(ok)
+	s.Equal("acme", value)
+	value, ok = req.Value("user")
+	s.True(ok)
+	s.Equal("bob", value)
+
+	_, ok = acquireRequest{Entries: map[string]string{"tenant": "acme"}}.Value("user")
+	s.False(ok)
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	gocontext "context"
+	"math"
+	"strconv"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	ratelimitv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/common/ratelimit/v3"
+	rlsv3 "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/chihkaiyu/ratelimiter/service/ratelimiter"
+)
+
+// EnvoyServer serves the rate limiter by the external rate limit service protocol of Envoy
+type EnvoyServer struct {
+	rlsv3.UnimplementedRateLimitServiceServer
+	limiter ratelimiter.Limiter
+}
+
+func NewEnvoyServer(limiter ratelimiter.Limiter) *EnvoyServer {
+	return &EnvoyServer{
+		limiter: limiter,
+	}
+}
+
+// ShouldRateLimit acquires the permits of every descriptor from the rule named by the domain.
+// Like the reference implementation of Envoy, the requests of unknown domains are not limited.
+func (s *EnvoyServer) ShouldRateLimit(c gocontext.Context, req *rlsv3.RateLimitRequest) (*rlsv3.RateLimitResponse, error) {
+	context := grpcContext(c, "envoy.ShouldRateLimit")
+	if len(req.Descriptors) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "descriptors are required")
+	}
+
+	hits := int(req.HitsAddend)
+	if hits == 0 {
+		hits = 1
+	}
+
+	resp := &rlsv3.RateLimitResponse{OverallCode: rlsv3.RateLimitResponse_OK}
+	var denied *ratelimiter.Decision
+	for _, d := range req.Descriptors {
+		decision, err := s.limiter.AcquireN(context, req.Domain, envoyDescriptorOf(d), hits)
+		if err == ratelimiter.ErrRuleNotFound {
+			resp.Statuses = append(resp.Statuses, &rlsv3.RateLimitResponse_DescriptorStatus{Code: rlsv3.RateLimitResponse_OK})
+			continue
+		}
+		if err != nil {
+			context.WithFields(logrus.Fields{
+				"err":    err,
+				"domain": req.Domain,
+			}).Error("limiter.AcquireN failed")
+			return nil, status.Error(codes.Unavailable, "rate limiter unavailable")
+		}
+
+		resp.Statuses = append(resp.Statuses, descriptorStatusOf(decision))
+		if !decision.Allowed && (denied == nil || decision.RetryAfter > denied.RetryAfter) {
+			denied = &decision
+		}
+	}
+
+	if denied != nil {
+		resp.OverallCode = rlsv3.RateLimitResponse_OVER_LIMIT
+		resp.ResponseHeadersToAdd = []*corev3.HeaderValue{
+			{Key: "X-RateLimit-Reason", Value: denied.Reason()},
+			{Key: "Retry-After", Value: strconv.Itoa(int(math.Ceil(denied.RetryAfter.Seconds())))},
+		}
+	}
+
+	return resp, nil
+}
+
+func envoyDescriptorOf(d *ratelimitv3.RateLimitDescriptor) ratelimiter.Entries {
+	entries := ratelimiter.Entries{}
+	for _, entry := range d.Entries {
+		entries[entry.Key] = entry.Value
+	}
+
+	return entries
+}
+
+// descriptorStatusOf returns the status of the level which made the decision, the duration until
+// reset is the time to wait for the next permit if it's denied
+func descriptorStatusOf(d ratelimiter.Decision) *rlsv3.RateLimitResponse_DescriptorStatus {
+	st := &rlsv3.RateLimitResponse_DescriptorStatus{Code: rlsv3.RateLimitResponse_OK}
+	if !d.Allowed {
+		st.Code = rlsv3.RateLimitResponse_OVER_LIMIT
+	}
+	// no level applies to the descriptor
+	if d.Level == "" {
+		return st
+	}
+
+	// the strategies are not limited by a unit of time, e.g. token bucket, so the unit is unknown
+	st.CurrentLimit = &rlsv3.RateLimitResponse_RateLimit{
+		Name:            d.Rule + "." + d.Level,
+		RequestsPerUnit: uint32(d.Limit),
+	}
+	st.LimitRemaining = uint32(d.Remaining)
+	st.DurationUntilReset = durationpb.New(d.ResetAfter)
+	if !d.Allowed {
+		st.DurationUntilReset = durationpb.New(d.RetryAfter)
+	}
+
+	return st
+}
@@ -0,0 +1,192 @@
+package api
+
+import (
+	gocontext "context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	ratelimitv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/common/ratelimit/v3"
+	rlsv3 "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/chihkaiyu/ratelimiter/service/ratelimiter"
+	"github.com/chihkaiyu/ratelimiter/service/ratelimiter/strategy"
+)
+
+type envoySuite struct {
+	suite.Suite
+	limiter *mockLimiter
+	server  *grpc.Server
+	conn    *grpc.ClientConn
+	client  rlsv3.RateLimitServiceClient
+}
+
+func TestEnvoySuite(t *testing.T) {
+	suite.Run(t, new(envoySuite))
+}
+
+func (s *envoySuite) SetupTest() {
+	s.limiter = new(mockLimiter)
+	s.server = grpc.NewServer(grpc.UnaryInterceptor(DecisionAuthInterceptor()))
+	rlsv3.RegisterRateLimitServiceServer(s.server, NewEnvoyServer(s.limiter))
+
+	lis := bufconn.Listen(1024 * 1024)
+	go s.server.Serve(lis)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(gocontext.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithInsecure(),
+	)
+	s.Require().NoError(err)
+	s.conn = conn
+	s.client = rlsv3.NewRateLimitServiceClient(conn)
+	*decisionToken = "secret"
+}
+
+func (s *envoySuite) TearDownTest() {
+	s.limiter.AssertExpectations(s.T())
+	s.conn.Close()
+	s.server.Stop()
+	*decisionToken = ""
+}
+
+func envoyDescriptor(key, value string) *ratelimitv3.RateLimitDescriptor {
+	return &ratelimitv3.RateLimitDescriptor{
+		Entries: []*ratelimitv3.RateLimitDescriptor_Entry{{Key: key, Value: value}},
+	}
+}
+
+func (s *envoySuite) TestShouldRateLimit() {
+	allowed := ratelimiter.Decision{
+		Decision: strategy.Decision{Allowed: true, Count: 3, Limit: 10, Remaining: 7, ResetAfter: 30 * time.Second},
+		Rule:     "api",
+		Level:    "remote_address",
+		Key:      "10.0.0.1",
+	}
+	denied := ratelimiter.Decision{
+		Decision: strategy.Decision{Allowed: false, Count: 101, Limit: 100, RetryAfter: 1500 * time.Millisecond},
+		Rule:     "api",
+		Level:    "tenant",
+		Key:      "acme",
+	}
+
+	tests := []struct {
+		Desc      string
+		SetupTest func()
+		Req       *rlsv3.RateLimitRequest
+		Exp       *rlsv3.RateLimitResponse
+	}{
+		{
+			Desc: "all allowed",
+			SetupTest: func() {
+				s.limiter.On("AcquireN", "api", ratelimiter.Entries{"remote_address": "10.0.0.1"}, 1).Return(allowed, nil).Once()
+			},
+			Req: &rlsv3.RateLimitRequest{
+				Domain:      "api",
+				Descriptors: []*ratelimitv3.RateLimitDescriptor{envoyDescriptor("remote_address", "10.0.0.1")},
+			},
+			Exp: &rlsv3.RateLimitResponse{
+				OverallCode: rlsv3.RateLimitResponse_OK,
+				Statuses: []*rlsv3.RateLimitResponse_DescriptorStatus{
+					{
+						Code:               rlsv3.RateLimitResponse_OK,
+						CurrentLimit:       &rlsv3.RateLimitResponse_RateLimit{Name: "api.remote_address", RequestsPerUnit: 10},
+						LimitRemaining:     7,
+						DurationUntilReset: durationpb.New(30 * time.Second),
+					},
+				},
+			},
+		},
+		{
+			Desc: "over limit with hits",
+			SetupTest: func() {
+				s.limiter.On("AcquireN", "api", ratelimiter.Entries{"remote_address": "10.0.0.1"}, 5).Return(allowed, nil).Once()
+				s.limiter.On("AcquireN", "api", ratelimiter.Entries{"tenant": "acme"}, 5).Return(denied, nil).Once()
+			},
+			Req: &rlsv3.RateLimitRequest{
+				Domain: "api",
+				Descriptors: []*ratelimitv3.RateLimitDescriptor{
+					envoyDescriptor("remote_address", "10.0.0.1"),
+					envoyDescriptor("tenant", "acme"),
+				},
+				HitsAddend: 5,
+			},
+			Exp: &rlsv3.RateLimitResponse{
+				OverallCode: rlsv3.RateLimitResponse_OVER_LIMIT,
+				Statuses: []*rlsv3.RateLimitResponse_DescriptorStatus{
+					{
+						Code:               rlsv3.RateLimitResponse_OK,
+						CurrentLimit:       &rlsv3.RateLimitResponse_RateLimit{Name: "api.remote_address", RequestsPerUnit: 10},
+						LimitRemaining:     7,
+						DurationUntilReset: durationpb.New(30 * time.Second),
+					},
+					{
+						Code:               rlsv3.RateLimitResponse_OVER_LIMIT,
+						CurrentLimit:       &rlsv3.RateLimitResponse_RateLimit{Name: "api.tenant", RequestsPerUnit: 100},
+						DurationUntilReset: durationpb.New(1500 * time.Millisecond),
+					},
+				},
+				ResponseHeadersToAdd: []*corev3.HeaderValue{
+					{Key: "X-RateLimit-Reason", Value: "tenant limit of rule api exceeded"},
+					{Key: "Retry-After", Value: "2"},
+				},
+			},
+		},
+		{
+			Desc: "unknown domain isn't limited",
+			SetupTest: func() {
+				s.limiter.On("AcquireN", "not-exist", mock.Anything, 1).Return(ratelimiter.Decision{}, ratelimiter.ErrRuleNotFound).Once()
+			},
+			Req: &rlsv3.RateLimitRequest{
+				Domain:      "not-exist",
+				Descriptors: []*ratelimitv3.RateLimitDescriptor{envoyDescriptor("remote_address", "10.0.0.1")},
+			},
+			Exp: &rlsv3.RateLimitResponse{
+				OverallCode: rlsv3.RateLimitResponse_OK,
+				Statuses:    []*rlsv3.RateLimitResponse_DescriptorStatus{{Code: rlsv3.RateLimitResponse_OK}},
+			},
+		},
+	}
+
+	for _, t := range tests {
+		t.SetupTest()
+
+		act, err := s.client.ShouldRateLimit(authorized("secret"), t.Req)
+		s.Require().NoError(err, t.Desc)
+		s.Equal(t.Exp.String(), act.String(), t.Desc)
+	}
+}
+
+func (s *envoySuite) TestShouldRateLimitError() {
+	s.limiter.On("AcquireN", "api", mock.Anything, 1).Return(ratelimiter.Decision{}, fmt.Errorf("redis down")).Once()
+
+	_, err := s.client.ShouldRateLimit(authorized("secret"), &rlsv3.RateLimitRequest{
+		Domain:      "api",
+		Descriptors: []*ratelimitv3.RateLimitDescriptor{envoyDescriptor("remote_address", "10.0.0.1")},
+	})
+	s.Equal(codes.Unavailable, status.Code(err))
+
+	_, err = s.client.ShouldRateLimit(authorized("secret"), &rlsv3.RateLimitRequest{Domain: "api"})
+	s.Equal(codes.InvalidArgument, status.Code(err))
+}
+
+func (s *envoySuite) TestUnauthenticated() {
+	req := &rlsv3.RateLimitRequest{
+		Domain:      "api",
+		Descriptors: []*ratelimitv3.RateLimitDescriptor{envoyDescriptor("remote_address", "10.0.0.1")},
+	}
+
+	_, err := s.client.ShouldRateLimit(gocontext.Background(), req)
+	s.Equal(codes.Unauthenticated, status.Code(err), "no token")
+	_, err = s.client.ShouldRateLimit(authorized("wrong"), req)
+	s.Equal(codes.Unauthenticated, status.Code(err), "wrong token")
+}
@@ -0,0 +1,158 @@
+package api
+
+import (
+	gocontext "context"
+	"crypto/subtle"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/chihkaiyu/ratelimiter/api/pb"
+	"github.com/chihkaiyu/ratelimiter/base/ctx"
+	"github.com/chihkaiyu/ratelimiter/service/ratelimiter"
+)
+
+// RateLimitServer serves the rate limiter over gRPC for the services not embedding it
+type RateLimitServer struct {
+	pb.UnimplementedRateLimiterServer
+	limiter ratelimiter.Limiter
+}
+
+func NewRateLimitServer(limiter ratelimiter.Limiter) *RateLimitServer {
+	return &RateLimitServer{
+		limiter: limiter,
+	}
+}
+
+// DecisionAuthInterceptor authenticates the gRPC calls by the bearer token in the metadata
+// authorization like DecisionAuth, all calls are rejected if decision_token is empty
+func DecisionAuthInterceptor() grpc.UnaryServerInterceptor {
+	return func(c gocontext.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		md, _ := metadata.FromIncomingContext(c)
+		token := strings.TrimPrefix(metadataCarrier(md).Get("authorization"), "Bearer ")
+		if *decisionToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(*decisionToken)) != 1 {
+			return nil, status.Error(codes.Unauthenticated, "unauthorized")
+		}
+
+		return handler(c, req)
+	}
+}
+
+// ShouldRateLimit acquires the permits of every descriptor from the rule named by the domain
+func (s *RateLimitServer) ShouldRateLimit(c gocontext.Context, req *pb.ShouldRateLimitRequest) (*pb.ShouldRateLimitResponse, error) {
+	context := grpcContext(c, "ShouldRateLimit")
+	if req.Domain == "" || len(req.Descriptors) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "domain and descriptors are required")
+	}
+
+	hits := int(req.Hits)
+	if hits == 0 {
+		hits = 1
+	}
+
+	resp := &pb.ShouldRateLimitResponse{Allowed: true}
+	// the permits of the allowed descriptors are given back if another descriptor is denied
+	allowed := []permit{}
+	for _, d := range req.Descriptors {
+		descriptor := descriptorOf(d)
+		decision, err := s.limiter.AcquireN(context, req.Domain, descriptor, hits)
+		if err == ratelimiter.ErrRuleNotFound {
+			return nil, status.Error(codes.NotFound, err.Error())
+		}
+		if err != nil {
+			context.WithFields(logrus.Fields{
+				"err":    err,
+				"domain": req.Domain,
+			}).Error("limiter.AcquireN failed")
+			return nil, status.Error(codes.Unavailable, "rate limiter unavailable")
+		}
+
+		if decision.Allowed && !decision.Shadow {
+			allowed = append(allowed, permit{rule: req.Domain, descriptor: descriptor, n: hits})
+		}
+		resp.Allowed = resp.Allowed && decision.Allowed
+		resp.Decisions = append(resp.Decisions, decisionOf(decision))
+	}
+
+	if !resp.Allowed {
+		release(context, s.limiter, allowed)
+	}
+
+	return resp, nil
+}
+
+func descriptorOf(d *pb.Descriptor) ratelimiter.Entries {
+	entries := ratelimiter.Entries{}
+	for _, entry := range d.Entries {
+		entries[entry.Key] = entry.Value
+	}
+
+	return entries
+}
+
+func decisionOf(d ratelimiter.Decision) *pb.Decision {
+	return &pb.Decision{
+		Allowed:    d.Allowed,
+		WouldBlock: d.WouldBlock,
+		Level:      d.Level,
+		Strategy:   d.Strategy,
+		Key:        d.Key,
+		Count:      int64(d.Count),
+		Limit:      int64(d.Limit),
+		Remaining:  int64(d.Remaining),
+		RetryAfter: durationpb.New(d.RetryAfter),
+		ResetAfter: durationpb.New(d.ResetAfter),
+		Reason:     d.Reason(),
+	}
+}
+
+// metadataCarrier adapts gRPC metadata to the carrier of trace propagation
+type metadataCarrier metadata.MD
+
+func (c metadataCarrier) Get(key string) string {
+	values := metadata.MD(c).Get(key)
+	if len(values) == 0 {
+		return ""
+	}
+
+	return values[0]
+}
+
+func (c metadataCarrier) Set(key, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+// grpcContext returns the context of the gRPC request like AddContext, the request ID is taken
+// from the metadata x-request-id, or generated if there is none
+func grpcContext(c gocontext.Context, method string) ctx.CTX {
+	context, _ := grpcRequestContext(c, method)
+	return context
+}
+
+// grpcRequestContext returns the context of grpcContext and the request ID
+func grpcRequestContext(c gocontext.Context, method string) (ctx.CTX, string) {
+	md, _ := metadata.FromIncomingContext(c)
+	requestID := metadataCarrier(md).Get(headerRequestID)
+	if !validRequestID(requestID) {
+		requestID = newRequestID()
+	}
+
+	client := ""
+	if p, ok := peer.FromContext(c); ok {
+		client = p.Addr.String()
+	}
+
+	parent := otel.GetTextMapPropagator().Extract(c, metadataCarrier(md))
+	return ctx.WithContext(parent, logrus.Fields{
+		"request_id": requestID,
+		"client":     client,
+		"method":     method,
+	}), requestID
+}
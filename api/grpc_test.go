@@ -0,0 +1,171 @@
+package api
+
+import (
+	gocontext "context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/chihkaiyu/ratelimiter/api/pb"
+	"github.com/chihkaiyu/ratelimiter/base/ctx"
+	"github.com/chihkaiyu/ratelimiter/service/ratelimiter"
+	"github.com/chihkaiyu/ratelimiter/service/ratelimiter/strategy"
+)
+
+type mockLimiter struct {
+	// the methods not mocked panic
+	ratelimiter.Limiter
+	mock.Mock
+}
+
+func (m *mockLimiter) Acquire(context ctx.CTX, rule string, descriptor ratelimiter.Descriptor) (ratelimiter.Decision, error) {
+	return m.AcquireN(context, rule, descriptor, 1)
+}
+
+func (m *mockLimiter) Release(context ctx.CTX, rule string, descriptor ratelimiter.Descriptor, n int) error {
+	args := m.Called(rule, n)
+	return args.Error(0)
+}
+
+func (m *mockLimiter) AcquireN(context ctx.CTX, rule string, descriptor ratelimiter.Descriptor, n int) (ratelimiter.Decision, error) {
+	args := m.Called(rule, descriptor, n)
+	return args.Get(0).(ratelimiter.Decision), args.Error(1)
+}
+
+type grpcSuite struct {
+	suite.Suite
+	limiter *mockLimiter
+	server  *grpc.Server
+	conn    *grpc.ClientConn
+	client  pb.RateLimiterClient
+}
+
+func TestGRPCSuite(t *testing.T) {
+	suite.Run(t, new(grpcSuite))
+}
+
+func (s *grpcSuite) SetupTest() {
+	s.limiter = new(mockLimiter)
+	s.server = grpc.NewServer(grpc.UnaryInterceptor(DecisionAuthInterceptor()))
+	pb.RegisterRateLimiterServer(s.server, NewRateLimitServer(s.limiter))
+
+	lis := bufconn.Listen(1024 * 1024)
+	go s.server.Serve(lis)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(gocontext.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithInsecure(),
+	)
+	s.Require().NoError(err)
+	s.conn = conn
+	s.client = pb.NewRateLimiterClient(conn)
+	*decisionToken = "secret"
+}
+
+func (s *grpcSuite) TearDownTest() {
+	s.limiter.AssertExpectations(s.T())
+	s.conn.Close()
+	s.server.Stop()
+	*decisionToken = ""
+}
+
+// authorized returns the context of the calls with the decision token
+func authorized(token string) gocontext.Context {
+	return metadata.AppendToOutgoingContext(gocontext.Background(), "authorization", "Bearer "+token)
+}
+
+func (s *grpcSuite) TestShouldRateLimit() {
+	allowed := ratelimiter.Decision{
+		Decision: strategy.Decision{Allowed: true, Count: 3, Limit: 10, Remaining: 7, ResetAfter: time.Second},
+		Rule:     "api",
+		Level:    "user",
+		Key:      "bob",
+	}
+	denied := ratelimiter.Decision{
+		Decision: strategy.Decision{Allowed: false, Count: 11, Limit: 10, RetryAfter: 2 * time.Second},
+		Rule:     "api",
+		Level:    "user",
+		Key:      "alice",
+	}
+	s.limiter.On("AcquireN", "api", ratelimiter.Entries{"user": "bob"}, 3).Return(allowed, nil).Once()
+	s.limiter.On("AcquireN", "api", ratelimiter.Entries{"user": "alice"}, 3).Return(denied, nil).Once()
+	// the permits of bob are given back since alice is denied
+	s.limiter.On("Release", "api", 3).Return(nil).Once()
+
+	resp, err := s.client.ShouldRateLimit(authorized("secret"), &pb.ShouldRateLimitRequest{
+		Domain: "api",
+		Descriptors: []*pb.Descriptor{
+			{Entries: []*pb.Descriptor_Entry{{Key: "user", Value: "bob"}}},
+			{Entries: []*pb.Descriptor_Entry{{Key: "user", Value: "alice"}}},
+		},
+		Hits: 3,
+	})
+	s.Require().NoError(err)
+	s.False(resp.Allowed)
+	s.Require().Len(resp.Decisions, 2)
+	s.True(resp.Decisions[0].Allowed)
+	s.Equal(int64(7), resp.Decisions[0].Remaining)
+	s.Equal(time.Second, resp.Decisions[0].ResetAfter.AsDuration())
+	s.False(resp.Decisions[1].Allowed)
+	s.Equal(2*time.Second, resp.Decisions[1].RetryAfter.AsDuration())
+	s.Equal("user limit of rule api exceeded", resp.Decisions[1].Reason)
+}
+
+func (s *grpcSuite) TestShouldRateLimitError() {
+	descriptors := []*pb.Descriptor{{Entries: []*pb.Descriptor_Entry{{Key: "user", Value: "bob"}}}}
+	s.limiter.On("AcquireN", "not-exist", mock.Anything, 1).Return(ratelimiter.Decision{}, ratelimiter.ErrRuleNotFound).Once()
+	s.limiter.On("AcquireN", "api", mock.Anything, 1).Return(ratelimiter.Decision{}, fmt.Errorf("redis down")).Once()
+
+	tests := []struct {
+		Desc    string
+		Req     *pb.ShouldRateLimitRequest
+		ExpCode codes.Code
+	}{
+		{
+			Desc:    "no descriptor",
+			Req:     &pb.ShouldRateLimitRequest{Domain: "api"},
+			ExpCode: codes.InvalidArgument,
+		},
+		{
+			Desc:    "rule not found",
+			Req:     &pb.ShouldRateLimitRequest{Domain: "not-exist", Descriptors: descriptors},
+			ExpCode: codes.NotFound,
+		},
+		{
+			Desc:    "limiter failed",
+			Req:     &pb.ShouldRateLimitRequest{Domain: "api", Descriptors: descriptors},
+			ExpCode: codes.Unavailable,
+		},
+	}
+
+	for _, t := range tests {
+		_, err := s.client.ShouldRateLimit(authorized("secret"), t.Req)
+		s.Equal(t.ExpCode, status.Code(err), t.Desc)
+	}
+}
+
+func (s *grpcSuite) TestUnauthenticated() {
+	req := &pb.ShouldRateLimitRequest{
+		Domain:      "api",
+		Descriptors: []*pb.Descriptor{{Entries: []*pb.Descriptor_Entry{{Key: "user", Value: "bob"}}}},
+	}
+
+	_, err := s.client.ShouldRateLimit(gocontext.Background(), req)
+	s.Equal(codes.Unauthenticated, status.Code(err), "no token")
+	_, err = s.client.ShouldRateLimit(authorized("wrong"), req)
+	s.Equal(codes.Unauthenticated, status.Code(err), "wrong token")
+
+	// all calls are rejected without decision_token
+	*decisionToken = ""
+	_, err = s.client.ShouldRateLimit(authorized(""), req)
+	s.Equal(codes.Unauthenticated, status.Code(err), "disabled")
+}
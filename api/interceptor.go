@@ -0,0 +1,121 @@
+package api
+
+import (
+	gocontext "context"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// grpcDescriptor describes the gRPC call by "ip" of the peer, "method" or "header:<name>" of the metadata,
+// so the rules of HTTP APIs keyed by headers, e.g. the API key, work for gRPC too
+type grpcDescriptor struct {
+	ip     string
+	method string
+	md     metadata.MD
+}
+
+func (d grpcDescriptor) Value(key string) (string, bool) {
+	switch {
+	case key == "ip":
+		return d.ip, true
+	case key == "method":
+		return d.method, true
+	case strings.HasPrefix(key, headerKeyPrefix):
+		value := metadataCarrier(d.md).Get(strings.TrimPrefix(key, headerKeyPrefix))
+		return value, value != ""
+	}
+
+	return "", false
+}
+
+// UnaryServerInterceptor rate limits the unary calls by given rules like Acquire, rejected calls
+// fail with RESOURCE_EXHAUSTED and the retry info in the status details
+func (rl *RateLimiter) UnaryServerInterceptor(rules ...string) grpc.UnaryServerInterceptor {
+	return func(c gocontext.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := rl.limitCall(c, info.FullMethod, rules); err != nil {
+			return nil, err
+		}
+
+		return handler(c, req)
+	}
+}
+
+// StreamServerInterceptor rate limits the streams like UnaryServerInterceptor, a stream acquires
+// once when it's opened no matter how many messages it sends
+func (rl *RateLimiter) StreamServerInterceptor(rules ...string) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := rl.limitCall(ss.Context(), info.FullMethod, rules); err != nil {
+			return err
+		}
+
+		return handler(srv, ss)
+	}
+}
+
+// limitCall returns the status error if the call is rejected
+func (rl *RateLimiter) limitCall(c gocontext.Context, method string, rules []string) error {
+	context, requestID := grpcRequestContext(c, method)
+	md, _ := metadata.FromIncomingContext(c)
+	descriptor := grpcDescriptor{ip: peerIP(c), method: method, md: md}
+
+	decision, banned, err := rl.check(context, descriptor.ip, requestID, descriptor, rules)
+	if err != nil && *failurePolicy == FailureOpen {
+		context.WithField("err", err).Warn("rate limiter failed, request allowed by failure policy")
+		return nil
+	}
+
+	if err != nil {
+		return status.Error(codes.Unavailable, "rate limiter unavailable")
+	}
+
+	if banned > 0 {
+		return exhaustedError(reasonBanned, banned)
+	}
+
+	if !decision.Allowed {
+		return exhaustedError(decision.Reason(), decision.RetryAfter, &errdetails.QuotaFailure{
+			Violations: []*errdetails.QuotaFailure_Violation{{
+				Subject:     decision.Rule + "." + decision.Level,
+				Description: decision.Reason(),
+			}},
+		})
+	}
+
+	return nil
+}
+
+// exhaustedError returns RESOURCE_EXHAUSTED with the time to wait before retrying in RetryInfo
+func exhaustedError(message string, retryAfter time.Duration, details ...proto.Message) error {
+	details = append([]proto.Message{&errdetails.RetryInfo{RetryDelay: durationpb.New(retryAfter)}}, details...)
+	st, err := status.New(codes.ResourceExhausted, message).WithDetails(details...)
+	if err != nil {
+		return status.Error(codes.ResourceExhausted, message)
+	}
+
+	return st.Err()
+}
+
+// peerIP returns the IP of the peer, or its address if it has no port, e.g. unix sockets
+func peerIP(c gocontext.Context) string {
+	p, ok := peer.FromContext(c)
+	if !ok {
+		return ""
+	}
+
+	addr := p.Addr.String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+
+	return addr
+}
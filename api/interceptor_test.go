@@ -0,0 +1,131 @@
+package api
+
+import (
+	gocontext "context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/chihkaiyu/ratelimiter/service/ratelimiter"
+	"github.com/chihkaiyu/ratelimiter/service/ratelimiter/strategy"
+)
+
+type interceptorSuite struct {
+	suite.Suite
+	limiter *mockLimiter
+	server  *grpc.Server
+	conn    *grpc.ClientConn
+	client  healthpb.HealthClient
+}
+
+func TestInterceptorSuite(t *testing.T) {
+	suite.Run(t, new(interceptorSuite))
+}
+
+func (s *interceptorSuite) SetupTest() {
+	s.limiter = new(mockLimiter)
+	rl := NewRateLimiter(s.limiter, nil, 0)
+	s.server = grpc.NewServer(
+		grpc.UnaryInterceptor(rl.UnaryServerInterceptor("api")),
+		grpc.StreamInterceptor(rl.StreamServerInterceptor("api")),
+	)
+	healthpb.RegisterHealthServer(s.server, health.NewServer())
+
+	lis := bufconn.Listen(1024 * 1024)
+	go s.server.Serve(lis)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(gocontext.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithInsecure(),
+	)
+	s.Require().NoError(err)
+	s.conn = conn
+	s.client = healthpb.NewHealthClient(conn)
+}
+
+func (s *interceptorSuite) TearDownTest() {
+	s.limiter.AssertExpectations(s.T())
+	s.conn.Close()
+	s.server.Stop()
+}
+
+func (s *interceptorSuite) TestUnaryAllowed() {
+	allowed := ratelimiter.Decision{Decision: strategy.Decision{Allowed: true, Count: 1, Limit: 10, Remaining: 9}, Rule: "api"}
+	s.limiter.On("AcquireN", "api", mock.Anything, 1).Return(allowed, nil).Once()
+
+	c := metadata.AppendToOutgoingContext(gocontext.Background(), "x-api-key", "secret")
+	_, err := s.client.Check(c, &healthpb.HealthCheckRequest{})
+	s.Require().NoError(err)
+
+	descriptor := s.limiter.Calls[0].Arguments.Get(1).(ratelimiter.Descriptor)
+	method, _ := descriptor.Value("method")
+	s.Equal("/grpc.health.v1.Health/Check", method)
+	apiKey, ok := descriptor.Value("header:X-API-Key")
+	s.True(ok)
+	s.Equal("secret", apiKey)
+	ip, _ := descriptor.Value("ip")
+	s.Equal("bufconn", ip)
+	_, ok = descriptor.Value("header:X-Tenant-ID")
+	s.False(ok)
+}
+
+func (s *interceptorSuite) TestUnaryRejected() {
+	denied := ratelimiter.Decision{
+		Decision: strategy.Decision{Allowed: false, Count: 11, Limit: 10, RetryAfter: 2 * time.Second},
+		Rule:     "api",
+		Level:    "method",
+		Key:      "/grpc.health.v1.Health/Check",
+	}
+	s.limiter.On("AcquireN", "api", mock.Anything, 1).Return(denied, nil).Once()
+
+	_, err := s.client.Check(gocontext.Background(), &healthpb.HealthCheckRequest{})
+	st := status.Convert(err)
+	s.Equal(codes.ResourceExhausted, st.Code())
+	s.Equal("method limit of rule api exceeded", st.Message())
+	s.Require().Len(st.Details(), 2)
+	s.Equal(2*time.Second, st.Details()[0].(*errdetails.RetryInfo).RetryDelay.AsDuration())
+	s.Equal("api.method", st.Details()[1].(*errdetails.QuotaFailure).Violations[0].Subject)
+}
+
+func (s *interceptorSuite) TestUnaryLimiterFailed() {
+	s.limiter.On("AcquireN", "api", mock.Anything, 1).Return(ratelimiter.Decision{}, fmt.Errorf("redis down")).Once()
+
+	_, err := s.client.Check(gocontext.Background(), &healthpb.HealthCheckRequest{})
+	s.Equal(codes.Unavailable, status.Code(err))
+}
+
+func (s *interceptorSuite) TestStream() {
+	allowed := ratelimiter.Decision{Decision: strategy.Decision{Allowed: true, Count: 1, Limit: 1}, Rule: "api"}
+	denied := ratelimiter.Decision{Decision: strategy.Decision{Allowed: false, Count: 2, Limit: 1, RetryAfter: time.Second}, Rule: "api", Level: "ip"}
+	s.limiter.On("AcquireN", "api", mock.Anything, 1).Return(allowed, nil).Once()
+	s.limiter.On("AcquireN", "api", mock.Anything, 1).Return(denied, nil).Once()
+
+	c, cancel := gocontext.WithCancel(gocontext.Background())
+	defer cancel()
+	stream, err := s.client.Watch(c, &healthpb.HealthCheckRequest{})
+	s.Require().NoError(err)
+	resp, err := stream.Recv()
+	s.Require().NoError(err)
+	s.Equal(healthpb.HealthCheckResponse_SERVING, resp.Status)
+
+	stream, err = s.client.Watch(c, &healthpb.HealthCheckRequest{})
+	s.Require().NoError(err)
+	_, err = stream.Recv()
+	s.Equal(codes.ResourceExhausted, status.Code(err))
+
+	descriptor := s.limiter.Calls[0].Arguments.Get(1).(ratelimiter.Descriptor)
+	method, _ := descriptor.Value("method")
+	s.Equal("/grpc.health.v1.Health/Watch", method)
+}
@@ -0,0 +1,14 @@
+package api
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	limiterFailing = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "ratelimiter",
+		Name:      "limiter_failing",
+		Help:      "1 if the last decision of the middlewares failed and the failure policy applied, 0 otherwise.",
+	})
+)
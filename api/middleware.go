@@ -1,19 +1,27 @@
 package api
 
 import (
+	gocontext "context"
+	"crypto/rand"
+	"encoding/hex"
 	"flag"
+	"net"
 	"net/http"
 	"regexp"
 	"strings"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
 
 	"github.com/chihkaiyu/ratelimiter/base/ctx"
+	"github.com/chihkaiyu/ratelimiter/service/accesslist"
 )
 
 var (
-	env = flag.String("env", "", "dev")
+	env          = flag.String("env", "", "dev")
+	apiKeyHeader = flag.String("api_key_header", "X-API-Key", "the header of API key")
 )
 
 // Cors sets required headers for cors and clients
@@ -39,18 +47,165 @@ func Cors() gin.HandlerFunc {
 	}
 }
 
-// AddContext adds context into gin
+// contextKey is the key of the values in the context of net/http requests
+type contextKey int
+
+const (
+	ctxKey contextKey = iota
+	requestIDKey
+	allowlistedKey
+)
+
+// ContextOf returns the context of the request added by Handler, or the background context if there is none
+func ContextOf(r *http.Request) ctx.CTX {
+	if context, ok := r.Context().Value(ctxKey).(ctx.CTX); ok {
+		return context
+	}
+
+	return ctx.Background()
+}
+
+// RequestIDOf returns the request ID added by Handler, it's empty if there is none
+func RequestIDOf(r *http.Request) string {
+	requestID, _ := r.Context().Value(requestIDKey).(string)
+	return requestID
+}
+
+// withContext returns the request carrying the context and the request ID for the handlers after Handler
+func withContext(r *http.Request, context ctx.CTX, requestID string) *http.Request {
+	c := gocontext.WithValue(context.Context, requestIDKey, requestID)
+	c = gocontext.WithValue(c, ctxKey, ctx.CTX{Context: c, FieldLogger: context.FieldLogger})
+	return r.WithContext(c)
+}
+
+// allowlisted returns true if the request is exempted by AccessListHandler
+func allowlisted(r *http.Request) bool {
+	allowlisted, _ := r.Context().Value(allowlistedKey).(bool)
+	return allowlisted
+}
+
+const (
+	headerRequestID = "X-Request-ID"
+	// maxRequestIDLength is the maximum length of the request ID taken from the client
+	maxRequestIDLength = 128
+)
+
+// AddContext adds the context of the request into gin. The context is canceled when the client
+// goes away, carries the trace propagated by the client and logs with the request ID, client
+// and route. The request ID is taken from X-Request-ID, or generated if there is none or it's invalid.
 func AddContext() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		c.Set("ctx", ctx.Background())
+		context, requestID := requestContext(c.Writer, c.Request, clientIP(c), c.FullPath())
+		c.Set("requestID", requestID)
+		c.Set("ctx", context)
 		c.Next()
 	}
 }
 
-// SetClientIP sets client IP from request header
+// requestContext returns the context of the request and its request ID for AddContext and
+// the middlewares without gin, the request ID is also set to the response header
+func requestContext(w http.ResponseWriter, r *http.Request, client, route string) (ctx.CTX, string) {
+	requestID := r.Header.Get(headerRequestID)
+	if !validRequestID(requestID) {
+		requestID = newRequestID()
+	}
+	w.Header().Set(headerRequestID, requestID)
+
+	parent := otel.GetTextMapPropagator().Extract(r.Context(), r.Header)
+	return ctx.WithContext(parent, logrus.Fields{
+		"request_id": requestID,
+		"client":     client,
+		"route":      route,
+	}), requestID
+}
+
+// SetClientIP sets client IP from the remote address. The header true-client-ip is replaced for the
+// handlers reading it, so it can't be spoofed by the client.
 func SetClientIP() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		remoteIP := strings.Split(c.Request.RemoteAddr, ":")[0]
-		c.Request.Header.Add("true-client-ip", remoteIP)
+		ip := remoteIP(c.Request)
+		c.Set("clientIP", ip)
+		c.Request.Header.Set("true-client-ip", ip)
+	}
+}
+
+// clientIP returns the IP set by SetClientIP, or the remote IP if it's not set yet
+func clientIP(c *gin.Context) string {
+	if ip := c.GetString("clientIP"); ip != "" {
+		return ip
+	}
+
+	return remoteIP(c.Request)
+}
+
+// remoteIP returns the IP of the remote address, e.g. "::1" of "[::1]:8080"
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		// the address without port
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// validRequestID returns true if the request ID from the client is at most 128 printable ASCII
+// characters, so it can't flood the logs or inject control characters into them and the headers
+func validRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(requestID); i++ {
+		if requestID[i] < ' ' || requestID[i] > '~' {
+			return false
+		}
+	}
+
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		logrus.WithField("err", err).Error("rand.Read failed")
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// AccessListHandler is the net/http middleware of CheckAccessList, it must be before Handler
+func AccessListHandler(list accesslist.Service) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := remoteIP(r)
+			switch list.Check(ip, r.Header.Get(*apiKeyHeader)) {
+			case accesslist.Denied:
+				context := ctx.WithContext(r.Context(), logrus.Fields{"client": ip})
+				setAllowOrigin(w.Header(), r)
+				writeJSON(context, w, http.StatusForbidden, gin.H{"error": "forbidden"})
+				return
+			case accesslist.Allowed:
+				r = r.WithContext(gocontext.WithValue(r.Context(), allowlistedKey, true))
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// CheckAccessList rejects the requests in denylist and exempts the requests in allowlist from rate limiting
+func CheckAccessList(list accesslist.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch list.Check(clientIP(c), c.GetHeader(*apiKeyHeader)) {
+		case accesslist.Denied:
+			setAllowOrigin(c.Writer.Header(), c.Request)
+			c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+			c.Abort()
+			return
+		case accesslist.Allowed:
+			c.Set("allowlisted", true)
+		}
+
+		c.Next()
 	}
 }
@@ -0,0 +1,131 @@
+package api
+
+import (
+	gocontext "context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/chihkaiyu/ratelimiter/base/ctx"
+)
+
+type middlewareSuite struct {
+	suite.Suite
+}
+
+func TestMiddlewareSuite(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	suite.Run(t, new(middlewareSuite))
+}
+
+func (s *middlewareSuite) TestAddContext() {
+	tests := []struct {
+		Desc      string
+		RequestID string
+	}{
+		{
+			Desc:      "request id from header",
+			RequestID: "abc",
+		},
+		{
+			Desc: "generated request id",
+		},
+		{
+			Desc:      "too long request id",
+			RequestID: strings.Repeat("a", maxRequestIDLength+1),
+		},
+		{
+			Desc:      "request id with control characters",
+			RequestID: "abc\x1b[31m",
+		},
+	}
+
+	for _, t := range tests {
+		var context ctx.CTX
+		router := gin.New()
+		router.GET("/ping", AddContext(), func(c *gin.Context) {
+			context = c.MustGet("ctx").(ctx.CTX)
+		})
+
+		parent, cancel := gocontext.WithCancel(gocontext.Background())
+		req := httptest.NewRequest(http.MethodGet, "/ping", nil).WithContext(parent)
+		if t.RequestID != "" {
+			req.Header.Set(headerRequestID, t.RequestID)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		requestID := w.Header().Get(headerRequestID)
+		if validRequestID(t.RequestID) {
+			s.Equal(t.RequestID, requestID, t.Desc)
+		} else {
+			s.Len(requestID, 32, t.Desc)
+		}
+		// the context is canceled with the request
+		s.NoError(context.Err(), t.Desc)
+		cancel()
+		s.Equal(gocontext.Canceled, context.Err(), t.Desc)
+	}
+}
+
+func (s *middlewareSuite) TestRemoteIP() {
+	tests := []struct {
+		Desc       string
+		RemoteAddr string
+		Exp        string
+	}{
+		{
+			Desc:       "IPv4",
+			RemoteAddr: "10.0.0.1:1234",
+			Exp:        "10.0.0.1",
+		},
+		{
+			Desc:       "IPv6",
+			RemoteAddr: "[2001:db8::1]:1234",
+			Exp:        "2001:db8::1",
+		},
+		{
+			Desc:       "without port",
+			RemoteAddr: "10.0.0.1",
+			Exp:        "10.0.0.1",
+		},
+	}
+
+	for _, t := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/ping", nil)
+		req.RemoteAddr = t.RemoteAddr
+		s.Equal(t.Exp, remoteIP(req), t.Desc)
+	}
+}
+
+func (s *middlewareSuite) TestSetClientIP() {
+	var ip, header string
+	router := gin.New()
+	router.GET("/ping", SetClientIP(), func(c *gin.Context) {
+		ip = clientIP(c)
+		header = c.GetHeader("true-client-ip")
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/ping", nil)
+	req.RemoteAddr = "[2001:db8::1]:1234"
+	req.Header.Set("true-client-ip", "10.9.9.9")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	// the header sent by the client is replaced
+	s.Equal("2001:db8::1", ip)
+	s.Equal("2001:db8::1", header)
+}
+
+func (s *middlewareSuite) TestValidRequestID() {
+	s.True(validRequestID("1f0c-abc_123"))
+	s.True(validRequestID(strings.Repeat("a", maxRequestIDLength)))
+	s.False(validRequestID(""))
+	s.False(validRequestID(strings.Repeat("a", maxRequestIDLength+1)))
+	s.False(validRequestID("abc\ndef"))
+	s.False(validRequestID("abc\x7f"))
+	s.False(validRequestID("請求"))
+}
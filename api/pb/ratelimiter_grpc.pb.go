@@ -0,0 +1,99 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+
+package pb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion7
+
+// RateLimiterClient is the client API for RateLimiter service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type RateLimiterClient interface {
+	// ShouldRateLimit acquires the permits of every descriptor from the rule named by the domain
+	ShouldRateLimit(ctx context.Context, in *ShouldRateLimitRequest, opts ...grpc.CallOption) (*ShouldRateLimitResponse, error)
+}
+
+type rateLimiterClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewRateLimiterClient(cc grpc.ClientConnInterface) RateLimiterClient {
+	return &rateLimiterClient{cc}
+}
+
+func (c *rateLimiterClient) ShouldRateLimit(ctx context.Context, in *ShouldRateLimitRequest, opts ...grpc.CallOption) (*ShouldRateLimitResponse, error) {
+	out := new(ShouldRateLimitResponse)
+	err := c.cc.Invoke(ctx, "/ratelimiter.v1.RateLimiter/ShouldRateLimit", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// RateLimiterServer is the server API for RateLimiter service.
+// All implementations must embed UnimplementedRateLimiterServer
+// for forward compatibility
+type RateLimiterServer interface {
+	// ShouldRateLimit acquires the permits of every descriptor from the rule named by the domain
+	ShouldRateLimit(context.Context, *ShouldRateLimitRequest) (*ShouldRateLimitResponse, error)
+	mustEmbedUnimplementedRateLimiterServer()
+}
+
+// UnimplementedRateLimiterServer must be embedded to have forward compatible implementations.
+type UnimplementedRateLimiterServer struct {
+}
+
+func (UnimplementedRateLimiterServer) ShouldRateLimit(context.Context, *ShouldRateLimitRequest) (*ShouldRateLimitResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ShouldRateLimit not implemented")
+}
+func (UnimplementedRateLimiterServer) mustEmbedUnimplementedRateLimiterServer() {}
+
+// UnsafeRateLimiterServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to RateLimiterServer will
+// result in compilation errors.
+type UnsafeRateLimiterServer interface {
+	mustEmbedUnimplementedRateLimiterServer()
+}
+
+func RegisterRateLimiterServer(s grpc.ServiceRegistrar, srv RateLimiterServer) {
+	s.RegisterService(&_RateLimiter_serviceDesc, srv)
+}
+
+func _RateLimiter_ShouldRateLimit_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ShouldRateLimitRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RateLimiterServer).ShouldRateLimit(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/ratelimiter.v1.RateLimiter/ShouldRateLimit",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RateLimiterServer).ShouldRateLimit(ctx, req.(*ShouldRateLimitRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _RateLimiter_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "ratelimiter.v1.RateLimiter",
+	HandlerType: (*RateLimiterServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ShouldRateLimit",
+			Handler:    _RateLimiter_ShouldRateLimit_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "ratelimiter.proto",
+}
@@ -1,48 +1,128 @@
 package api
 
 import (
+	"flag"
+	"net/http"
+	"strings"
+	"time"
+
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/codes"
 
 	"github.com/chihkaiyu/ratelimiter/base/ctx"
+	"github.com/chihkaiyu/ratelimiter/base/tracing"
+	"github.com/chihkaiyu/ratelimiter/service/audit"
+	"github.com/chihkaiyu/ratelimiter/service/penalty"
 	"github.com/chihkaiyu/ratelimiter/service/ratelimiter"
+	"github.com/chihkaiyu/ratelimiter/service/ratelimiter/rule"
+)
+
+const (
+	headerKeyPrefix = "header:"
+
+	// FailureOpen allows the requests when the rate limiter fails
+	FailureOpen = "open"
+	// FailureClosed rejects the requests when the rate limiter fails
+	FailureClosed = "closed"
+)
+
+var (
+	limiterTimeout = flag.Duration("limiter_timeout", time.Second, "the timeout of rate limiting a request, 0 means no timeout")
+	failurePolicy  = flag.String("failure_policy", FailureClosed, "what to do when the rate limiter fails (e.g. redis is unreachable): open allows the requests, closed rejects them")
 )
 
 type RateLimiter struct {
 	errorBody interface{}
 	errorCode int
-	limiter   ratelimiter.Service
+	// responses are the responses of the requests rejected by the rules, errorBody and errorCode
+	// are responded for the rules without response
+	responses       map[string]rule.Response
+	failureResponse *rule.Response
+	limiter         ratelimiter.Limiter
+	penalty         penalty.Service
+	audit           audit.Service
 }
 
-func NewRateLimiter(limiter ratelimiter.Service, errorBody interface{}, errorCode int) *RateLimiter {
-	return &RateLimiter{
-		limiter:   limiter,
-		errorBody: errorBody,
-		errorCode: errorCode,
+// RateLimiterOption is an alias for functional argument in NewRateLimiter
+type RateLimiterOption func(*RateLimiter)
+
+// NewRateLimiter returns the middlewares responding errorBody and errorCode to the rejected requests,
+// unless the rule has its response in rules config
+func NewRateLimiter(limiter ratelimiter.Limiter, errorBody interface{}, errorCode int, opts ...RateLimiterOption) *RateLimiter {
+	config, err := rule.LoadFile()
+	if err != nil {
+		logrus.Panicf("rule.LoadFile failed, err: %v", err)
 	}
+
+	rl := &RateLimiter{
+		limiter:         limiter,
+		errorBody:       errorBody,
+		errorCode:       errorCode,
+		responses:       config.Responses,
+		failureResponse: config.FailureResponse,
+	}
+	for _, opt := range opts {
+		opt(rl)
+	}
+
+	return rl
 }
 
-func (rl *RateLimiter) Acquire() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		context := c.MustGet("ctx").(ctx.CTX)
-		ip := c.GetHeader("true-client-ip")
+// WithPenalty bans the clients which keep being rejected, banned clients are rejected before acquiring
+func WithPenalty(penalty penalty.Service) RateLimiterOption {
+	return func(rl *RateLimiter) {
+		rl.penalty = penalty
+	}
+}
 
-		permit, count, err := rl.limiter.AcquireByIP(context, ip)
-		if err != nil {
-			context.WithFields(logrus.Fields{
-				"err": err,
-				"ip":  ip,
-			}).Error("limiter.AcquireByIP failed")
+// WithResponses sets the responses of the rules and the response when the rate limiter fails
+// instead of rules config
+func WithResponses(responses map[string]rule.Response, failure *rule.Response) RateLimiterOption {
+	return func(rl *RateLimiter) {
+		rl.responses = responses
+		rl.failureResponse = failure
+	}
+}
 
-			setAllowOrigin(c)
-			c.JSON(rl.errorCode, rl.errorBody)
-			c.Abort()
+// WithAudit writes the decisions to the audit log
+func WithAudit(audit audit.Service) RateLimiterOption {
+	return func(rl *RateLimiter) {
+		rl.audit = audit
+	}
+}
+
+// requestDescriptor describes the request by "ip" or "header:<name>"
+type requestDescriptor struct {
+	r  *http.Request
+	ip string
+}
+
+func (d requestDescriptor) Value(key string) (string, bool) {
+	if key == "ip" {
+		return d.ip, true
+	}
+
+	if strings.HasPrefix(key, headerKeyPrefix) {
+		value := d.r.Header.Get(strings.TrimPrefix(key, headerKeyPrefix))
+		return value, value != ""
+	}
+
+	return "", false
+}
+
+// Acquire is the gin middleware of Limit, it requires AddContext and SetClientIP before it.
+// The request count is set to reqCount for JSON.
+func (rl *RateLimiter) Acquire(rules ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetBool("allowlisted") {
+			c.Set("reqCount", 0)
+			c.Next()
 			return
 		}
 
-		if !permit {
-			setAllowOrigin(c)
-			c.JSON(rl.errorCode, rl.errorBody)
+		count, ok := rl.Limit(c.MustGet("ctx").(ctx.CTX), c.Writer, c.Request, clientIP(c), c.GetString("requestID"), rules...)
+		if !ok {
 			c.Abort()
 			return
 		}
@@ -51,3 +131,223 @@ func (rl *RateLimiter) Acquire() gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// Handler is the net/http middleware of Limit, e.g. for chi or http.ServeMux. It adds the context
+// of the request like AddContext, and the client IP like SetClientIP. The handlers after it get
+// the context and the request ID by ContextOf and RequestIDOf. Requests exempted by AccessListHandler
+// before it aren't rate limited.
+func (rl *RateLimiter) Handler(rules ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := remoteIP(r)
+			r.Header.Set("true-client-ip", ip)
+			context, requestID := requestContext(w, r, ip, r.URL.Path)
+			if !allowlisted(r) {
+				if _, ok := rl.Limit(context, w, r, ip, requestID, rules...); !ok {
+					return
+				}
+			}
+
+			next.ServeHTTP(w, withContext(r, context, requestID))
+		})
+	}
+}
+
+// Limit acquires the permission of given rules for the request, the request is rejected
+// if any rule rejects it. All rules are evaluated, so rules in shadow mode always see the
+// same requests as the enforced ones, and the permits of the other enforced rules are given back
+// when it's rejected. The level keyed by "ip" is limited by the given IP of the client.
+// It returns the request count of the first rule, or writes the error response and returns false if
+// the request is rejected.
+func (rl *RateLimiter) Limit(context ctx.CTX, w http.ResponseWriter, r *http.Request, ip, requestID string, rules ...string) (int, bool) {
+	decision, banned, err := rl.check(context, ip, requestID, requestDescriptor{r: r, ip: ip}, rules)
+	if err != nil && *failurePolicy == FailureOpen {
+		context.WithField("err", err).Warn("rate limiter failed, request allowed by failure policy")
+		return 0, true
+	}
+
+	if err != nil {
+		rl.fail(context, w, r, requestID)
+		return 0, false
+	}
+
+	if banned > 0 {
+		setRetryAfter(w.Header(), banned)
+		w.Header().Set("X-RateLimit-Reason", reasonBanned)
+		if resp, ok := rl.bannedResponse(rules); ok {
+			setAllowOrigin(w.Header(), r)
+			writeResponse(context, w, r, resp, rl.errorCode, bannedValues(rules[0], banned, requestID))
+			return 0, false
+		}
+		rl.reject(context, w, r)
+		return 0, false
+	}
+
+	if !decision.Allowed {
+		// the headers are set before the body of any response is written
+		setRateLimitHeaders(w.Header(), decision)
+		if resp, ok := rl.responses[decision.Rule]; ok {
+			setAllowOrigin(w.Header(), r)
+			writeResponse(context, w, r, resp, rl.errorCode, responseValues(decision, requestID))
+			return 0, false
+		}
+		rl.reject(context, w, r)
+		return 0, false
+	}
+
+	return decision.Count, true
+}
+
+// check returns the decision of given rules for the request of the client, it's traced and
+// written to the audit log
+func (rl *RateLimiter) check(context ctx.CTX, client, requestID string, descriptor ratelimiter.Descriptor, rules []string) (ratelimiter.Decision, time.Duration, error) {
+	cancel := func() {}
+	if *limiterTimeout > 0 {
+		context, cancel = ctx.WithTimeout(context, *limiterTimeout)
+	}
+
+	context, span := tracing.Start(context, "ratelimiter.Middleware", tracing.KeyHash.String(tracing.HashKey(client)))
+	decision, banned, err := rl.decide(context, client, descriptor, rules)
+	cancel()
+	if err != nil {
+		limiterFailing.Set(1)
+	} else {
+		limiterFailing.Set(0)
+	}
+	switch {
+	case err != nil:
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		span.SetAttributes(tracing.Result.String("error"))
+	case banned > 0:
+		span.SetAttributes(tracing.Result.String("banned"))
+	case !decision.Allowed:
+		span.SetAttributes(tracing.Result.String("denied"), tracing.Remaining.Int(decision.Remaining))
+	default:
+		span.SetAttributes(tracing.Result.String("allowed"), tracing.Remaining.Int(decision.Remaining))
+	}
+	span.End()
+
+	if err == nil && rl.audit != nil {
+		rl.audit.Log(context, audit.Entry{
+			RequestID: requestID,
+			Client:    client,
+			Decision:  decision,
+			Banned:    banned,
+		})
+	}
+
+	return decision, banned, err
+}
+
+// bannedResponse returns the response template of the first rule for the banned client, since the
+// ban isn't of any rule
+func (rl *RateLimiter) bannedResponse(rules []string) (rule.Response, bool) {
+	if len(rules) == 0 {
+		return rule.Response{}, false
+	}
+	resp, ok := rl.responses[rules[0]]
+	return resp, ok
+}
+
+// reject writes the error response
+func (rl *RateLimiter) reject(context ctx.CTX, w http.ResponseWriter, r *http.Request) {
+	setAllowOrigin(w.Header(), r)
+	writeJSON(context, w, rl.errorCode, rl.errorBody)
+}
+
+// fail writes 503 when the rate limiter fails, so clients could tell it from being rate limited
+func (rl *RateLimiter) fail(context ctx.CTX, w http.ResponseWriter, r *http.Request, requestID string) {
+	setAllowOrigin(w.Header(), r)
+	if rl.failureResponse != nil {
+		writeResponse(context, w, r, *rl.failureResponse, http.StatusServiceUnavailable, map[string]string{"request_id": requestID})
+		return
+	}
+
+	writeJSON(context, w, http.StatusServiceUnavailable, gin.H{"error": "rate limiter unavailable"})
+}
+
+// decide returns the decision of given rules for the request, or the remaining duration
+// of the ban if the client is banned
+func (rl *RateLimiter) decide(context ctx.CTX, ip string, descriptor ratelimiter.Descriptor, rules []string) (ratelimiter.Decision, time.Duration, error) {
+	if rl.penalty != nil {
+		banned, err := rl.penalty.Banned(context, ip)
+		if err != nil {
+			context.WithFields(logrus.Fields{
+				"err": err,
+				"ip":  ip,
+			}).Error("penalty.Banned failed")
+		}
+		if banned > 0 {
+			return ratelimiter.Decision{}, banned, nil
+		}
+	}
+
+	var result ratelimiter.Decision
+	// the enforced rules which allowed the request, the permits are given back if another rule
+	// rejects it. Rules in shadow mode keep counting the request.
+	allowed := []permit{}
+	for i, rule := range rules {
+		decision, err := rl.limiter.Acquire(context, rule, descriptor)
+		if err != nil {
+			context.WithFields(logrus.Fields{
+				"err":  err,
+				"ip":   ip,
+				"rule": rule,
+			}).Error("limiter.Acquire failed")
+			return ratelimiter.Decision{}, 0, err
+		}
+
+		if decision.Allowed && !decision.Shadow {
+			allowed = append(allowed, permit{rule: rule, descriptor: descriptor, n: 1})
+		}
+		if i == 0 || (result.Allowed && !decision.Allowed) {
+			result = decision
+		}
+	}
+
+	if !result.Allowed {
+		context.WithFields(logrus.Fields{
+			"ip":     ip,
+			"reason": result.Reason(),
+		}).Info("request rejected")
+		release(context, rl.limiter, allowed)
+
+		// bans are checked by IP, so only the levels limiting the IP penalize it. A client denied by
+		// a level keyed by something else, e.g. a tenant or all clients together, isn't to blame.
+		if rl.penalty != nil && ip != "" && result.Key == ip {
+			if _, err := rl.penalty.Penalize(context, ip); err != nil {
+				context.WithFields(logrus.Fields{
+					"err": err,
+					"ip":  ip,
+				}).Error("penalty.Penalize failed")
+			}
+		}
+	}
+
+	return result, 0, nil
+}
+
+// permit is the permits acquired from a rule, given back if another rule denies the request
+type permit struct {
+	rule       string
+	descriptor ratelimiter.Descriptor
+	n          int
+}
+
+// release gives back the permits, if the limiter supports it
+func release(context ctx.CTX, limiter ratelimiter.Limiter, permits []permit) {
+	releaser, ok := limiter.(ratelimiter.Releaser)
+	if !ok {
+		return
+	}
+
+	for _, p := range permits {
+		if err := releaser.Release(context, p.rule, p.descriptor, p.n); err != nil {
+			context.WithFields(logrus.Fields{
+				"err":  err,
+				"rule": p.rule,
+			}).Error("limiter.Release failed")
+		}
+	}
+}
@@ -0,0 +1,355 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/chihkaiyu/ratelimiter/base/ctx"
+	"github.com/chihkaiyu/ratelimiter/service/accesslist"
+	"github.com/chihkaiyu/ratelimiter/service/penalty"
+	"github.com/chihkaiyu/ratelimiter/service/ratelimiter"
+	"github.com/chihkaiyu/ratelimiter/service/ratelimiter/rule"
+	"github.com/chihkaiyu/ratelimiter/service/ratelimiter/strategy"
+)
+
+type mockPenalty struct {
+	// the methods not mocked panic
+	penalty.Service
+	mock.Mock
+}
+
+func (m *mockPenalty) Banned(context ctx.CTX, key string) (time.Duration, error) {
+	args := m.Called(key)
+	return args.Get(0).(time.Duration), args.Error(1)
+}
+
+func (m *mockPenalty) Penalize(context ctx.CTX, key string) (time.Duration, error) {
+	args := m.Called(key)
+	return args.Get(0).(time.Duration), args.Error(1)
+}
+
+type mockAccessList struct {
+	// the methods not mocked panic
+	accesslist.Service
+	mock.Mock
+}
+
+func (m *mockAccessList) Check(ip, apiKey string) accesslist.Result {
+	args := m.Called(ip, apiKey)
+	return args.Get(0).(accesslist.Result)
+}
+
+type rateLimiterSuite struct {
+	suite.Suite
+	limiter *mockLimiter
+	rl      *RateLimiter
+}
+
+func TestRateLimiterSuite(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	suite.Run(t, new(rateLimiterSuite))
+}
+
+func (s *rateLimiterSuite) SetupTest() {
+	s.limiter = new(mockLimiter)
+	s.rl = NewRateLimiter(s.limiter, gin.H{"error": "too many requests"}, http.StatusTooManyRequests)
+}
+
+func (s *rateLimiterSuite) TearDownTest() {
+	s.limiter.AssertExpectations(s.T())
+}
+
+// handlers returns the gin and net/http handlers of the same rules, they respond the request count
+func (s *rateLimiterSuite) handlers() map[string]http.Handler {
+	router := gin.New()
+	router.GET("/ping", AddContext(), SetClientIP(), s.rl.Acquire("api"), func(c *gin.Context) {
+		JSON(c, http.StatusOK)
+	})
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	return map[string]http.Handler{
+		"gin":      router,
+		"net/http": s.rl.Handler("api")(ok),
+	}
+}
+
+func (s *rateLimiterSuite) serve(handler http.Handler) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/ping", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	// spoofed by the client
+	req.Header.Set("true-client-ip", "10.9.9.9")
+	req.Header.Set("Origin", "https://www.dcard.com.tw")
+	req.Header.Set("X-Tenant-ID", "acme")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+func (s *rateLimiterSuite) TestAllowed() {
+	allowed := ratelimiter.Decision{Decision: strategy.Decision{Allowed: true, Count: 3, Limit: 10, Remaining: 7}, Rule: "api"}
+	s.limiter.On("AcquireN", "api", mock.Anything, 1).Return(allowed, nil).Twice()
+
+	limiterFailing.Set(1)
+	for name, handler := range s.handlers() {
+		w := s.serve(handler)
+		s.Equal(http.StatusOK, w.Code, name)
+		s.Len(w.Header().Get(headerRequestID), 32, name)
+	}
+	s.Equal(float64(0), testutil.ToFloat64(limiterFailing))
+
+	// both adapters describe the request the same way, by the remote IP
+	for _, call := range s.limiter.Calls {
+		descriptor := call.Arguments.Get(1).(ratelimiter.Descriptor)
+		ip, _ := descriptor.Value("ip")
+		s.Equal("10.0.0.1", ip)
+		tenant, _ := descriptor.Value("header:X-Tenant-ID")
+		s.Equal("acme", tenant)
+	}
+}
+
+func (s *rateLimiterSuite) TestRejected() {
+	denied := ratelimiter.Decision{
+		Decision: strategy.Decision{Allowed: false, Count: 11, Limit: 10, RetryAfter: 2 * time.Second},
+		Rule:     "api",
+		Level:    "ip",
+		Key:      "10.0.0.1",
+	}
+	s.limiter.On("AcquireN", "api", mock.Anything, 1).Return(denied, nil).Twice()
+
+	for name, handler := range s.handlers() {
+		w := s.serve(handler)
+		s.Equal(http.StatusTooManyRequests, w.Code, name)
+		s.Equal("ip limit of rule api exceeded", w.Header().Get("X-RateLimit-Reason"), name)
+		s.Equal("10", w.Header().Get("X-RateLimit-Limit"), name)
+		s.Equal("0", w.Header().Get("X-RateLimit-Remaining"), name)
+		s.Equal("2", w.Header().Get("Retry-After"), name)
+		s.Equal("https://www.dcard.com.tw", w.Header().Get("Access-Control-Allow-Origin"), name)
+		s.Equal("application/json; charset=utf-8", w.Header().Get("Content-Type"), name)
+		s.JSONEq(`{"error": "too many requests"}`, w.Body.String(), name)
+	}
+}
+
+func (s *rateLimiterSuite) TestMultipleRules() {
+	allowed := ratelimiter.Decision{Decision: strategy.Decision{Allowed: true, Count: 3, Limit: 10, Remaining: 7}, Rule: "api"}
+	shadow := ratelimiter.Decision{Decision: strategy.Decision{Allowed: true, Count: 3, Limit: 10, Remaining: 7}, Rule: "candidate", Shadow: true}
+	denied := ratelimiter.Decision{Decision: strategy.Decision{Allowed: false, Count: 11, Limit: 10}, Rule: "strict", Level: "ip", Key: "10.0.0.1"}
+	s.limiter.On("AcquireN", "api", mock.Anything, 1).Return(allowed, nil).Once()
+	s.limiter.On("AcquireN", "candidate", mock.Anything, 1).Return(shadow, nil).Once()
+	s.limiter.On("AcquireN", "strict", mock.Anything, 1).Return(denied, nil).Once()
+	// only the enforced rule is given back, the shadow rule keeps counting the request
+	s.limiter.On("Release", "api", 1).Return(nil).Once()
+
+	handler := s.rl.Handler("api", "candidate", "strict")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	w := s.serve(handler)
+	s.Equal(http.StatusTooManyRequests, w.Code)
+	s.Equal("ip limit of rule strict exceeded", w.Header().Get("X-RateLimit-Reason"))
+}
+
+func (s *rateLimiterSuite) TestHandlerContext() {
+	allowed := ratelimiter.Decision{Decision: strategy.Decision{Allowed: true, Count: 3, Limit: 10, Remaining: 7}, Rule: "api"}
+	s.limiter.On("AcquireN", "api", mock.Anything, 1).Return(allowed, nil).Once()
+
+	var context ctx.CTX
+	var requestID, ip string
+	handler := s.rl.Handler("api")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		context, requestID, ip = ContextOf(r), RequestIDOf(r), r.Header.Get("true-client-ip")
+	}))
+	w := s.serve(handler)
+	s.Equal(http.StatusOK, w.Code)
+	s.Equal(w.Header().Get(headerRequestID), requestID)
+	s.Equal(requestID, context.FieldLogger.(*logrus.Entry).Data["request_id"])
+	s.Equal("10.0.0.1", ip)
+
+	// nothing is added without Handler
+	req := httptest.NewRequest(http.MethodGet, "/ping", nil)
+	s.Empty(RequestIDOf(req))
+	s.NotNil(ContextOf(req).FieldLogger)
+}
+
+func (s *rateLimiterSuite) TestAccessListHandler() {
+	list := new(mockAccessList)
+	defer list.AssertExpectations(s.T())
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := AccessListHandler(list)(s.rl.Handler("api")(ok))
+
+	// denylisted requests are rejected before rate limiting
+	list.On("Check", "10.0.0.1", "").Return(accesslist.Denied).Once()
+	w := s.serve(handler)
+	s.Equal(http.StatusForbidden, w.Code)
+	s.JSONEq(`{"error": "forbidden"}`, w.Body.String())
+	s.Equal("https://www.dcard.com.tw", w.Header().Get("Access-Control-Allow-Origin"))
+
+	// allowlisted requests aren't rate limited but still get the context
+	list.On("Check", "10.0.0.1", "").Return(accesslist.Allowed).Once()
+	w = s.serve(handler)
+	s.Equal(http.StatusOK, w.Code)
+	s.Len(w.Header().Get(headerRequestID), 32)
+
+	allowed := ratelimiter.Decision{Decision: strategy.Decision{Allowed: true, Count: 3, Limit: 10, Remaining: 7}, Rule: "api"}
+	s.limiter.On("AcquireN", "api", mock.Anything, 1).Return(allowed, nil).Once()
+	list.On("Check", "10.0.0.1", "").Return(accesslist.NotListed).Once()
+	s.Equal(http.StatusOK, s.serve(handler).Code)
+}
+
+func (s *rateLimiterSuite) TestPenalty() {
+	p := new(mockPenalty)
+	defer p.AssertExpectations(s.T())
+	WithPenalty(p)(s.rl)
+	handler := s.handlers()["net/http"]
+	p.On("Banned", "10.0.0.1").Return(time.Duration(0), nil).Times(3)
+
+	// denied by the level limiting the IP
+	s.limiter.On("AcquireN", "api", mock.Anything, 1).Return(ratelimiter.Decision{
+		Decision: strategy.Decision{Allowed: false, Count: 11, Limit: 10},
+		Rule:     "api",
+		Level:    "ip",
+		Key:      "10.0.0.1",
+	}, nil).Once()
+	p.On("Penalize", "10.0.0.1").Return(time.Duration(0), nil).Once()
+	s.Equal(http.StatusTooManyRequests, s.serve(handler).Code)
+
+	// denied by the levels keyed by others don't penalize the IP
+	s.limiter.On("AcquireN", "api", mock.Anything, 1).Return(ratelimiter.Decision{
+		Decision: strategy.Decision{Allowed: false, Count: 11, Limit: 10},
+		Rule:     "api",
+		Level:    "tenant",
+		Key:      "acme",
+	}, nil).Once()
+	s.Equal(http.StatusTooManyRequests, s.serve(handler).Code)
+	s.limiter.On("AcquireN", "api", mock.Anything, 1).Return(ratelimiter.Decision{
+		Decision: strategy.Decision{Allowed: false, Count: 11, Limit: 10},
+		Rule:     "api",
+		Level:    "global",
+	}, nil).Once()
+	s.Equal(http.StatusTooManyRequests, s.serve(handler).Code)
+
+	// banned before acquiring
+	p.On("Banned", "10.0.0.1").Return(time.Minute, nil).Once()
+	w := s.serve(handler)
+	s.Equal(http.StatusTooManyRequests, w.Code)
+	s.Equal("60", w.Header().Get("Retry-After"))
+	s.Equal("client banned", w.Header().Get("X-RateLimit-Reason"))
+
+	// banned clients are responded by the template of the first rule
+	WithResponses(map[string]rule.Response{
+		"api": {Type: rule.ResponseJSON, Body: `{"rule":"${rule}","reason":"${reason}","retry_after":${retry_after}}`},
+	}, nil)(s.rl)
+	p.On("Banned", "10.0.0.1").Return(90*time.Second, nil).Once()
+	w = s.serve(handler)
+	s.Equal(http.StatusTooManyRequests, w.Code)
+	s.Equal("90", w.Header().Get("Retry-After"))
+	s.Equal("client banned", w.Header().Get("X-RateLimit-Reason"))
+	s.JSONEq(`{"rule":"api","reason":"client banned","retry_after":90}`, w.Body.String())
+}
+
+func (s *rateLimiterSuite) TestLimiterFailed() {
+	s.limiter.On("AcquireN", "api", mock.Anything, 1).Return(ratelimiter.Decision{}, fmt.Errorf("redis down")).Times(4)
+
+	// distinct from being rate limited
+	for name, handler := range s.handlers() {
+		w := s.serve(handler)
+		s.Equal(http.StatusServiceUnavailable, w.Code, name)
+		s.JSONEq(`{"error": "rate limiter unavailable"}`, w.Body.String(), name)
+	}
+	s.Equal(float64(1), testutil.ToFloat64(limiterFailing))
+
+	WithResponses(nil, &rule.Response{Type: rule.ResponseText, Body: "we're broken, request ${request_id}"})(s.rl)
+	for name, handler := range s.handlers() {
+		w := s.serve(handler)
+		s.Equal(http.StatusServiceUnavailable, w.Code, name)
+		s.Equal("we're broken, request "+w.Header().Get(headerRequestID), w.Body.String(), name)
+	}
+}
+
+func (s *rateLimiterSuite) TestResponses() {
+	denied := ratelimiter.Decision{
+		Decision: strategy.Decision{Allowed: false, Count: 11, Limit: 10, RetryAfter: 1500 * time.Millisecond},
+		Rule:     "api",
+		Level:    "ip",
+		Key:      "10.0.0.1",
+	}
+	requestID := `"<script>`
+
+	tests := []struct {
+		Desc           string
+		Response       rule.Response
+		ExpCode        int
+		ExpContentType string
+		ExpBody        string
+		ExpLocation    string
+	}{
+		{
+			Desc:           "json",
+			Response:       rule.Response{Type: rule.ResponseJSON, Body: `{"error": "${reason}", "limit": ${limit}, "retry_after": ${retry_after}, "request_id": "${request_id}"}`},
+			ExpCode:        http.StatusTooManyRequests,
+			ExpContentType: "application/json; charset=utf-8",
+			ExpBody:        `{"error": "ip limit of rule api exceeded", "limit": 10, "retry_after": 2, "request_id": "\"\u003cscript\u003e"}`,
+		},
+		{
+			Desc:           "text with status",
+			Response:       rule.Response{Type: rule.ResponseText, Status: http.StatusServiceUnavailable, Body: "slow down, ${remaining} left"},
+			ExpCode:        http.StatusServiceUnavailable,
+			ExpContentType: "text/plain; charset=utf-8",
+			ExpBody:        "slow down, 0 left",
+		},
+		{
+			Desc:           "html",
+			Response:       rule.Response{Type: rule.ResponseHTML, Body: "<p>${request_id}</p>"},
+			ExpCode:        http.StatusTooManyRequests,
+			ExpContentType: "text/html; charset=utf-8",
+			ExpBody:        "<p>&#34;&lt;script&gt;</p>",
+		},
+		{
+			Desc:        "redirect",
+			Response:    rule.Response{Type: rule.ResponseRedirect, Body: "https://www.dcard.com.tw/limited?retry=${retry_after}&id=${request_id}"},
+			ExpCode:     http.StatusFound,
+			ExpLocation: "https://www.dcard.com.tw/limited?retry=2&id=%22%3Cscript%3E",
+		},
+	}
+
+	for _, t := range tests {
+		WithResponses(map[string]rule.Response{"api": t.Response}, nil)(s.rl)
+		s.limiter.On("AcquireN", "api", mock.Anything, 1).Return(denied, nil).Twice()
+
+		for name, handler := range s.handlers() {
+			req := httptest.NewRequest(http.MethodGet, "/ping", nil)
+			req.Header.Set(headerRequestID, requestID)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			s.Equal(t.ExpCode, w.Code, t.Desc, name)
+			s.Equal("ip limit of rule api exceeded", w.Header().Get("X-RateLimit-Reason"), t.Desc, name)
+			s.Equal("10", w.Header().Get("X-RateLimit-Limit"), t.Desc, name)
+			s.Equal("0", w.Header().Get("X-RateLimit-Remaining"), t.Desc, name)
+			s.Equal("2", w.Header().Get("Retry-After"), t.Desc, name)
+			if t.ExpLocation != "" {
+				s.Equal(t.ExpLocation, w.Header().Get("Location"), t.Desc, name)
+				continue
+			}
+			s.Equal(t.ExpContentType, w.Header().Get("Content-Type"), t.Desc, name)
+			s.Equal(t.ExpBody, w.Body.String(), t.Desc, name)
+		}
+	}
+
+	// the rules without response respond the default error
+	WithResponses(map[string]rule.Response{"other": {Type: rule.ResponseText, Body: "limited"}}, nil)(s.rl)
+	s.limiter.On("AcquireN", "api", mock.Anything, 1).Return(denied, nil).Twice()
+	for name, handler := range s.handlers() {
+		w := s.serve(handler)
+		s.JSONEq(`{"error": "too many requests"}`, w.Body.String(), name)
+	}
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"encoding/json"
+	"html"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chihkaiyu/ratelimiter/base/ctx"
+	"github.com/chihkaiyu/ratelimiter/service/ratelimiter"
+	"github.com/chihkaiyu/ratelimiter/service/ratelimiter/rule"
+)
+
+// reasonBanned is the reason of rejecting the banned clients
+const reasonBanned = "client banned"
+
+// responseValues returns the values of the placeholders in the response templates
+func responseValues(decision ratelimiter.Decision, requestID string) map[string]string {
+	return map[string]string{
+		"rule":        decision.Rule,
+		"level":       decision.Level,
+		"reason":      decision.Reason(),
+		"limit":       strconv.Itoa(decision.Limit),
+		"remaining":   strconv.Itoa(decision.Remaining),
+		"retry_after": strconv.Itoa(int(math.Ceil(decision.RetryAfter.Seconds()))),
+		"request_id":  requestID,
+	}
+}
+
+// bannedValues returns the values of the placeholders in the response template of the rule for
+// the banned client, the ban has no level or limit
+func bannedValues(rule string, banned time.Duration, requestID string) map[string]string {
+	values := responseValues(ratelimiter.Decision{Rule: rule}, requestID)
+	values["reason"] = reasonBanned
+	values["retry_after"] = strconv.Itoa(int(math.Ceil(banned.Seconds())))
+	return values
+}
+
+// writeResponse writes the response of the template, the values are escaped for the type of the
+// response since the request ID is from the client. The status is defaultStatus if it's not set,
+// or 302 for redirect.
+func writeResponse(context ctx.CTX, w http.ResponseWriter, r *http.Request, resp rule.Response, defaultStatus int, values map[string]string) {
+	escape := func(s string) string { return s }
+	contentType := "text/plain; charset=utf-8"
+	switch resp.Type {
+	case rule.ResponseJSON:
+		escape = escapeJSON
+		contentType = "application/json; charset=utf-8"
+	case rule.ResponseHTML:
+		escape = html.EscapeString
+		contentType = "text/html; charset=utf-8"
+	case rule.ResponseRedirect:
+		escape = url.QueryEscape
+	}
+
+	pairs := []string{}
+	for k, v := range values {
+		pairs = append(pairs, "${"+k+"}", escape(v))
+	}
+	body := strings.NewReplacer(pairs...).Replace(resp.Body)
+
+	status := resp.Status
+	if resp.Type == rule.ResponseRedirect {
+		if status == 0 {
+			status = http.StatusFound
+		}
+		http.Redirect(w, r, body, status)
+		return
+	}
+
+	if status == 0 {
+		status = defaultStatus
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	if _, err := w.Write([]byte(body)); err != nil {
+		context.WithField("err", err).Error("w.Write failed")
+	}
+}
+
+// escapeJSON escapes the value in a JSON string
+func escapeJSON(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b[1 : len(b)-1])
+}
@@ -1,10 +1,21 @@
 package api
 
-import "github.com/gin-gonic/gin"
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/chihkaiyu/ratelimiter/base/ctx"
+	"github.com/chihkaiyu/ratelimiter/service/ratelimiter"
+)
 
 // JSON wraps gin context's JSON method and removes private field.
 func JSON(c *gin.Context, code int) {
-	setAllowOrigin(c)
+	setAllowOrigin(c.Writer.Header(), c.Request)
 	// context := c.MustGet("ctx").(ctx.CTX)
 	count := c.MustGet("reqCount").(int)
 
@@ -13,7 +24,32 @@ func JSON(c *gin.Context, code int) {
 	})
 }
 
-func setAllowOrigin(c *gin.Context) {
-	origin := c.Request.Header.Get("Origin")
-	c.Header("Access-Control-Allow-Origin", origin)
+func setAllowOrigin(header http.Header, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	header.Set("Access-Control-Allow-Origin", origin)
+}
+
+// setRetryAfter sets Retry-After header in seconds, rounded up
+func setRetryAfter(header http.Header, d time.Duration) {
+	header.Set("Retry-After", strconv.Itoa(int(math.Ceil(d.Seconds()))))
+}
+
+// setRateLimitHeaders sets the headers of the denied decision: X-RateLimit-Limit, X-RateLimit-Remaining,
+// X-RateLimit-Reason and Retry-After if the decision tells when to retry
+func setRateLimitHeaders(header http.Header, d ratelimiter.Decision) {
+	header.Set("X-RateLimit-Limit", strconv.Itoa(d.Limit))
+	header.Set("X-RateLimit-Remaining", strconv.Itoa(d.Remaining))
+	header.Set("X-RateLimit-Reason", d.Reason())
+	if d.RetryAfter > 0 {
+		setRetryAfter(header, d.RetryAfter)
+	}
+}
+
+// writeJSON writes the response like gin context's JSON method, for the handlers without gin
+func writeJSON(context ctx.CTX, w http.ResponseWriter, code int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		context.WithField("err", err).Error("json.Encode failed")
+	}
 }
@@ -1,41 +1,124 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"net/http"
+	"strings"
 
+	rlsv3 "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
 
 	"github.com/chihkaiyu/ratelimiter/api"
+	"github.com/chihkaiyu/ratelimiter/api/pb"
+	"github.com/chihkaiyu/ratelimiter/base/facility"
+	"github.com/chihkaiyu/ratelimiter/base/tracing"
+	"github.com/chihkaiyu/ratelimiter/service/accesslist"
+	"github.com/chihkaiyu/ratelimiter/service/audit"
+	"github.com/chihkaiyu/ratelimiter/service/penalty"
 	"github.com/chihkaiyu/ratelimiter/service/ratelimiter"
 	"github.com/chihkaiyu/ratelimiter/service/redis"
 )
 
+const (
+	shutdownLevelServer = iota
+	shutdownLevelRedis
+	shutdownLevelAudit
+)
+
 var (
 	port      = flag.Int("port", 9000, "api server port")
+	grpcPort  = flag.Int("grpc_port", 9001, "grpc server port serving the rate limit decisions and envoy rate limit service")
+	debugAddr = flag.String("debug_addr", ":8080", "monitor server addr serving metrics and pprof: host:port")
 	redisAddr = flag.String("redis_addr", "localhost:6379", "redis addr: host:port")
+	rules     = flag.String("ratelimiter_rule", ratelimiter.DefaultRule, "the rules applied to api, comma separated")
 )
 
 func main() {
 	flag.Parse()
 
-	redis := redis.NewRedis(*redisAddr, "")
+	shutdownTracing, err := tracing.Init()
+	if err != nil {
+		logrus.Panicf("tracing.Init failed, err: %v", err)
+	}
+	defer shutdownTracing(context.Background())
+
+	degradedStart := redis.DegradedStart()
+	redis, err := redis.NewRedis(*redisAddr, "")
+	if err != nil {
+		logrus.Panicf("redis.NewRedis failed, err: %v", err)
+	}
 	limiter := ratelimiter.NewRateLimiter(redis)
-	ratelimiter := api.NewRateLimiter(limiter, gin.H{"error": "too many request"}, http.StatusTooManyRequests)
+	accessList := accesslist.NewAccessList(redis)
+	penaltyBox := penalty.NewPenaltyBox(redis)
+	auditService := audit.NewAudit()
+	ratelimiter := api.NewRateLimiter(
+		limiter, gin.H{"error": "too many request"}, http.StatusTooManyRequests,
+		api.WithPenalty(penaltyBox), api.WithAudit(auditService),
+	)
+	admin := api.NewAdmin(limiter, penaltyBox, accessList)
+	decision := api.NewDecisionAPI(limiter)
 
 	router := gin.Default()
 	router.Use(api.Cors())
 	rg := router.Group("/api/v1")
 	rg.Use(
-		api.AddContext(), api.SetClientIP(), ratelimiter.Acquire(),
+		api.AddContext(), api.SetClientIP(), api.CheckAccessList(accessList), ratelimiter.Acquire(strings.Split(*rules, ",")...),
 	)
 	rg.GET("/ping", func(c *gin.Context) {
 		api.JSON(c, http.StatusOK)
 	})
 
-	if err := router.Run(fmt.Sprintf(":%d", *port)); err != nil {
-		logrus.Panicf("router.Run failed, err: %v", err)
+	// the decision API shares the context and access list with the API, but it isn't rate limited
+	dg := router.Group("/api/v1")
+	dg.Use(api.AddContext(), api.SetClientIP(), api.CheckAccessList(accessList), api.DecisionAuth())
+	dg.POST("/acquire", decision.Acquire)
+
+	ag := router.Group("/admin/v1")
+	ag.Use(api.AddContext(), api.AdminAuth())
+	ag.GET("/bans", admin.ListBans)
+	ag.DELETE("/bans/:key", admin.LiftBan)
+	ag.GET("/rules", admin.ListRules)
+	ag.PUT("/rules/:name", admin.SetRule)
+	ag.DELETE("/rules/:name", admin.DeleteRule)
+	ag.GET("/rules/:name/state", admin.InspectKey)
+	ag.DELETE("/rules/:name/state", admin.ResetKey)
+	ag.GET("/rules/:name/levels/:level/top", admin.TopConsumers)
+	ag.GET("/overrides", admin.ListOverrides)
+	ag.PUT("/overrides", admin.SetOverride)
+	ag.DELETE("/overrides", admin.DeleteOverride)
+	ag.GET("/accesslist/:list", admin.ListAccessList)
+	ag.PUT("/accesslist/:list", admin.AddAccessListEntry)
+	ag.DELETE("/accesslist/:list", admin.RemoveAccessListEntry)
+
+	// rules are loaded before serving, so the readiness only depends on redis. With degraded start
+	// the server serves by failure_policy without redis, so it isn't taken out of the load balancers.
+	if !degradedStart {
+		facility.AddReadinessCheck("redis", redis.Ping)
+	}
+	// redis is closed after in-flight requests are finished
+	facility.AddShutdownHandler(redis.Close, facility.WithShutdownLevel(shutdownLevelRedis))
+	// the audit log is closed last, so the decisions of in-flight requests are all written
+	facility.AddShutdownHandler(auditService.Close, facility.WithShutdownLevel(shutdownLevelAudit))
+
+	opts := []facility.Option{
+		facility.WithGinRouter(fmt.Sprintf(":%d", *port), router),
+		facility.WithDebugAddr(*debugAddr),
+		facility.WithShutdownLevel(shutdownLevelServer),
+	}
+	// the gRPC services, including the one of Envoy, are authenticated by decision_token like the
+	// decision API, so the gRPC server isn't served without it
+	if api.DecisionEnabled() {
+		grpcServer := grpc.NewServer(grpc.UnaryInterceptor(api.DecisionAuthInterceptor()))
+		pb.RegisterRateLimiterServer(grpcServer, api.NewRateLimitServer(limiter))
+		rlsv3.RegisterRateLimitServiceServer(grpcServer, api.NewEnvoyServer(limiter))
+		opts = append(opts, facility.WithGRPCServer(fmt.Sprintf(":%d", *grpcPort), grpcServer))
+	}
+
+	if err := facility.Serve("ratelimiter", opts...); err != nil {
+		logrus.Panicf("facility.Serve failed, err: %v", err)
 	}
 }
@@ -2,6 +2,7 @@ package ctx
 
 import (
 	"context"
+	"time"
 
 	"github.com/sirupsen/logrus"
 )
@@ -19,3 +20,20 @@ func Background() CTX {
 		FieldLogger: logrus.StandardLogger(),
 	}
 }
+
+// WithContext returns a CTX derived from given context, logging with given fields
+func WithContext(parent context.Context, fields logrus.Fields) CTX {
+	return CTX{
+		Context:     parent,
+		FieldLogger: logrus.StandardLogger().WithFields(fields),
+	}
+}
+
+// WithTimeout returns a copy of CTX with given timeout
+func WithTimeout(parent CTX, timeout time.Duration) (CTX, context.CancelFunc) {
+	c, cancel := context.WithTimeout(parent.Context, timeout)
+	return CTX{
+		Context:     c,
+		FieldLogger: parent.FieldLogger,
+	}, cancel
+}
@@ -1,35 +1,79 @@
 package facility
 
 import (
+	"context"
+	"encoding/json"
+	"flag"
 	"fmt"
+	"net"
 	"net/http"
+	"net/http/pprof"
 	"os"
 	"os/signal"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
-	"github.com/braintree/manners"
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+
+	"github.com/chihkaiyu/ratelimiter/base/ctx"
 )
 
 const (
 	defaultDebugAddr = ":8080"
+
+	// MonitorShutdownLevel is the shutdown level of the monitor server, it's shut down
+	// after the others so metrics and probes are available while shutting down
+	MonitorShutdownLevel = 100
+	// DelayShutdownLevel is the shutdown level of shutdown_delay, it's before the others so the
+	// readiness fails while the servers are still serving
+	DelayShutdownLevel = -100
+
+	readinessTimeout = 2 * time.Second
 )
 
 var (
-	shutdownHandlers     = map[int][]handlerFunc{}
+	drainTimeout  = flag.Int("drain_timeout", 25, "the time waiting for in-flight requests to finish when shutting down (in second)")
+	shutdownDelay = flag.Int("shutdown_delay", 5, "the time failing the readiness before the servers stop accepting new requests when shutting down, so load balancers stop sending requests first (in second), 0 to disable")
+
+	shutdownHandlers     = map[int][]HandlerFunc{}
 	shutdownHandlerMutex = sync.Mutex{}
+	hookOnce             = sync.Once{}
+	// shutdownFinished is closed when all shutdown handlers are finished
+	shutdownFinished = make(chan struct{})
+	// shuttingDown is set to 1 when the terminated signal is received
+	shuttingDown int32
+
+	readinessChecks     = map[string]CheckFunc{}
+	readinessCheckMutex = sync.Mutex{}
 )
 
 type (
-	handlerFunc func() error
+	// HandlerFunc is called when shutting down
+	HandlerFunc func() error
+
+	// CheckFunc returns error if the dependency is not ready
+	CheckFunc func(context ctx.CTX) error
+
+	server struct {
+		addr string
+		// serve blocks until the server is stopped
+		serve func() error
+		// stop stops accepting new requests and waits for in-flight requests until the context is done
+		stop func(context.Context) error
+		// close closes the server and the in-flight requests immediately
+		close func() error
+		// timeout is the deadline of finishing in-flight requests
+		timeout time.Duration
+	}
 
 	serveOption struct {
-		addr          string
-		ginRouter     *gin.Engine
+		servers       []server
 		debugAddr     string
 		shutdownLevel int
 	}
@@ -38,7 +82,9 @@ type (
 	Option func(*serveOption)
 )
 
-func addShutdownHandler(handler handlerFunc, options ...Option) {
+// AddShutdownHandler registers the handler called when shutting down. Handlers are called from
+// the lowest shutdown level, handlers at the same level are called concurrently.
+func AddShutdownHandler(handler HandlerFunc, options ...Option) {
 	o := &serveOption{}
 	for _, opt := range options {
 		opt(o)
@@ -50,19 +96,12 @@ func addShutdownHandler(handler handlerFunc, options ...Option) {
 }
 
 func hookShutdownHandler(name string) {
-	term := make(chan os.Signal)
-	termCheck := make(chan os.Signal)
-	allSignal := make(chan os.Signal)
+	term := make(chan os.Signal, 1)
+	allSignal := make(chan os.Signal, 1)
 	// seems like it's impossible to get SIGKILL
 	signal.Notify(term, syscall.SIGTERM, syscall.SIGINT, syscall.SIGKILL)
-	signal.Notify(termCheck, syscall.SIGTERM, syscall.SIGINT, syscall.SIGKILL)
 	signal.Notify(allSignal)
 
-	type orderedHandler struct {
-		level    int
-		handlers []handlerFunc
-	}
-
 	// log all signals
 	go func() {
 		for s := range allSignal {
@@ -77,47 +116,136 @@ func hookShutdownHandler(name string) {
 	go func() {
 		<-term
 		logrus.Info("Got terminated signal")
-		termTime := time.Now()
+		shutdown(name)
+		close(shutdownFinished)
+	}()
+}
 
-		oHlrs := []orderedHandler{}
-		for lvl, hlrs := range shutdownHandlers {
-			oHlrs = append(oHlrs, orderedHandler{level: lvl, handlers: hlrs})
-		}
-		// sort in ascending order
-		sort.Slice(oHlrs, func(i, j int) bool { return oHlrs[i].level < oHlrs[j].level })
-
-		var termWg sync.WaitGroup
-		for _, oHlr := range oHlrs {
-			// Registered callbacks at the same level run concurrently
-			for i := range oHlr.handlers {
-				cb := oHlr.handlers[i]
-				termWg.Add(1)
-				go func() {
-					defer termWg.Done()
-					cb()
-				}()
-			}
-			termWg.Wait()
+// shutdown fails the readiness and calls the shutdown handlers from the lowest level
+func shutdown(name string) {
+	type orderedHandler struct {
+		level    int
+		handlers []HandlerFunc
+	}
+
+	// fail the readiness before anything is shut down, so load balancers drain us first
+	atomic.StoreInt32(&shuttingDown, 1)
+	termTime := time.Now()
+
+	shutdownHandlerMutex.Lock()
+	oHlrs := []orderedHandler{}
+	for lvl, hlrs := range shutdownHandlers {
+		oHlrs = append(oHlrs, orderedHandler{level: lvl, handlers: hlrs})
+	}
+	shutdownHandlerMutex.Unlock()
+	// sort in ascending order
+	sort.Slice(oHlrs, func(i, j int) bool { return oHlrs[i].level < oHlrs[j].level })
+
+	var termWg sync.WaitGroup
+	for _, oHlr := range oHlrs {
+		// Registered callbacks at the same level run concurrently
+		for i := range oHlr.handlers {
+			cb := oHlr.handlers[i]
+			termWg.Add(1)
+			go func() {
+				defer termWg.Done()
+				if err := cb(); err != nil {
+					logrus.WithField("err", err).Error("shutdown handler failed")
+				}
+			}()
 		}
-		t := float64(time.Since(termTime) / time.Second)
-		logrus.Info(fmt.Sprintf("shutdown callbacks finished in %fs", t))
-	}()
+		termWg.Wait()
+	}
+	t := float64(time.Since(termTime) / time.Second)
+	logrus.Info(fmt.Sprintf("%s shutdown callbacks finished in %fs", name, t))
+}
 
-	go func() {
-		<-termCheck
-		time.Sleep(25 * time.Second)
-		logrus.Warn("Time limit of graceful shutdown exceeded.")
-	}()
+// addShutdownDelay waits for the delay at DelayShutdownLevel, the readiness fails during it
+func addShutdownDelay(delay time.Duration) {
+	if delay <= 0 {
+		return
+	}
+
+	AddShutdownHandler(func() error {
+		logrus.WithField("delay", delay).Info("readiness failed, waiting before shutting down")
+		time.Sleep(delay)
+		return nil
+	}, WithShutdownLevel(DelayShutdownLevel))
 }
 
-func startMonitorServer(addr string) {
-	go func() {
-		if err := http.ListenAndServe(addr, nil); err != nil {
-			logrus.Warnf("Cannot start the monitor server: %v", err)
+// AddReadinessCheck registers the check of /readyz, the server is not ready if any check fails
+func AddReadinessCheck(name string, check CheckFunc) {
+	readinessCheckMutex.Lock()
+	defer readinessCheckMutex.Unlock()
+	readinessChecks[name] = check
+}
+
+// healthz responds OK as long as the process is alive
+func healthz(w http.ResponseWriter, r *http.Request) {
+	writeStatus(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// readyz responds OK if the server is not shutting down and all readiness checks pass
+func readyz(w http.ResponseWriter, r *http.Request) {
+	if atomic.LoadInt32(&shuttingDown) == 1 {
+		writeStatus(w, http.StatusServiceUnavailable, map[string]string{"status": "shutting down"})
+		return
+	}
+
+	context, cancel := ctx.WithTimeout(ctx.WithContext(r.Context(), logrus.Fields{"path": r.URL.Path}), readinessTimeout)
+	defer cancel()
+
+	readinessCheckMutex.Lock()
+	checks := map[string]CheckFunc{}
+	for name, check := range readinessChecks {
+		checks[name] = check
+	}
+	readinessCheckMutex.Unlock()
+
+	failed := map[string]string{}
+	for name, check := range checks {
+		if err := check(context); err != nil {
+			failed[name] = err.Error()
 		}
-	}()
+	}
+	if len(failed) > 0 {
+		writeStatus(w, http.StatusServiceUnavailable, map[string]interface{}{"status": "not ready", "checks": failed})
+		return
+	}
+
+	writeStatus(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func writeStatus(w http.ResponseWriter, code int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		logrus.WithField("err", err).Error("json.Encode failed")
+	}
+}
+
+// newMonitorServer returns the monitor server serving prometheus metrics at /metrics, pprof at
+// /debug/pprof, liveness at /healthz and readiness at /readyz
+func newMonitorServer(addr string) *http.Server {
+	// a private mux, so they're not exposed by the servers using http.DefaultServeMux. Importing
+	// net/http/pprof still registers its handlers on http.DefaultServeMux, don't serve it.
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/healthz", healthz)
+	mux.HandleFunc("/readyz", readyz)
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	return &http.Server{Addr: addr, Handler: mux}
 }
 
+// Serve serves the servers and the monitor server until the terminated signal is received.
+// The servers stop accepting new requests at the shutdown level and are closed if in-flight
+// requests are not finished before their deadlines. It returns after all shutdown handlers
+// are finished, or when any server fails.
 func Serve(name string, opts ...Option) error {
 	o := &serveOption{}
 	for _, opt := range opts {
@@ -128,16 +256,102 @@ func Serve(name string, opts ...Option) error {
 		o.debugAddr = defaultDebugAddr
 	}
 
-	hookShutdownHandler(name)
-	startMonitorServer(o.debugAddr)
+	hookOnce.Do(func() {
+		hookShutdownHandler(name)
+		addShutdownDelay(time.Duration(*shutdownDelay) * time.Second)
+	})
 
 	gin.EnableJsonDecoderUseNumber()
-	addShutdownHandler(func() error {
-		manners.Close()
+	servers := append(o.servers, httpServer(newMonitorServer(o.debugAddr), time.Duration(*drainTimeout)*time.Second))
+	failed := make(chan error, len(servers))
+	for i, s := range servers {
+		level := o.shutdownLevel
+		if i == len(servers)-1 {
+			level = MonitorShutdownLevel
+		}
+		AddShutdownHandler(s.shutdown, WithShutdownLevel(level))
+
+		go func(s server) {
+			if err := s.serve(); err != nil {
+				logrus.WithFields(logrus.Fields{
+					"err":  err,
+					"addr": s.addr,
+				}).Error("server.serve failed")
+				failed <- err
+			}
+		}(s)
+	}
+
+	select {
+	case err := <-failed:
+		return err
+	case <-shutdownFinished:
 		return nil
-	}, WithShutdownLevel(o.shutdownLevel))
+	}
+}
+
+// shutdown waits for in-flight requests until the deadline, and closes the server after that
+func (s server) shutdown() error {
+	c, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	if err := s.stop(c); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"err":  err,
+			"addr": s.addr,
+		}).Warn("Time limit of graceful shutdown exceeded.")
+		return s.close()
+	}
 
-	return manners.ListenAndServe(o.addr, o.ginRouter)
+	return nil
+}
+
+func httpServer(s *http.Server, timeout time.Duration) server {
+	return server{
+		addr: s.Addr,
+		serve: func() error {
+			if err := s.ListenAndServe(); err != http.ErrServerClosed {
+				return err
+			}
+			return nil
+		},
+		stop:    s.Shutdown,
+		close:   s.Close,
+		timeout: timeout,
+	}
+}
+
+func grpcServer(addr string, s *grpc.Server, timeout time.Duration) server {
+	return server{
+		addr: addr,
+		serve: func() error {
+			lis, err := net.Listen("tcp", addr)
+			if err != nil {
+				return err
+			}
+			// it returns nil after the server is stopped
+			return s.Serve(lis)
+		},
+		stop: func(c context.Context) error {
+			stopped := make(chan struct{})
+			go func() {
+				s.GracefulStop()
+				close(stopped)
+			}()
+
+			select {
+			case <-stopped:
+				return nil
+			case <-c.Done():
+				return c.Err()
+			}
+		},
+		close: func() error {
+			s.Stop()
+			return nil
+		},
+		timeout: timeout,
+	}
 }
 
 // WithShutdownLevel adds shutdown level to shutdown handler
@@ -147,10 +361,30 @@ func WithShutdownLevel(level int) Option {
 	}
 }
 
-// WithGinRouter adds a gin router
+// WithDebugAddr sets the address of the monitor server
+func WithDebugAddr(addr string) Option {
+	return func(o *serveOption) {
+		o.debugAddr = addr
+	}
+}
+
+// WithGinRouter adds a server serving the gin router, in-flight requests are waited for
+// drain_timeout when shutting down
 func WithGinRouter(addr string, router *gin.Engine) Option {
+	return WithServer(&http.Server{Addr: addr, Handler: router}, time.Duration(*drainTimeout)*time.Second)
+}
+
+// WithServer adds a server, in-flight requests are waited for the timeout when shutting down
+func WithServer(s *http.Server, timeout time.Duration) Option {
+	return func(o *serveOption) {
+		o.servers = append(o.servers, httpServer(s, timeout))
+	}
+}
+
+// WithGRPCServer adds a gRPC server listening on the addr, in-flight requests are waited for
+// drain_timeout when shutting down
+func WithGRPCServer(addr string, s *grpc.Server) Option {
 	return func(o *serveOption) {
-		o.addr = addr
-		o.ginRouter = router
+		o.servers = append(o.servers, grpcServer(addr, s, time.Duration(*drainTimeout)*time.Second))
 	}
 }
@@ -0,0 +1,124 @@
+package facility
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/chihkaiyu/ratelimiter/base/ctx"
+)
+
+type facilitySuite struct {
+	suite.Suite
+}
+
+func TestFacilitySuite(t *testing.T) {
+	suite.Run(t, new(facilitySuite))
+}
+
+func (s *facilitySuite) TearDownTest() {
+	readinessChecks = map[string]CheckFunc{}
+	shutdownHandlers = map[int][]HandlerFunc{}
+	atomic.StoreInt32(&shuttingDown, 0)
+}
+
+func (s *facilitySuite) TestHealthz() {
+	w := httptest.NewRecorder()
+	healthz(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	s.Equal(http.StatusOK, w.Code)
+}
+
+func (s *facilitySuite) TestReadyz() {
+	tests := []struct {
+		Desc         string
+		Check        error
+		ShuttingDown bool
+		ExpCode      int
+		ExpBody      string
+	}{
+		{
+			Desc:    "ready",
+			ExpCode: http.StatusOK,
+			ExpBody: `{"status":"ok"}`,
+		},
+		{
+			Desc:    "check failed",
+			Check:   errors.New("connection refused"),
+			ExpCode: http.StatusServiceUnavailable,
+			ExpBody: `{"checks":{"redis":"connection refused"},"status":"not ready"}`,
+		},
+		{
+			Desc:         "shutting down",
+			ShuttingDown: true,
+			ExpCode:      http.StatusServiceUnavailable,
+			ExpBody:      `{"status":"shutting down"}`,
+		},
+	}
+
+	for _, t := range tests {
+		s.TearDownTest()
+		err := t.Check
+		AddReadinessCheck("redis", func(context ctx.CTX) error {
+			return err
+		})
+		if t.ShuttingDown {
+			atomic.StoreInt32(&shuttingDown, 1)
+		}
+
+		w := httptest.NewRecorder()
+		readyz(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		s.Equal(t.ExpCode, w.Code, t.Desc)
+		s.JSONEq(t.ExpBody, w.Body.String(), t.Desc)
+	}
+}
+
+func (s *facilitySuite) TestMonitorServer() {
+	handler := newMonitorServer(defaultDebugAddr).Handler
+	s.NotEqual(http.DefaultServeMux, handler)
+
+	for _, path := range []string{"/metrics", "/healthz", "/readyz", "/debug/pprof/", "/debug/pprof/cmdline"} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		s.Equal(http.StatusOK, w.Code, path)
+	}
+
+	// mounting twice doesn't panic, the handlers are not registered globally
+	s.NotPanics(func() { newMonitorServer(defaultDebugAddr) })
+}
+
+func (s *facilitySuite) TestShutdownDelay() {
+	// readyz responds the status when the handler of each level starts
+	status := func() int {
+		w := httptest.NewRecorder()
+		readyz(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		return w.Code
+	}
+	var delayStatus, serverStatus int
+	var serverStart time.Time
+
+	addShutdownDelay(50 * time.Millisecond)
+	AddShutdownHandler(func() error {
+		delayStatus = status()
+		return nil
+	}, WithShutdownLevel(DelayShutdownLevel))
+	AddShutdownHandler(func() error {
+		serverStatus, serverStart = status(), time.Now()
+		return nil
+	})
+
+	start := time.Now()
+	shutdown("test")
+	// the readiness fails during the delay, and the servers are shut down after it
+	s.Equal(http.StatusServiceUnavailable, delayStatus)
+	s.Equal(http.StatusServiceUnavailable, serverStatus)
+	s.True(serverStart.Sub(start) >= 50*time.Millisecond)
+
+	// no delay if it's disabled
+	addShutdownDelay(0)
+	s.Len(shutdownHandlers[DelayShutdownLevel], 2)
+}
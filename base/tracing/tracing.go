@@ -0,0 +1,124 @@
+package tracing
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpgrpc"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/propagation"
+	sdkresource "go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/semconv"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/chihkaiyu/ratelimiter/base/ctx"
+)
+
+const (
+	tracerName = "github.com/chihkaiyu/ratelimiter"
+
+	// KeyHash is the attribute of hashed limited key, we don't record raw keys since they may be IPs
+	KeyHash = label.Key("ratelimiter.key_hash")
+	// Rule is the attribute of rule name
+	Rule = label.Key("ratelimiter.rule")
+	// Level is the attribute of level name
+	Level = label.Key("ratelimiter.level")
+	// Strategy is the attribute of strategy name
+	Strategy = label.Key("ratelimiter.strategy")
+	// Result is the attribute of decision result
+	Result = label.Key("ratelimiter.result")
+	// Remaining is the attribute of remaining quota
+	Remaining = label.Key("ratelimiter.remaining")
+
+	// hashSize is the number of bytes of the hashed key
+	hashSize = 16
+)
+
+var (
+	keyHashSecret = flag.String("key_hash_secret", "", "the secret of hashing the keys in traces and audit log, a random one is generated if it's empty")
+
+	secret     []byte
+	secretOnce sync.Once
+
+	otlpEndpoint = flag.String("otlp_endpoint", "", "the OTLP gRPC endpoint exporting traces to: host:port, tracing is disabled if it's empty")
+	serviceName  = flag.String("tracing_service_name", "ratelimiter", "the service name of traces")
+)
+
+// Init sets the global tracer provider exporting spans by OTLP, returns the function flushing
+// and shutting down the exporter. It's no-op if the OTLP endpoint is not set.
+func Init() (func(context.Context) error, error) {
+	if *otlpEndpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlp.NewExporter(context.Background(), otlpgrpc.NewDriver(
+		otlpgrpc.WithInsecure(),
+		otlpgrpc.WithEndpoint(*otlpEndpoint),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	return Register(sdktrace.WithBatcher(exporter)), nil
+}
+
+// Register sets the global tracer provider with given options, returns the function
+// shutting down the provider
+func Register(opts ...sdktrace.TracerProviderOption) func(context.Context) error {
+	opts = append(opts, sdktrace.WithResource(sdkresource.NewWithAttributes(
+		semconv.ServiceNameKey.String(*serviceName),
+	)))
+	provider := sdktrace.NewTracerProvider(opts...)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{},
+	))
+
+	return provider.Shutdown
+}
+
+// Start starts a span as child of the span in given CTX, returns the CTX carrying the new span
+func Start(context ctx.CTX, name string, attrs ...label.KeyValue) (ctx.CTX, trace.Span) {
+	spanCtx, span := otel.Tracer(tracerName).Start(context.Context, name, trace.WithAttributes(attrs...))
+	return ctx.CTX{
+		Context:     spanCtx,
+		FieldLogger: context.FieldLogger,
+	}, span
+}
+
+// HashKey hashes the limited key by HMAC-SHA256 for recording it without leaking raw IPs or API keys.
+// The key space of IPs is small, so the hash is keyed by the secret to stop it from being reversed
+// by enumerating all IPs.
+func HashKey(key string) string {
+	secretOnce.Do(initSecret)
+	return hashKey(secret, key)
+}
+
+func hashKey(secret []byte, key string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(key))
+	return hex.EncodeToString(mac.Sum(nil)[:hashSize])
+}
+
+// initSecret sets the secret of HashKey from flag, or a random one if it's not set
+func initSecret() {
+	if *keyHashSecret != "" {
+		secret = []byte(*keyHashSecret)
+		return
+	}
+
+	secret = make([]byte, sha256.Size)
+	if _, err := rand.Read(secret); err != nil {
+		logrus.Panicf("rand.Read failed, err: %v", err)
+	}
+	logrus.Warn("key_hash_secret is not set, hashed keys can't be correlated across replicas and restarts")
+}
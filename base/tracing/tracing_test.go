@@ -0,0 +1,33 @@
+package tracing
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type tracingSuite struct {
+	suite.Suite
+}
+
+func TestTracingSuite(t *testing.T) {
+	suite.Run(t, new(tracingSuite))
+}
+
+func (s *tracingSuite) TestHashKey() {
+	act := hashKey([]byte("secret"), "1.2.3.4")
+	s.Len(act, 2*hashSize)
+	s.Equal(act, hashKey([]byte("secret"), "1.2.3.4"))
+	s.NotEqual(act, hashKey([]byte("secret"), "1.2.3.5"))
+	s.NotEqual(act, hashKey([]byte("another"), "1.2.3.4"))
+
+	// not the plain hash which could be reversed by hashing every IP
+	sum := sha256.Sum256([]byte("1.2.3.4"))
+	s.NotEqual(hex.EncodeToString(sum[:hashSize]), act)
+
+	// a random secret is generated if it's not set
+	s.Equal(HashKey("1.2.3.4"), HashKey("1.2.3.4"))
+	s.Len(secret, sha256.Size)
+}
@@ -0,0 +1,76 @@
+package client
+
+import (
+	"sync"
+	"time"
+
+	"github.com/chihkaiyu/ratelimiter/service/ratelimiter"
+)
+
+type deniedEntry struct {
+	decision ratelimiter.Decision
+	n        int
+	until    time.Time
+}
+
+// deniedCache remembers the denied descriptors until they're allowed to retry
+type deniedCache struct {
+	mutex   sync.Mutex
+	size    int
+	entries map[string]deniedEntry
+}
+
+func newDeniedCache(size int) *deniedCache {
+	return &deniedCache{
+		size:    size,
+		entries: map[string]deniedEntry{},
+	}
+}
+
+// get returns the denied decision of the key with the remaining retry-after, a request costing
+// less than the denied one may still be allowed, so it's not denied by the cache
+func (c *deniedCache) get(key string, n int, now time.Time) (ratelimiter.Decision, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return ratelimiter.Decision{}, false
+	}
+	if !now.Before(entry.until) {
+		delete(c.entries, key)
+		return ratelimiter.Decision{}, false
+	}
+	if n < entry.n {
+		return ratelimiter.Decision{}, false
+	}
+
+	decision := entry.decision
+	decision.RetryAfter = entry.until.Sub(now)
+	return decision, true
+}
+
+// set caches the denied decision until the given time. Expired entries are swept when the cache
+// is full, and the decision is not cached if it's still full.
+func (c *deniedCache) set(key string, n int, decision ratelimiter.Decision, until time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.size {
+		now := timeNow()
+		for k, entry := range c.entries {
+			if !now.Before(entry.until) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= c.size {
+			return
+		}
+	}
+
+	c.entries[key] = deniedEntry{
+		decision: decision,
+		n:        n,
+		until:    until,
+	}
+}
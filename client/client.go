@@ -0,0 +1,316 @@
+package client
+
+import (
+	gocontext "context"
+	"errors"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/chihkaiyu/ratelimiter/api/pb"
+	"github.com/chihkaiyu/ratelimiter/base/ctx"
+	"github.com/chihkaiyu/ratelimiter/service/ratelimiter"
+	"github.com/chihkaiyu/ratelimiter/service/ratelimiter/strategy"
+)
+
+const (
+	// FailureOpen allows the requests when the service is unreachable
+	FailureOpen = "open"
+	// FailureClosed returns the error when the service is unreachable, so the caller rejects the requests
+	FailureClosed = "closed"
+
+	defaultTimeout   = 200 * time.Millisecond
+	defaultRetries   = 2
+	defaultBackoff   = 50 * time.Millisecond
+	defaultPoolSize  = 1
+	defaultCacheSize = 10000
+)
+
+var (
+	// ErrInvalidPoolSize is returned by NewClient when the pool size is less than 1
+	ErrInvalidPoolSize = errors.New("pool size must be at least 1")
+	// ErrInvalidResponse is returned when the response of the service has no decision
+	ErrInvalidResponse = errors.New("invalid response of rate limiter service")
+
+	timeNow = time.Now
+)
+
+// Client is the client of the remote rate limiter service over gRPC. It implements ratelimiter.Limiter,
+// so it's a drop-in replacement of the local limiter, e.g. in api.NewRateLimiter. It doesn't implement
+// ratelimiter.Releaser since the service can't give back permits, so with several rules the permits
+// of the rules allowing a request are still counted when another rule denies it.
+type Client struct {
+	conns       []*grpc.ClientConn
+	clients     []pb.RateLimiterClient
+	next        uint32
+	dialOptions []grpc.DialOption
+	poolSize    int
+	keys        []string
+	timeout     time.Duration
+	retries     int
+	backoff     time.Duration
+	policy      string
+	cacheSize   int
+	cache       *deniedCache
+	token       string
+}
+
+// Option is an alias for functional argument in NewClient
+type Option func(*Client)
+
+// NewClient connects to the gRPC server of the service at addr. The connections are established
+// in background, so it doesn't fail when the service is unreachable.
+func NewClient(addr string, opts ...Option) (*Client, error) {
+	cl := &Client{
+		dialOptions: []grpc.DialOption{grpc.WithInsecure()},
+		poolSize:    defaultPoolSize,
+		timeout:     defaultTimeout,
+		retries:     defaultRetries,
+		backoff:     defaultBackoff,
+		policy:      FailureClosed,
+		cacheSize:   defaultCacheSize,
+	}
+	for _, opt := range opts {
+		opt(cl)
+	}
+	if cl.poolSize < 1 {
+		logrus.WithField("poolSize", cl.poolSize).Error("invalid pool size")
+		return nil, ErrInvalidPoolSize
+	}
+	cl.cache = newDeniedCache(cl.cacheSize)
+
+	for i := 0; i < cl.poolSize; i++ {
+		conn, err := grpc.Dial(addr, cl.dialOptions...)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"err":  err,
+				"addr": addr,
+			}).Error("grpc.Dial failed")
+			cl.Close()
+			return nil, err
+		}
+		cl.conns = append(cl.conns, conn)
+		cl.clients = append(cl.clients, pb.NewRateLimiterClient(conn))
+	}
+
+	return cl, nil
+}
+
+// WithDialOptions replaces the default dial options, which connect without TLS
+func WithDialOptions(opts ...grpc.DialOption) Option {
+	return func(cl *Client) {
+		cl.dialOptions = opts
+	}
+}
+
+// WithPoolSize sets the number of connections, requests are sent through them in turn. It must be at least 1.
+func WithPoolSize(size int) Option {
+	return func(cl *Client) {
+		cl.poolSize = size
+	}
+}
+
+// WithKeys sets the keys sent to the service for the descriptors other than ratelimiter.Entries,
+// they should be the keys of the rule levels, e.g. "ip" and "header:X-Tenant-ID"
+func WithKeys(keys ...string) Option {
+	return func(cl *Client) {
+		cl.keys = keys
+	}
+}
+
+// WithTimeout sets the deadline of every attempt
+func WithTimeout(timeout time.Duration) Option {
+	return func(cl *Client) {
+		cl.timeout = timeout
+	}
+}
+
+// WithRetries sets the number of retries when the service is unavailable, the backoff is doubled
+// for every further retry. A retried request may be counted twice if the service counted the
+// failed attempt.
+func WithRetries(retries int, backoff time.Duration) Option {
+	return func(cl *Client) {
+		cl.retries = retries
+		cl.backoff = backoff
+	}
+}
+
+// WithFailurePolicy sets what to do when the service is unreachable, FailureOpen or FailureClosed
+func WithFailurePolicy(policy string) Option {
+	return func(cl *Client) {
+		cl.policy = policy
+	}
+}
+
+// WithToken sets the bearer token sent in the metadata authorization, it's the decision_token of the service
+func WithToken(token string) Option {
+	return func(cl *Client) {
+		cl.token = token
+	}
+}
+
+// WithCacheSize sets the maximum number of denied descriptors cached, 0 to disable the cache
+func WithCacheSize(size int) Option {
+	return func(cl *Client) {
+		cl.cacheSize = size
+	}
+}
+
+// Close closes the connections
+func (cl *Client) Close() error {
+	for _, conn := range cl.conns {
+		if err := conn.Close(); err != nil {
+			logrus.WithField("err", err).Error("conn.Close failed")
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Acquire acquires a permit, see AcquireN
+func (cl *Client) Acquire(context ctx.CTX, rule string, descriptor ratelimiter.Descriptor) (ratelimiter.Decision, error) {
+	return cl.AcquireN(context, rule, descriptor, 1)
+}
+
+// AcquireN asks the service for the decision. A denied descriptor is denied locally until its
+// retry-after without asking the service, unless the request costs less than the denied one.
+func (cl *Client) AcquireN(context ctx.CTX, rule string, descriptor ratelimiter.Descriptor, n int) (ratelimiter.Decision, error) {
+	entries := cl.entriesOf(descriptor)
+	key := cacheKeyOf(rule, entries)
+	if decision, ok := cl.cache.get(key, n, timeNow()); ok {
+		return decision, nil
+	}
+
+	pbEntries := []*pb.Descriptor_Entry{}
+	for k, v := range entries {
+		pbEntries = append(pbEntries, &pb.Descriptor_Entry{Key: k, Value: v})
+	}
+	resp, err := cl.call(context, &pb.ShouldRateLimitRequest{
+		Domain:      rule,
+		Descriptors: []*pb.Descriptor{{Entries: pbEntries}},
+		Hits:        uint32(n),
+	})
+	if status.Code(err) == codes.NotFound {
+		return ratelimiter.Decision{}, ratelimiter.ErrRuleNotFound
+	}
+	if err == nil && len(resp.Decisions) == 0 {
+		context.WithField("rule", rule).Error("response has no decision")
+		err = ErrInvalidResponse
+	}
+	if err != nil {
+		if cl.policy == FailureOpen {
+			context.WithFields(logrus.Fields{
+				"err":  err,
+				"rule": rule,
+			}).Warn("rate limiter service is unreachable, the request is allowed")
+			return ratelimiter.Decision{Decision: strategy.Decision{Allowed: true}, Rule: rule}, nil
+		}
+		return ratelimiter.Decision{}, err
+	}
+
+	decision := decisionOf(rule, resp.Decisions[0])
+	if !decision.Allowed && decision.RetryAfter > 0 {
+		cl.cache.set(key, n, decision, timeNow().Add(decision.RetryAfter))
+	}
+
+	return decision, nil
+}
+
+// Wait acquires n permits, it waits until they're allowed instead of being denied, see ratelimiter.Wait
+func (cl *Client) Wait(context ctx.CTX, rule string, descriptor ratelimiter.Descriptor, n int) (ratelimiter.Decision, error) {
+	return ratelimiter.Wait(context, cl, rule, descriptor, n)
+}
+
+// call sends the request through the connections in turn, and retries when the service is unavailable
+func (cl *Client) call(context ctx.CTX, req *pb.ShouldRateLimitRequest) (*pb.ShouldRateLimitResponse, error) {
+	var err error
+	backoff := cl.backoff
+	for attempt := 0; ; attempt++ {
+		client := cl.clients[int(atomic.AddUint32(&cl.next, 1))%len(cl.clients)]
+		c, cancel := gocontext.WithTimeout(context, cl.timeout)
+		if cl.token != "" {
+			c = metadata.AppendToOutgoingContext(c, "authorization", "Bearer "+cl.token)
+		}
+		var resp *pb.ShouldRateLimitResponse
+		resp, err = client.ShouldRateLimit(c, req)
+		cancel()
+		if err == nil {
+			return resp, nil
+		}
+		if status.Code(err) != codes.Unavailable || attempt >= cl.retries {
+			break
+		}
+
+		context.WithFields(logrus.Fields{
+			"err":     err,
+			"attempt": attempt,
+		}).Warn("client.ShouldRateLimit failed, retrying")
+		select {
+		case <-time.After(backoff):
+		case <-context.Done():
+			return nil, context.Err()
+		}
+		backoff *= 2
+	}
+
+	if status.Code(err) != codes.NotFound {
+		context.WithFields(logrus.Fields{
+			"err":  err,
+			"rule": req.Domain,
+		}).Error("client.ShouldRateLimit failed")
+	}
+	return nil, err
+}
+
+// entriesOf returns the entries sent to the service
+func (cl *Client) entriesOf(descriptor ratelimiter.Descriptor) ratelimiter.Entries {
+	if entries, ok := descriptor.(ratelimiter.Entries); ok {
+		return entries
+	}
+
+	entries := ratelimiter.Entries{}
+	for _, key := range cl.keys {
+		if value, ok := descriptor.Value(key); ok {
+			entries[key] = value
+		}
+	}
+
+	return entries
+}
+
+// cacheKeyOf returns the key of the denied cache, entries are sorted so the key is stable
+func cacheKeyOf(rule string, entries ratelimiter.Entries) string {
+	pairs := []string{}
+	for k, v := range entries {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+
+	return rule + "\x00" + strings.Join(pairs, "\x00")
+}
+
+func decisionOf(rule string, d *pb.Decision) ratelimiter.Decision {
+	return ratelimiter.Decision{
+		Decision: strategy.Decision{
+			Allowed:    d.Allowed,
+			Count:      int(d.Count),
+			Limit:      int(d.Limit),
+			Remaining:  int(d.Remaining),
+			RetryAfter: d.RetryAfter.AsDuration(),
+			ResetAfter: d.ResetAfter.AsDuration(),
+		},
+		Rule:       rule,
+		Level:      d.Level,
+		Strategy:   d.Strategy,
+		Key:        d.Key,
+		WouldBlock: d.WouldBlock,
+	}
+}
@@ -0,0 +1,312 @@
+package client
+
+import (
+	gocontext "context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/chihkaiyu/ratelimiter/api/pb"
+	"github.com/chihkaiyu/ratelimiter/base/ctx"
+	"github.com/chihkaiyu/ratelimiter/service/ratelimiter"
+)
+
+var (
+	mockCTX = ctx.Background()
+)
+
+type mockServer struct {
+	pb.UnimplementedRateLimiterServer
+	mock.Mock
+}
+
+func (m *mockServer) ShouldRateLimit(c gocontext.Context, req *pb.ShouldRateLimitRequest) (*pb.ShouldRateLimitResponse, error) {
+	entries := map[string]string{}
+	for _, entry := range req.Descriptors[0].Entries {
+		entries[entry.Key] = entry.Value
+	}
+	args := m.Called(req.Domain, entries, int(req.Hits))
+	resp, _ := args.Get(0).(*pb.ShouldRateLimitResponse)
+	return resp, args.Error(1)
+}
+
+// mockDescriptor is a descriptor other than ratelimiter.Entries, e.g. a request
+type mockDescriptor map[string]string
+
+func (d mockDescriptor) Value(key string) (string, bool) {
+	value, ok := d[key]
+	return value, ok
+}
+
+type clientSuite struct {
+	suite.Suite
+	server *mockServer
+	grpc   *grpc.Server
+	lis    *bufconn.Listener
+	now    time.Time
+	// authorization is the metadata authorization of the last call
+	authorization []string
+}
+
+func TestClientSuite(t *testing.T) {
+	suite.Run(t, new(clientSuite))
+}
+
+func (s *clientSuite) SetupTest() {
+	s.server = new(mockServer)
+	s.authorization = nil
+	s.grpc = grpc.NewServer(grpc.UnaryInterceptor(
+		func(c gocontext.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			md, _ := metadata.FromIncomingContext(c)
+			s.authorization = md.Get("authorization")
+			return handler(c, req)
+		},
+	))
+	pb.RegisterRateLimiterServer(s.grpc, s.server)
+	s.lis = bufconn.Listen(1024 * 1024)
+	go s.grpc.Serve(s.lis)
+
+	s.now = time.Unix(1600000000, 0)
+	timeNow = func() time.Time { return s.now }
+}
+
+func (s *clientSuite) TearDownTest() {
+	s.server.AssertExpectations(s.T())
+	s.grpc.Stop()
+	timeNow = time.Now
+}
+
+func (s *clientSuite) newClient(opts ...Option) *Client {
+	opts = append([]Option{
+		WithDialOptions(
+			grpc.WithContextDialer(func(gocontext.Context, string) (net.Conn, error) { return s.lis.Dial() }),
+			grpc.WithInsecure(),
+		),
+		WithRetries(2, time.Millisecond),
+		WithTimeout(time.Second),
+	}, opts...)
+	cl, err := NewClient("bufnet", opts...)
+	s.Require().NoError(err)
+	return cl
+}
+
+func deniedResponse(retryAfter time.Duration) *pb.ShouldRateLimitResponse {
+	return &pb.ShouldRateLimitResponse{
+		Allowed: false,
+		Decisions: []*pb.Decision{{
+			Allowed:    false,
+			Level:      "user",
+			Key:        "bob",
+			Count:      11,
+			Limit:      10,
+			RetryAfter: durationpb.New(retryAfter),
+			ResetAfter: durationpb.New(0),
+		}},
+	}
+}
+
+func (s *clientSuite) TestAcquireN() {
+	cl := s.newClient(WithKeys("user", "ip"), WithPoolSize(2))
+	defer cl.Close()
+
+	resp := &pb.ShouldRateLimitResponse{
+		Allowed: true,
+		Decisions: []*pb.Decision{{
+			Allowed:    true,
+			Level:      "user",
+			Strategy:   "fixed_window",
+			Key:        "bob",
+			Count:      3,
+			Limit:      10,
+			Remaining:  7,
+			RetryAfter: durationpb.New(0),
+			ResetAfter: durationpb.New(time.Second),
+		}},
+	}
+	s.server.On("ShouldRateLimit", "api", map[string]string{"user": "bob"}, 3).Return(resp, nil).Twice()
+	s.server.On("ShouldRateLimit", "api", map[string]string{"tenant": "acme"}, 1).Return(resp, nil).Once()
+
+	// only the keys configured are sent for the descriptors other than entries
+	decision, err := cl.AcquireN(mockCTX, "api", mockDescriptor{"user": "bob", "path": "/"}, 3)
+	s.Require().NoError(err)
+	s.True(decision.Allowed)
+	s.Equal("api", decision.Rule)
+	s.Equal("user", decision.Level)
+	s.Equal("fixed_window", decision.Strategy)
+	s.Equal(7, decision.Remaining)
+	s.Equal(time.Second, decision.ResetAfter)
+
+	_, err = cl.AcquireN(mockCTX, "api", ratelimiter.Entries{"user": "bob"}, 3)
+	s.Require().NoError(err)
+	_, err = cl.Acquire(mockCTX, "api", ratelimiter.Entries{"tenant": "acme"})
+	s.Require().NoError(err)
+}
+
+func (s *clientSuite) TestInvalidPoolSize() {
+	for _, size := range []int{0, -1} {
+		cl, err := NewClient("bufnet", WithPoolSize(size))
+		s.Equal(ErrInvalidPoolSize, err, size)
+		s.Nil(cl, size)
+	}
+}
+
+func (s *clientSuite) TestDeniedCache() {
+	cl := s.newClient()
+	defer cl.Close()
+
+	s.server.On("ShouldRateLimit", "api", map[string]string{"user": "bob"}, 2).Return(deniedResponse(2*time.Second), nil).Once()
+	s.server.On("ShouldRateLimit", "api", map[string]string{"user": "bob"}, 1).Return(deniedResponse(time.Second), nil).Once()
+
+	decision, err := cl.AcquireN(mockCTX, "api", ratelimiter.Entries{"user": "bob"}, 2)
+	s.Require().NoError(err)
+	s.False(decision.Allowed)
+	s.Equal(2*time.Second, decision.RetryAfter)
+
+	// denied by the cache with the remaining retry-after
+	s.now = s.now.Add(500 * time.Millisecond)
+	decision, err = cl.AcquireN(mockCTX, "api", ratelimiter.Entries{"user": "bob"}, 5)
+	s.Require().NoError(err)
+	s.False(decision.Allowed)
+	s.Equal("user", decision.Level)
+	s.Equal(1500*time.Millisecond, decision.RetryAfter)
+
+	// a cheaper request is asked
+	decision, err = cl.AcquireN(mockCTX, "api", ratelimiter.Entries{"user": "bob"}, 1)
+	s.Require().NoError(err)
+	s.False(decision.Allowed)
+
+	// other descriptors are not affected
+	s.server.On("ShouldRateLimit", "api", map[string]string{"user": "alice"}, 2).Return(deniedResponse(0), nil).Once()
+	_, err = cl.AcquireN(mockCTX, "api", ratelimiter.Entries{"user": "alice"}, 2)
+	s.Require().NoError(err)
+
+	// asked again after retry-after
+	s.server.On("ShouldRateLimit", "api", map[string]string{"user": "bob"}, 2).Return(deniedResponse(time.Second), nil).Once()
+	s.now = s.now.Add(2 * time.Second)
+	_, err = cl.AcquireN(mockCTX, "api", ratelimiter.Entries{"user": "bob"}, 2)
+	s.Require().NoError(err)
+}
+
+func (s *clientSuite) TestDeniedCacheDisabled() {
+	cl := s.newClient(WithCacheSize(0))
+	defer cl.Close()
+
+	s.server.On("ShouldRateLimit", "api", map[string]string{"user": "bob"}, 1).Return(deniedResponse(time.Second), nil).Twice()
+	for i := 0; i < 2; i++ {
+		decision, err := cl.Acquire(mockCTX, "api", ratelimiter.Entries{"user": "bob"})
+		s.Require().NoError(err)
+		s.False(decision.Allowed)
+	}
+}
+
+func (s *clientSuite) TestRetry() {
+	cl := s.newClient()
+	defer cl.Close()
+
+	s.server.On("ShouldRateLimit", "api", map[string]string{"user": "bob"}, 1).Return(nil, status.Error(codes.Unavailable, "rate limiter unavailable")).Twice()
+	s.server.On("ShouldRateLimit", "api", map[string]string{"user": "bob"}, 1).Return(deniedResponse(0), nil).Once()
+
+	decision, err := cl.Acquire(mockCTX, "api", ratelimiter.Entries{"user": "bob"})
+	s.Require().NoError(err)
+	s.False(decision.Allowed)
+}
+
+func (s *clientSuite) TestFailurePolicy() {
+	s.server.On("ShouldRateLimit", "api", map[string]string{"user": "bob"}, 1).Return(nil, status.Error(codes.Unavailable, "rate limiter unavailable")).Times(6)
+	s.server.On("ShouldRateLimit", "not-exist", map[string]string{"user": "bob"}, 1).Return(nil, status.Error(codes.NotFound, "rule not found")).Twice()
+
+	tests := []struct {
+		Desc       string
+		Policy     string
+		Rule       string
+		ExpAllowed bool
+		ExpErr     bool
+	}{
+		{
+			Desc:   "closed",
+			Policy: FailureClosed,
+			Rule:   "api",
+			ExpErr: true,
+		},
+		{
+			Desc:       "open",
+			Policy:     FailureOpen,
+			Rule:       "api",
+			ExpAllowed: true,
+		},
+		{
+			Desc:   "rule not found is not a failure",
+			Policy: FailureOpen,
+			Rule:   "not-exist",
+			ExpErr: true,
+		},
+		{
+			Desc:   "rule not found with closed policy",
+			Policy: FailureClosed,
+			Rule:   "not-exist",
+			ExpErr: true,
+		},
+	}
+
+	for _, t := range tests {
+		cl := s.newClient(WithFailurePolicy(t.Policy))
+		decision, err := cl.Acquire(mockCTX, t.Rule, ratelimiter.Entries{"user": "bob"})
+		s.Equal(t.ExpErr, err != nil, t.Desc)
+		s.Equal(t.ExpAllowed, decision.Allowed, t.Desc)
+		if t.Rule == "not-exist" {
+			s.Equal(ratelimiter.ErrRuleNotFound, err, t.Desc)
+		}
+		cl.Close()
+	}
+}
+
+func (s *clientSuite) TestInvalidResponse() {
+	s.server.On("ShouldRateLimit", "api", map[string]string{"user": "bob"}, 1).Return(&pb.ShouldRateLimitResponse{Allowed: true}, nil).Twice()
+
+	// a response without decision is a failure of the service
+	cl := s.newClient()
+	defer cl.Close()
+	_, err := cl.Acquire(mockCTX, "api", ratelimiter.Entries{"user": "bob"})
+	s.Equal(ErrInvalidResponse, err)
+
+	cl = s.newClient(WithFailurePolicy(FailureOpen))
+	defer cl.Close()
+	decision, err := cl.Acquire(mockCTX, "api", ratelimiter.Entries{"user": "bob"})
+	s.NoError(err)
+	s.True(decision.Allowed)
+}
+
+func (s *clientSuite) TestUnreachable() {
+	s.grpc.Stop()
+	cl := s.newClient(WithFailurePolicy(FailureOpen), WithTimeout(50*time.Millisecond))
+	defer cl.Close()
+
+	decision, err := cl.Acquire(mockCTX, "api", ratelimiter.Entries{"user": "bob"})
+	s.Require().NoError(err)
+	s.True(decision.Allowed)
+}
+
+func (s *clientSuite) TestToken() {
+	s.server.On("ShouldRateLimit", "api", map[string]string{"user": "bob"}, 1).Return(deniedResponse(0), nil).Twice()
+
+	cl := s.newClient()
+	defer cl.Close()
+	_, err := cl.Acquire(mockCTX, "api", ratelimiter.Entries{"user": "bob"})
+	s.Require().NoError(err)
+	s.Empty(s.authorization)
+
+	cl = s.newClient(WithToken("secret"))
+	defer cl.Close()
+	_, err = cl.Acquire(mockCTX, "api", ratelimiter.Entries{"user": "bob"})
+	s.Require().NoError(err)
+	s.Equal([]string{"Bearer secret"}, s.authorization)
+}
@@ -0,0 +1,134 @@
+package client
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/chihkaiyu/ratelimiter/base/ctx"
+	"github.com/chihkaiyu/ratelimiter/service/ratelimiter"
+)
+
+const (
+	headerKeyPrefix = "header:"
+)
+
+var (
+	// ErrRateLimited is returned by Transport when the outbound request is denied
+	ErrRateLimited = errors.New("rate limited")
+)
+
+// Transport limits the outbound requests by a rule before sending them, e.g. to respect the quota of
+// a vendor. With a limiter shared by replicas, e.g. the redis backed ratelimiter.Service or Client,
+// all replicas together respect the limit.
+type Transport struct {
+	base    http.RoundTripper
+	limiter ratelimiter.Limiter
+	rule    string
+	maxWait time.Duration
+}
+
+// TransportOption is an alias for functional argument in NewTransport
+type TransportOption func(*Transport)
+
+// NewTransport returns the transport acquiring from the rule of the limiter. The requests are
+// described by "host", "method", "path" and "header:<name>".
+func NewTransport(limiter ratelimiter.Limiter, rule string, opts ...TransportOption) *Transport {
+	t := &Transport{
+		base:    http.DefaultTransport,
+		limiter: limiter,
+		rule:    rule,
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+
+	return t
+}
+
+// WithBase sets the transport sending the allowed requests, http.DefaultTransport by default
+func WithBase(base http.RoundTripper) TransportOption {
+	return func(t *Transport) {
+		t.base = base
+	}
+}
+
+// WithMaxWait waits until the request is allowed by ratelimiter.Wait if it takes at most maxWait,
+// otherwise the request fails with ErrRateLimited immediately. The requests fail fast by default.
+func WithMaxWait(maxWait time.Duration) TransportOption {
+	return func(t *Transport) {
+		t.maxWait = maxWait
+	}
+}
+
+// outboundDescriptor describes the outbound request
+type outboundDescriptor struct {
+	req *http.Request
+}
+
+func (d outboundDescriptor) Value(key string) (string, bool) {
+	switch {
+	case key == "host":
+		return d.req.URL.Host, true
+	case key == "method":
+		return d.req.Method, true
+	case key == "path":
+		return d.req.URL.Path, true
+	case strings.HasPrefix(key, headerKeyPrefix):
+		value := d.req.Header.Get(strings.TrimPrefix(key, headerKeyPrefix))
+		return value, value != ""
+	}
+
+	return "", false
+}
+
+// RoundTrip implements http.RoundTripper. It fails with ErrRateLimited when the request is denied,
+// and with the error of the limiter when the limiter fails, so the request is never sent over the limit.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	context := ctx.WithContext(req.Context(), logrus.Fields{
+		"rule": t.rule,
+		"host": req.URL.Host,
+	})
+
+	if err := t.acquire(context, outboundDescriptor{req: req}); err != nil {
+		// RoundTrip must always close the body, including on errors
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+
+	return t.base.RoundTrip(req)
+}
+
+func (t *Transport) acquire(context ctx.CTX, descriptor ratelimiter.Descriptor) error {
+	var decision ratelimiter.Decision
+	var err error
+	if t.maxWait > 0 {
+		waitCTX, cancel := ctx.WithTimeout(context, t.maxWait)
+		decision, err = ratelimiter.Wait(waitCTX, t.limiter, t.rule, descriptor, 1)
+		// the wait is stopped by maxWait rather than the request
+		if err != nil && context.Err() == nil && waitCTX.Err() != nil {
+			err = ratelimiter.ErrWaitExceedsDeadline
+		}
+		cancel()
+	} else {
+		decision, err = t.limiter.Acquire(context, t.rule, descriptor)
+	}
+
+	switch {
+	case err == ratelimiter.ErrWaitExceedsDeadline || err == ratelimiter.ErrExceedsLimit || (err == nil && !decision.Allowed):
+		context.WithField("reason", decision.Reason()).Info("outbound request rejected")
+		return ErrRateLimited
+	case err != nil && context.Err() != nil:
+		return err
+	case err != nil:
+		context.WithField("err", err).Error("limiter.Acquire failed")
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,183 @@
+package client
+
+import (
+	gocontext "context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/chihkaiyu/ratelimiter/base/ctx"
+	"github.com/chihkaiyu/ratelimiter/service/ratelimiter"
+	"github.com/chihkaiyu/ratelimiter/service/ratelimiter/strategy"
+)
+
+type mockLimiter struct {
+	mock.Mock
+}
+
+func (m *mockLimiter) Acquire(context ctx.CTX, rule string, descriptor ratelimiter.Descriptor) (ratelimiter.Decision, error) {
+	return m.AcquireN(context, rule, descriptor, 1)
+}
+
+func (m *mockLimiter) AcquireN(context ctx.CTX, rule string, descriptor ratelimiter.Descriptor, n int) (ratelimiter.Decision, error) {
+	args := m.Called(rule, descriptor, n)
+	return args.Get(0).(ratelimiter.Decision), args.Error(1)
+}
+
+var (
+	allowed = ratelimiter.Decision{Decision: strategy.Decision{Allowed: true, Count: 1, Limit: 1}, Rule: "vendor"}
+)
+
+func denied(retryAfter time.Duration) ratelimiter.Decision {
+	return ratelimiter.Decision{Decision: strategy.Decision{Allowed: false, Count: 2, Limit: 1, RetryAfter: retryAfter}, Rule: "vendor", Level: "host"}
+}
+
+// closeRecorder is a request body recording whether it's closed
+type closeRecorder struct {
+	io.Reader
+	closed bool
+}
+
+func (r *closeRecorder) Close() error {
+	r.closed = true
+	return nil
+}
+
+type transportSuite struct {
+	suite.Suite
+	limiter  *mockLimiter
+	server   *httptest.Server
+	requests int
+}
+
+func TestTransportSuite(t *testing.T) {
+	suite.Run(t, new(transportSuite))
+}
+
+func (s *transportSuite) SetupTest() {
+	s.limiter = new(mockLimiter)
+	s.requests = 0
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.requests++
+		w.WriteHeader(http.StatusOK)
+	}))
+}
+
+func (s *transportSuite) TearDownTest() {
+	s.limiter.AssertExpectations(s.T())
+	s.server.Close()
+}
+
+func (s *transportSuite) get(c gocontext.Context, opts ...TransportOption) (*http.Response, error) {
+	client := &http.Client{Transport: NewTransport(s.limiter, "vendor", opts...)}
+	req, err := http.NewRequestWithContext(c, http.MethodGet, s.server.URL+"/v1/items", nil)
+	s.Require().NoError(err)
+	req.Header.Set("X-API-Key", "secret")
+	return client.Do(req)
+}
+
+func (s *transportSuite) TestAllowed() {
+	s.limiter.On("AcquireN", "vendor", mock.Anything, 1).Return(allowed, nil).Once()
+
+	resp, err := s.get(gocontext.Background())
+	s.Require().NoError(err)
+	resp.Body.Close()
+	s.Equal(http.StatusOK, resp.StatusCode)
+	s.Equal(1, s.requests)
+
+	descriptor := s.limiter.Calls[0].Arguments.Get(1).(ratelimiter.Descriptor)
+	host, _ := descriptor.Value("host")
+	s.Equal(s.server.Listener.Addr().String(), host)
+	path, _ := descriptor.Value("path")
+	s.Equal("/v1/items", path)
+	method, _ := descriptor.Value("method")
+	s.Equal(http.MethodGet, method)
+	apiKey, _ := descriptor.Value("header:X-API-Key")
+	s.Equal("secret", apiKey)
+}
+
+func (s *transportSuite) TestFailFast() {
+	s.limiter.On("AcquireN", "vendor", mock.Anything, 1).Return(denied(time.Second), nil).Once()
+
+	_, err := s.get(gocontext.Background())
+	s.Require().Error(err)
+	s.True(errors.Is(err, ErrRateLimited))
+	s.Equal(0, s.requests)
+}
+
+func (s *transportSuite) TestWait() {
+	s.limiter.On("AcquireN", "vendor", mock.Anything, 1).Return(denied(20*time.Millisecond), nil).Twice()
+	s.limiter.On("AcquireN", "vendor", mock.Anything, 1).Return(allowed, nil).Once()
+
+	start := time.Now()
+	resp, err := s.get(gocontext.Background(), WithMaxWait(time.Second))
+	s.Require().NoError(err)
+	resp.Body.Close()
+	s.True(time.Since(start) >= 40*time.Millisecond)
+	s.Equal(1, s.requests)
+}
+
+func (s *transportSuite) TestWaitTooLong() {
+	s.limiter.On("AcquireN", "vendor", mock.Anything, 1).Return(denied(time.Minute), nil).Once()
+
+	_, err := s.get(gocontext.Background(), WithMaxWait(time.Second))
+	s.Require().Error(err)
+	s.True(errors.Is(err, ErrRateLimited))
+}
+
+func (s *transportSuite) TestWaitBeyondDeadline() {
+	s.limiter.On("AcquireN", "vendor", mock.Anything, 1).Return(denied(time.Second), nil).Once()
+
+	// the request fails immediately instead of waiting until its deadline
+	c, cancel := gocontext.WithTimeout(gocontext.Background(), time.Second/2)
+	defer cancel()
+	start := time.Now()
+	_, err := s.get(c, WithMaxWait(time.Minute))
+	s.True(errors.Is(err, ErrRateLimited))
+	s.True(time.Since(start) < time.Second/2)
+	s.Equal(0, s.requests)
+}
+
+func (s *transportSuite) TestWaitCanceled() {
+	s.limiter.On("AcquireN", "vendor", mock.Anything, 1).Return(denied(time.Second), nil).Once()
+
+	c, cancel := gocontext.WithCancel(gocontext.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	_, err := s.get(c, WithMaxWait(time.Minute))
+	s.True(errors.Is(err, gocontext.Canceled))
+	s.Equal(0, s.requests)
+}
+
+func (s *transportSuite) TestLimiterFailed() {
+	s.limiter.On("AcquireN", "vendor", mock.Anything, 1).Return(ratelimiter.Decision{}, fmt.Errorf("redis down")).Once()
+
+	_, err := s.get(gocontext.Background())
+	s.Require().Error(err)
+	s.Equal(0, s.requests)
+}
+
+func (s *transportSuite) TestBodyClosed() {
+	s.limiter.On("AcquireN", "vendor", mock.Anything, 1).Return(denied(time.Second), nil).Once()
+	s.limiter.On("AcquireN", "vendor", mock.Anything, 1).Return(ratelimiter.Decision{}, fmt.Errorf("redis down")).Once()
+
+	// calls RoundTrip directly, since http.Client closes the body itself
+	transport := NewTransport(s.limiter, "vendor")
+	for _, desc := range []string{"denied", "limiter failed"} {
+		body := &closeRecorder{Reader: strings.NewReader("payload")}
+		req, err := http.NewRequest(http.MethodPost, s.server.URL+"/v1/items", body)
+		s.Require().NoError(err)
+
+		_, err = transport.RoundTrip(req)
+		s.Error(err, desc)
+		s.True(body.closed, desc)
+	}
+	s.Equal(0, s.requests)
+}
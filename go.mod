@@ -3,11 +3,19 @@ module github.com/chihkaiyu/ratelimiter
 go 1.13
 
 require (
-	github.com/braintree/manners v0.0.0-20160418043613-82a8879fc5fd
+	github.com/envoyproxy/go-control-plane v0.9.8
 	github.com/gin-contrib/cors v1.3.1
 	github.com/gin-gonic/gin v1.6.3
 	github.com/go-redis/redis/v8 v8.4.11
+	github.com/golang/protobuf v1.4.3
 	github.com/ory/dockertest/v3 v3.6.3
-	github.com/sirupsen/logrus v1.4.2
+	github.com/prometheus/client_golang v1.9.0
+	github.com/sirupsen/logrus v1.6.0
 	github.com/stretchr/testify v1.6.1
+	go.opentelemetry.io/otel v0.16.0
+	go.opentelemetry.io/otel/exporters/otlp v0.16.0
+	go.opentelemetry.io/otel/sdk v0.16.0
+	google.golang.org/genproto v0.0.0-20200526211855-cb27e3aa2013
+	google.golang.org/grpc v1.34.0
+	google.golang.org/protobuf v1.25.0
 )
@@ -0,0 +1,61 @@
+package accesslist
+
+import (
+	"errors"
+
+	"github.com/chihkaiyu/ratelimiter/base/ctx"
+	"github.com/chihkaiyu/ratelimiter/service/ratelimiter/rule"
+)
+
+// List is the name of an access list
+type List string
+
+// Kind is the kind of entries in access list
+type Kind string
+
+// Result is the result of checking a request against the access lists
+type Result int
+
+const (
+	// Allowlist exempts requests from rate limiting
+	Allowlist List = "allowlist"
+	// Denylist rejects requests before rate limiting
+	Denylist List = "denylist"
+
+	// KindIP is an IP or CIDR, e.g. "10.0.0.1" or "10.0.0.0/8"
+	KindIP Kind = "ip"
+	// KindAPIKey is an API key
+	KindAPIKey Kind = "api_key"
+)
+
+const (
+	// NotListed means the request is in neither list
+	NotListed Result = iota
+	// Allowed means the request is in allowlist
+	Allowed
+	// Denied means the request is in denylist
+	Denied
+)
+
+var (
+	// ErrUnknownList is returned when the list is neither allowlist nor denylist
+	ErrUnknownList = errors.New("unknown list")
+	// ErrUnknownKind is returned when the kind is neither IP nor API key
+	ErrUnknownKind = errors.New("unknown kind")
+	// ErrInvalidIP is returned when the entry is not a valid IP or CIDR
+	ErrInvalidIP = errors.New("invalid IP or CIDR")
+)
+
+type Service interface {
+	// Check checks the IP and API key of a request, denylist takes precedence over allowlist
+	Check(ip, apiKey string) Result
+
+	// Add adds an entry into the list, the entry is persisted and applied by every replica
+	Add(context ctx.CTX, list List, kind Kind, entry string) error
+
+	// Remove removes an entry from the list, even if the entry is in rules file
+	Remove(context ctx.CTX, list List, kind Kind, entry string) error
+
+	// Entries returns all entries of the list
+	Entries(list List) (rule.AccessList, error)
+}
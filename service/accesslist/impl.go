@@ -0,0 +1,334 @@
+package accesslist
+
+import (
+	"flag"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/chihkaiyu/ratelimiter/base/ctx"
+	"github.com/chihkaiyu/ratelimiter/service/ratelimiter/rule"
+	"github.com/chihkaiyu/ratelimiter/service/redis"
+)
+
+type list struct {
+	v4      *trie
+	v6      *trie
+	ips     map[string]bool
+	apiKeys map[string]bool
+}
+
+func newList() *list {
+	return &list{
+		v4:      newTrie(),
+		v6:      newTrie(),
+		ips:     map[string]bool{},
+		apiKeys: map[string]bool{},
+	}
+}
+
+var (
+	refreshInterval = flag.Int("accesslist_refresh_interval", 10, "the interval of reloading the access list entries changed at runtime by admin API (in second), 0 to disable")
+)
+
+type impl struct {
+	mutex  sync.RWMutex
+	config rule.Config
+	store  *store
+	lists  map[List]*list
+	// version is the version of the entries changed at runtime
+	version int64
+}
+
+// NewAccessList returns the access lists loaded from rules file. The entries changed at runtime
+// are loaded from redis and reloaded periodically.
+func NewAccessList(redis redis.Service) Service {
+	config, err := rule.LoadFile()
+	if err != nil {
+		logrus.Panicf("rule.LoadFile failed, err: %v", err)
+	}
+
+	im, err := newAccessList(config)
+	if err != nil {
+		logrus.Panicf("newAccessList failed, err: %v", err)
+	}
+	im.store = &store{redis: redis}
+
+	// serve the lists from file if redis is unavailable, they are reloaded after redis is back
+	context := ctx.Background()
+	if err := im.reload(context); err != nil {
+		context.WithField("err", err).Warn("im.reload failed")
+	}
+	if *refreshInterval > 0 {
+		go im.refresh(context, time.Duration(*refreshInterval)*time.Second)
+	}
+
+	return im
+}
+
+func newAccessList(config rule.Config) (*impl, error) {
+	lists, err := newLists(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &impl{
+		config: config,
+		lists:  lists,
+	}, nil
+}
+
+// newLists returns the lists of the entries in rules config
+func newLists(config rule.Config) (map[List]*list, error) {
+	lists := map[List]*list{
+		Allowlist: newList(),
+		Denylist:  newList(),
+	}
+
+	for name, entries := range map[List]rule.AccessList{
+		Allowlist: config.Allowlist,
+		Denylist:  config.Denylist,
+	} {
+		for _, ip := range entries.IPs {
+			if err := apply(lists, edit{list: name, kind: KindIP, entry: ip}); err != nil {
+				return nil, err
+			}
+		}
+		for _, apiKey := range entries.APIKeys {
+			if err := apply(lists, edit{list: name, kind: KindAPIKey, entry: apiKey}); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return lists, nil
+}
+
+// parseIP parses IP or CIDR into the prefix and its canonical form
+func parseIP(entry string) (net.IP, int, string, error) {
+	if !strings.Contains(entry, "/") {
+		ip := net.ParseIP(entry)
+		if ip == nil {
+			return nil, 0, "", ErrInvalidIP
+		}
+		if v4 := ip.To4(); v4 != nil {
+			return v4, 8 * net.IPv4len, v4.String(), nil
+		}
+		return ip, 8 * net.IPv6len, ip.String(), nil
+	}
+
+	_, ipNet, err := net.ParseCIDR(entry)
+	if err != nil {
+		return nil, 0, "", ErrInvalidIP
+	}
+	ones, bits := ipNet.Mask.Size()
+	if v4 := ipNet.IP.To4(); v4 != nil {
+		// IPv4-mapped IPv6 CIDR, e.g. ::ffff:10.0.0.0/104, is the IPv4 CIDR of the last 32 bits
+		if bits == 8*net.IPv6len {
+			ones -= 8 * (net.IPv6len - net.IPv4len)
+		}
+		canonical := (&net.IPNet{IP: v4, Mask: net.CIDRMask(ones, 8*net.IPv4len)}).String()
+		return v4, ones, canonical, nil
+	}
+	return ipNet.IP, ones, ipNet.String(), nil
+}
+
+func (l *list) trieOf(ip net.IP) *trie {
+	if len(ip) == net.IPv4len {
+		return l.v4
+	}
+
+	return l.v6
+}
+
+func (l *list) matchIP(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return l.v4.match(v4)
+	}
+
+	return l.v6.match(parsed)
+}
+
+func (im *impl) Check(ip, apiKey string) Result {
+	im.mutex.RLock()
+	defer im.mutex.RUnlock()
+
+	for _, name := range []List{Denylist, Allowlist} {
+		l := im.lists[name]
+		if l.matchIP(ip) || (apiKey != "" && l.apiKeys[apiKey]) {
+			if name == Denylist {
+				return Denied
+			}
+			return Allowed
+		}
+	}
+
+	return NotListed
+}
+
+// apply adds or removes the entry of the lists
+func apply(lists map[List]*list, e edit) error {
+	l, ok := lists[e.list]
+	if !ok {
+		return ErrUnknownList
+	}
+
+	switch e.kind {
+	case KindIP:
+		ip, ones, canonical, err := parseIP(e.entry)
+		if err != nil {
+			return err
+		}
+		if e.removed {
+			l.trieOf(ip).remove(ip, ones)
+			delete(l.ips, canonical)
+			break
+		}
+		l.trieOf(ip).insert(ip, ones)
+		l.ips[canonical] = true
+	case KindAPIKey:
+		if e.removed {
+			delete(l.apiKeys, e.entry)
+			break
+		}
+		l.apiKeys[e.entry] = true
+	default:
+		return ErrUnknownKind
+	}
+
+	return nil
+}
+
+// normalize validates the entry and returns it in canonical form, so the same IP is stored once
+func normalize(e edit) (edit, error) {
+	if e.list != Allowlist && e.list != Denylist {
+		return e, ErrUnknownList
+	}
+
+	switch e.kind {
+	case KindIP:
+		_, _, canonical, err := parseIP(e.entry)
+		if err != nil {
+			return e, err
+		}
+		e.entry = canonical
+	case KindAPIKey:
+	default:
+		return e, ErrUnknownKind
+	}
+
+	return e, nil
+}
+
+func (im *impl) Add(context ctx.CTX, name List, kind Kind, entry string) error {
+	return im.save(context, edit{list: name, kind: kind, entry: entry})
+}
+
+func (im *impl) Remove(context ctx.CTX, name List, kind Kind, entry string) error {
+	return im.save(context, edit{list: name, kind: kind, entry: entry, removed: true})
+}
+
+// save persists the entry and applies it at once, other replicas apply it on reload
+func (im *impl) save(context ctx.CTX, e edit) error {
+	e, err := normalize(e)
+	if err != nil {
+		return err
+	}
+
+	if err := im.store.save(context, e); err != nil {
+		context.WithField("err", err).Error("store.save failed")
+		return err
+	}
+
+	im.mutex.Lock()
+	defer im.mutex.Unlock()
+	return apply(im.lists, e)
+}
+
+// reload rebuilds the lists from rules config and the entries changed at runtime if they're changed
+func (im *impl) reload(context ctx.CTX) error {
+	version, err := im.store.version(context)
+	if err != nil {
+		context.WithField("err", err).Error("store.version failed")
+		return err
+	}
+
+	im.mutex.RLock()
+	current := im.version
+	im.mutex.RUnlock()
+	if version == current {
+		return nil
+	}
+
+	edits, err := im.store.load(context)
+	if err != nil {
+		context.WithField("err", err).Error("store.load failed")
+		return err
+	}
+
+	// the rules config has been validated in newAccessList
+	lists, err := newLists(im.config)
+	if err != nil {
+		context.WithField("err", err).Error("newLists failed")
+		return err
+	}
+	for _, e := range edits {
+		if err := apply(lists, e); err != nil {
+			context.WithFields(logrus.Fields{
+				"err":   err,
+				"list":  e.list,
+				"entry": e.entry,
+			}).Warn("invalid access list entry, skipped")
+		}
+	}
+
+	im.mutex.Lock()
+	defer im.mutex.Unlock()
+	im.lists = lists
+	im.version = version
+	context.WithField("version", version).Info("access lists reloaded")
+
+	return nil
+}
+
+// refresh reloads periodically
+func (im *impl) refresh(context ctx.CTX, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := im.reload(context); err != nil {
+			context.WithField("err", err).Error("im.reload failed")
+		}
+	}
+}
+
+func (im *impl) Entries(name List) (rule.AccessList, error) {
+	im.mutex.RLock()
+	defer im.mutex.RUnlock()
+
+	l, ok := im.lists[name]
+	if !ok {
+		return rule.AccessList{}, ErrUnknownList
+	}
+
+	entries := rule.AccessList{}
+	for ip := range l.ips {
+		entries.IPs = append(entries.IPs, ip)
+	}
+	for apiKey := range l.apiKeys {
+		entries.APIKeys = append(entries.APIKeys, apiKey)
+	}
+	sort.Strings(entries.IPs)
+	sort.Strings(entries.APIKeys)
+
+	return entries, nil
+}
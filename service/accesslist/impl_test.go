@@ -0,0 +1,253 @@
+package accesslist
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/chihkaiyu/ratelimiter/base/ctx"
+	"github.com/chihkaiyu/ratelimiter/base/docker"
+	"github.com/chihkaiyu/ratelimiter/service/ratelimiter/rule"
+	"github.com/chihkaiyu/ratelimiter/service/redis"
+)
+
+var (
+	mockCTX = ctx.Background()
+)
+
+type accessListSuite struct {
+	suite.Suite
+	redisPort  string
+	redis      redis.Service
+	accessList *impl
+}
+
+func TestAccessListSuite(t *testing.T) {
+	suite.Run(t, new(accessListSuite))
+}
+
+func (s *accessListSuite) SetupSuite() {
+	ports, err := docker.RunExternal([]string{"redis"})
+	s.NoError(err)
+
+	s.redisPort = ports[0]
+}
+
+func (s *accessListSuite) TearDownSuite() {
+	s.NoError(docker.RemoveExternal())
+}
+
+func (s *accessListSuite) SetupTest() {
+	redis, err := redis.NewRedis("localhost:"+s.redisPort, "")
+	s.Require().NoError(err)
+	s.redis = redis
+	s.accessList = s.newAccessList()
+}
+
+func (s *accessListSuite) TearDownTest() {
+	s.NoError(s.redis.Close())
+	s.NoError(docker.ClearRedis(s.redisPort))
+}
+
+// newAccessList returns a replica of the access lists sharing the redis
+func (s *accessListSuite) newAccessList() *impl {
+	im, err := newAccessList(rule.Config{
+		Allowlist: rule.AccessList{
+			IPs:     []string{"10.0.0.0/8", "192.168.1.1", "2001:db8::/32"},
+			APIKeys: []string{"health-checker"},
+		},
+		Denylist: rule.AccessList{
+			IPs:     []string{"10.1.0.0/16", "2001:db8:1::1"},
+			APIKeys: []string{"abuser"},
+		},
+	})
+	s.Require().NoError(err)
+	im.store = &store{redis: s.redis}
+	return im
+}
+
+func (s *accessListSuite) TestCheck() {
+	tests := []struct {
+		Desc   string
+		IP     string
+		APIKey string
+		Exp    Result
+	}{
+		{
+			Desc: "allowed by CIDR",
+			IP:   "10.2.3.4",
+			Exp:  Allowed,
+		},
+		{
+			Desc: "allowed by IP",
+			IP:   "192.168.1.1",
+			Exp:  Allowed,
+		},
+		{
+			Desc: "denylist takes precedence",
+			IP:   "10.1.2.3",
+			Exp:  Denied,
+		},
+		{
+			Desc: "allowed by IPv6 CIDR",
+			IP:   "2001:db8:2::1",
+			Exp:  Allowed,
+		},
+		{
+			Desc: "denied by IPv6",
+			IP:   "2001:db8:1::1",
+			Exp:  Denied,
+		},
+		{
+			Desc:   "allowed by API key",
+			IP:     "8.8.8.8",
+			APIKey: "health-checker",
+			Exp:    Allowed,
+		},
+		{
+			Desc:   "denied by API key",
+			IP:     "10.2.3.4",
+			APIKey: "abuser",
+			Exp:    Denied,
+		},
+		{
+			Desc: "not listed",
+			IP:   "192.168.1.2",
+			Exp:  NotListed,
+		},
+		{
+			Desc: "invalid IP",
+			IP:   "localhost",
+			Exp:  NotListed,
+		},
+	}
+
+	for _, test := range tests {
+		s.Equal(test.Exp, s.accessList.Check(test.IP, test.APIKey), test.Desc)
+	}
+}
+
+func (s *accessListSuite) TestAddAndRemove() {
+	s.Equal(NotListed, s.accessList.Check("172.16.0.1", ""))
+
+	s.NoError(s.accessList.Add(mockCTX, Denylist, KindIP, "172.16.0.0/12"))
+	s.Equal(Denied, s.accessList.Check("172.16.0.1", ""))
+
+	s.NoError(s.accessList.Remove(mockCTX, Denylist, KindIP, "172.16.0.0/12"))
+	s.Equal(NotListed, s.accessList.Check("172.16.0.1", ""))
+
+	s.NoError(s.accessList.Remove(mockCTX, Denylist, KindIP, "10.1.0.0/16"))
+	s.Equal(Allowed, s.accessList.Check("10.1.2.3", ""))
+
+	s.NoError(s.accessList.Remove(mockCTX, Allowlist, KindAPIKey, "health-checker"))
+	s.Equal(NotListed, s.accessList.Check("8.8.8.8", "health-checker"))
+
+	s.Equal(ErrInvalidIP, s.accessList.Add(mockCTX, Denylist, KindIP, "10.0.0.0/33"))
+	s.Equal(ErrUnknownList, s.accessList.Add(mockCTX, "greylist", KindIP, "10.0.0.1"))
+	s.Equal(ErrUnknownKind, s.accessList.Add(mockCTX, Denylist, "user", "bob"))
+}
+
+func (s *accessListSuite) TestReload() {
+	replica := s.newAccessList()
+	s.NoError(replica.reload(mockCTX))
+
+	s.NoError(s.accessList.Add(mockCTX, Denylist, KindIP, "::ffff:172.16.0.0/108"))
+	s.NoError(s.accessList.Add(mockCTX, Allowlist, KindAPIKey, "partner"))
+	s.NoError(s.accessList.Remove(mockCTX, Denylist, KindIP, "10.1.0.0/16"))
+	s.Equal(NotListed, replica.Check("172.16.0.1", ""))
+
+	// the entries are persisted in canonical form and applied on top of the rules file
+	s.NoError(replica.reload(mockCTX))
+	s.Equal(Denied, replica.Check("172.16.0.1", ""))
+	s.Equal(Allowed, replica.Check("8.8.8.8", "partner"))
+	s.Equal(Allowed, replica.Check("10.1.2.3", ""))
+	act, err := replica.Entries(Denylist)
+	s.NoError(err)
+	s.Equal(rule.AccessList{
+		IPs:     []string{"172.16.0.0/12", "2001:db8:1::1"},
+		APIKeys: []string{"abuser"},
+	}, act)
+
+	// an entry added back after removal
+	s.NoError(s.accessList.Add(mockCTX, Denylist, KindIP, "10.1.0.0/16"))
+	s.NoError(replica.reload(mockCTX))
+	s.Equal(Denied, replica.Check("10.1.2.3", ""))
+
+	// a malformed entry is skipped
+	s.NoError(s.redis.HSet(mockCTX, entriesKey, entryField(Denylist, KindIP, "10.0.0.0/33"), []byte(added)))
+	s.NoError(s.redis.HSet(mockCTX, entriesKey, "malformed", []byte(added)))
+	_, err = s.redis.Incr(mockCTX, versionKey)
+	s.NoError(err)
+	s.NoError(replica.reload(mockCTX))
+	s.Equal(Denied, replica.Check("172.16.0.1", ""))
+}
+
+func (s *accessListSuite) TestParseIP() {
+	tests := []struct {
+		Desc         string
+		Entry        string
+		ExpOnes      int
+		ExpCanonical string
+		ExpErr       error
+	}{
+		{
+			Desc:         "IPv4",
+			Entry:        "10.0.0.1",
+			ExpOnes:      32,
+			ExpCanonical: "10.0.0.1",
+		},
+		{
+			Desc:         "IPv4 CIDR",
+			Entry:        "10.0.0.0/8",
+			ExpOnes:      8,
+			ExpCanonical: "10.0.0.0/8",
+		},
+		{
+			Desc:         "IPv4-mapped IPv6",
+			Entry:        "::ffff:10.0.0.1",
+			ExpOnes:      32,
+			ExpCanonical: "10.0.0.1",
+		},
+		{
+			Desc:         "IPv4-mapped IPv6 CIDR",
+			Entry:        "::ffff:10.0.0.0/104",
+			ExpOnes:      8,
+			ExpCanonical: "10.0.0.0/8",
+		},
+		{
+			Desc:         "IPv6 CIDR",
+			Entry:        "2001:db8::/32",
+			ExpOnes:      32,
+			ExpCanonical: "2001:db8::/32",
+		},
+		{
+			Desc:   "invalid CIDR",
+			Entry:  "10.0.0.0/33",
+			ExpErr: ErrInvalidIP,
+		},
+	}
+
+	for _, t := range tests {
+		_, ones, canonical, err := parseIP(t.Entry)
+		s.Equal(t.ExpErr, err, t.Desc)
+		s.Equal(t.ExpOnes, ones, t.Desc)
+		s.Equal(t.ExpCanonical, canonical, t.Desc)
+	}
+
+	// doesn't panic and matches the IPv4 addresses
+	s.NoError(s.accessList.Add(mockCTX, Denylist, KindIP, "::ffff:172.16.0.0/108"))
+	s.Equal(Denied, s.accessList.Check("172.16.0.1", ""))
+	s.Equal(Denied, s.accessList.Check("::ffff:172.16.0.1", ""))
+}
+
+func (s *accessListSuite) TestEntries() {
+	act, err := s.accessList.Entries(Allowlist)
+	s.NoError(err)
+	s.Equal(rule.AccessList{
+		IPs:     []string{"10.0.0.0/8", "192.168.1.1", "2001:db8::/32"},
+		APIKeys: []string{"health-checker"},
+	}, act)
+
+	_, err = s.accessList.Entries("greylist")
+	s.Equal(ErrUnknownList, err)
+}
@@ -0,0 +1,104 @@
+package accesslist
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/chihkaiyu/ratelimiter/base/ctx"
+	"github.com/chihkaiyu/ratelimiter/service/redis"
+)
+
+const (
+	// entriesKey is the hash of entries changed at runtime, a removed entry is stored as an empty value
+	// so the entry is also removed if it's in the rules file
+	entriesKey = "ratelimiter:accesslist"
+	// versionKey is increased whenever an entry is changed, so replicas know when to reload
+	versionKey = "ratelimiter:accesslist:version"
+
+	added = "1"
+)
+
+// edit is an entry added or removed at runtime
+type edit struct {
+	list    List
+	kind    Kind
+	entry   string
+	removed bool
+}
+
+// store persists the entries changed at runtime
+type store struct {
+	redis redis.Service
+}
+
+// entryField returns the field of the entry in the hash
+func entryField(list List, kind Kind, entry string) string {
+	return fmt.Sprintf("%s:%s:%s", list, kind, entry)
+}
+
+// version returns the version of the entries, zero if nothing has been changed
+func (s *store) version(context ctx.CTX) (int64, error) {
+	value, err := s.redis.Get(context, versionKey)
+	if err == redis.Nil {
+		return 0, nil
+	}
+	if err != nil {
+		context.WithField("err", err).Error("redis.Get failed")
+		return 0, err
+	}
+
+	return strconv.ParseInt(string(value), 10, 64)
+}
+
+// load returns the entries changed at runtime, a malformed field is skipped
+func (s *store) load(context ctx.CTX) ([]edit, error) {
+	values, err := s.redis.HGetAll(context, entriesKey)
+	if err != nil {
+		context.WithField("err", err).Error("redis.HGetAll failed")
+		return nil, err
+	}
+
+	edits := []edit{}
+	for field, value := range values {
+		parts := strings.SplitN(field, ":", 3)
+		if len(parts) != 3 {
+			context.WithField("field", field).Warn("malformed access list entry, skipped")
+			continue
+		}
+		edits = append(edits, edit{
+			list:    List(parts[0]),
+			kind:    Kind(parts[1]),
+			entry:   parts[2],
+			removed: value != added,
+		})
+	}
+
+	return edits, nil
+}
+
+// save saves the entry as added or removed
+func (s *store) save(context ctx.CTX, e edit) error {
+	value := []byte(added)
+	if e.removed {
+		value = []byte{}
+	}
+
+	if err := s.redis.HSet(context, entriesKey, entryField(e.list, e.kind, e.entry), value); err != nil {
+		context.WithFields(logrus.Fields{
+			"err":   err,
+			"list":  e.list,
+			"entry": e.entry,
+		}).Error("redis.HSet failed")
+		return err
+	}
+
+	if _, err := s.redis.Incr(context, versionKey); err != nil {
+		context.WithField("err", err).Error("redis.Incr failed")
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,74 @@
+package accesslist
+
+import "net"
+
+type node struct {
+	children [2]*node
+	// terminal is true when a prefix ends at the node
+	terminal bool
+}
+
+// trie is a binary prefix trie of IP bits, looking up an IP takes at most
+// the number of bits of the IP steps no matter how many prefixes are inserted
+type trie struct {
+	root *node
+}
+
+func newTrie() *trie {
+	return &trie{root: &node{}}
+}
+
+func bitAt(ip net.IP, i int) int {
+	return int(ip[i/8]>>(7-uint(i%8))) & 1
+}
+
+// insert inserts the prefix of given length
+func (t *trie) insert(ip net.IP, ones int) {
+	n := t.root
+	for i := 0; i < ones; i++ {
+		b := bitAt(ip, i)
+		if n.children[b] == nil {
+			n.children[b] = &node{}
+		}
+		n = n.children[b]
+	}
+	n.terminal = true
+}
+
+// remove removes the prefix of given length and prunes the empty nodes
+func (t *trie) remove(ip net.IP, ones int) {
+	path := make([]*node, 0, ones+1)
+	n := t.root
+	for i := 0; i < ones; i++ {
+		path = append(path, n)
+		n = n.children[bitAt(ip, i)]
+		if n == nil {
+			return
+		}
+	}
+	n.terminal = false
+
+	for i := ones - 1; i >= 0; i-- {
+		if n.terminal || n.children[0] != nil || n.children[1] != nil {
+			return
+		}
+		path[i].children[bitAt(ip, i)] = nil
+		n = path[i]
+	}
+}
+
+// match returns true if any inserted prefix contains the IP
+func (t *trie) match(ip net.IP) bool {
+	n := t.root
+	for i := 0; i < len(ip)*8; i++ {
+		if n.terminal {
+			return true
+		}
+		n = n.children[bitAt(ip, i)]
+		if n == nil {
+			return false
+		}
+	}
+
+	return n.terminal
+}
@@ -0,0 +1,54 @@
+package accesslist
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type trieSuite struct {
+	suite.Suite
+	trie *trie
+}
+
+func TestTrieSuite(t *testing.T) {
+	suite.Run(t, new(trieSuite))
+}
+
+func (s *trieSuite) SetupTest() {
+	s.trie = newTrie()
+}
+
+func (s *trieSuite) TestMatch() {
+	s.trie.insert(net.ParseIP("10.0.0.0").To4(), 8)
+	s.trie.insert(net.ParseIP("192.168.1.1").To4(), 32)
+
+	s.True(s.trie.match(net.ParseIP("10.255.0.1").To4()))
+	s.True(s.trie.match(net.ParseIP("192.168.1.1").To4()))
+	s.False(s.trie.match(net.ParseIP("192.168.1.2").To4()))
+	s.False(s.trie.match(net.ParseIP("11.0.0.1").To4()))
+}
+
+func (s *trieSuite) TestRemove() {
+	s.trie.insert(net.ParseIP("10.0.0.0").To4(), 8)
+	s.trie.insert(net.ParseIP("10.1.0.0").To4(), 16)
+
+	s.trie.remove(net.ParseIP("10.0.0.0").To4(), 8)
+	s.False(s.trie.match(net.ParseIP("10.2.0.1").To4()))
+	s.True(s.trie.match(net.ParseIP("10.1.0.1").To4()))
+
+	s.trie.remove(net.ParseIP("10.1.0.0").To4(), 16)
+	s.False(s.trie.match(net.ParseIP("10.1.0.1").To4()))
+	// empty nodes are pruned
+	s.Nil(s.trie.root.children[0])
+	s.Nil(s.trie.root.children[1])
+
+	// removing a prefix which doesn't exist is no-op
+	s.trie.remove(net.ParseIP("172.16.0.0").To4(), 12)
+}
+
+func (s *trieSuite) TestMatchAll() {
+	s.trie.insert(net.IPv4zero.To4(), 0)
+	s.True(s.trie.match(net.ParseIP("8.8.8.8").To4()))
+}
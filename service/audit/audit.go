@@ -0,0 +1,28 @@
+package audit
+
+import (
+	"time"
+
+	"github.com/chihkaiyu/ratelimiter/base/ctx"
+	"github.com/chihkaiyu/ratelimiter/service/ratelimiter"
+)
+
+// Entry is a decision of a request to be audited
+type Entry struct {
+	RequestID string
+	// Client is the client of the request, e.g. IP
+	Client string
+	ratelimiter.Decision
+	// Banned is the remaining duration of the ban if the client is banned,
+	// the decision is empty in this case
+	Banned time.Duration
+}
+
+type Service interface {
+	// Log writes the entry to the audit log, rejections are always logged and
+	// allowed requests are sampled
+	Log(context ctx.CTX, entry Entry)
+
+	// Close flushes and closes the audit log file, entries logged after it are lost
+	Close() error
+}
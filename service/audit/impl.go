@@ -0,0 +1,146 @@
+package audit
+
+import (
+	"flag"
+	"io"
+	"math/rand"
+	"os"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/chihkaiyu/ratelimiter/base/ctx"
+	"github.com/chihkaiyu/ratelimiter/base/tracing"
+)
+
+const (
+	sinkStdout = "stdout"
+
+	resultAllowed    = "allowed"
+	resultDenied     = "denied"
+	resultWouldBlock = "would_block"
+	resultBanned     = "banned"
+)
+
+var (
+	randFloat = rand.Float64
+
+	auditLog        = flag.String("audit_log", "", "the file of decision audit log in JSON lines, \"stdout\" for standard output, audit log is disabled if it's empty")
+	auditSampleRate = flag.Float64("audit_sample_rate", 0, "the rate of allowed requests written to audit log, from 0 to 1, rejections are always written")
+	auditHashKeys   = flag.Bool("audit_hash_keys", true, "write hashed keys and clients instead of raw ones to audit log")
+)
+
+type impl struct {
+	// logger is nil when audit log is disabled
+	logger *logrus.Logger
+	// file is nil unless the audit log is written to a file
+	file       *os.File
+	sampleRate float64
+	hashKeys   bool
+}
+
+// NewAudit returns the audit log writing to the sink set by flag
+func NewAudit() Service {
+	switch *auditLog {
+	case "":
+		return &impl{}
+	case sinkStdout:
+		return newAudit(os.Stdout, *auditSampleRate, *auditHashKeys)
+	}
+
+	file, err := os.OpenFile(*auditLog, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		logrus.Panicf("os.OpenFile failed, err: %v", err)
+	}
+
+	im := newAudit(file, *auditSampleRate, *auditHashKeys)
+	im.file = file
+	return im
+}
+
+func newAudit(w io.Writer, sampleRate float64, hashKeys bool) *impl {
+	logger := logrus.New()
+	logger.SetOutput(w)
+	// the level of the rule is logged as "level", so the log level is renamed
+	logger.SetFormatter(&logrus.JSONFormatter{
+		FieldMap: logrus.FieldMap{logrus.FieldKeyLevel: "severity"},
+	})
+
+	return &impl{
+		logger:     logger,
+		sampleRate: sampleRate,
+		hashKeys:   hashKeys,
+	}
+}
+
+func (im *impl) Log(context ctx.CTX, entry Entry) {
+	if im.logger == nil {
+		return
+	}
+
+	result := resultOf(entry)
+	if result == resultAllowed && randFloat() >= im.sampleRate {
+		return
+	}
+
+	fields := logrus.Fields{
+		"request_id": entry.RequestID,
+		"client":     im.hash(entry.Client),
+		"result":     result,
+	}
+	if result == resultBanned {
+		fields["retry_after_ms"] = entry.Banned.Milliseconds()
+		im.logger.WithFields(fields).Info("decision")
+		return
+	}
+
+	fields["key"] = im.hash(entry.Key)
+	fields["rule"] = entry.Rule
+	fields["level"] = entry.Level
+	fields["strategy"] = entry.Strategy
+	fields["count"] = entry.Count
+	fields["limit"] = entry.Limit
+	fields["remaining"] = entry.Remaining
+	if result != resultAllowed {
+		fields["retry_after_ms"] = entry.RetryAfter.Milliseconds()
+		fields["reason"] = entry.Reason()
+	}
+	im.logger.WithFields(fields).Info("decision")
+}
+
+func (im *impl) Close() error {
+	if im.file == nil {
+		return nil
+	}
+
+	if err := im.file.Sync(); err != nil {
+		logrus.WithField("err", err).Error("file.Sync failed")
+		return err
+	}
+	if err := im.file.Close(); err != nil {
+		logrus.WithField("err", err).Error("file.Close failed")
+		return err
+	}
+
+	return nil
+}
+
+func (im *impl) hash(key string) string {
+	if !im.hashKeys || key == "" {
+		return key
+	}
+
+	return tracing.HashKey(key)
+}
+
+func resultOf(entry Entry) string {
+	switch {
+	case entry.Banned > 0:
+		return resultBanned
+	case entry.WouldBlock:
+		return resultWouldBlock
+	case entry.Allowed:
+		return resultAllowed
+	default:
+		return resultDenied
+	}
+}
@@ -0,0 +1,166 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/chihkaiyu/ratelimiter/base/ctx"
+	"github.com/chihkaiyu/ratelimiter/base/tracing"
+	"github.com/chihkaiyu/ratelimiter/service/ratelimiter"
+	"github.com/chihkaiyu/ratelimiter/service/ratelimiter/strategy"
+)
+
+type auditSuite struct {
+	suite.Suite
+	buf *bytes.Buffer
+}
+
+func TestAuditSuite(t *testing.T) {
+	suite.Run(t, new(auditSuite))
+}
+
+func (s *auditSuite) SetupTest() {
+	s.buf = &bytes.Buffer{}
+}
+
+func (s *auditSuite) TearDownTest() {
+	randFloat = rand.Float64
+}
+
+func (s *auditSuite) lines() []map[string]interface{} {
+	result := []map[string]interface{}{}
+	for _, line := range strings.Split(strings.TrimSpace(s.buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		m := map[string]interface{}{}
+		s.NoError(json.Unmarshal([]byte(line), &m))
+		result = append(result, m)
+	}
+
+	return result
+}
+
+func (s *auditSuite) TestLog() {
+	denied := ratelimiter.Decision{
+		Decision: strategy.Decision{Count: 11, Limit: 10, RetryAfter: 1500 * time.Millisecond},
+		Rule:     "default",
+		Level:    "ip",
+		Strategy: "fixedwindow",
+		Key:      "1.2.3.4",
+	}
+	allowed := denied
+	allowed.Allowed = true
+	wouldBlock := denied
+	wouldBlock.Allowed = true
+	wouldBlock.WouldBlock = true
+
+	tests := []struct {
+		Desc       string
+		Entry      Entry
+		SampleRate float64
+		HashKeys   bool
+		ExpFields  map[string]interface{}
+	}{
+		{
+			Desc:  "denied",
+			Entry: Entry{RequestID: "req", Client: "1.2.3.4", Decision: denied},
+			ExpFields: map[string]interface{}{
+				"request_id":     "req",
+				"client":         "1.2.3.4",
+				"key":            "1.2.3.4",
+				"result":         "denied",
+				"rule":           "default",
+				"level":          "ip",
+				"count":          float64(11),
+				"retry_after_ms": float64(1500),
+				"reason":         "ip limit of rule default exceeded",
+			},
+		},
+		{
+			Desc:     "hashed keys",
+			Entry:    Entry{Client: "1.2.3.4", Decision: denied},
+			HashKeys: true,
+			ExpFields: map[string]interface{}{
+				"client": tracing.HashKey("1.2.3.4"),
+				"key":    tracing.HashKey("1.2.3.4"),
+			},
+		},
+		{
+			Desc:      "would block",
+			Entry:     Entry{Client: "1.2.3.4", Decision: wouldBlock},
+			ExpFields: map[string]interface{}{"result": "would_block"},
+		},
+		{
+			Desc:      "banned",
+			Entry:     Entry{Client: "1.2.3.4", Banned: time.Minute},
+			ExpFields: map[string]interface{}{"result": "banned", "retry_after_ms": float64(60000)},
+		},
+		{
+			Desc:  "allowed not sampled",
+			Entry: Entry{Client: "1.2.3.4", Decision: allowed},
+		},
+		{
+			Desc:       "allowed sampled",
+			Entry:      Entry{Client: "1.2.3.4", Decision: allowed},
+			SampleRate: 0.6,
+			ExpFields:  map[string]interface{}{"result": "allowed", "remaining": float64(0)},
+		},
+	}
+
+	randFloat = func() float64 { return 0.5 }
+	for _, t := range tests {
+		s.SetupTest()
+		newAudit(s.buf, t.SampleRate, t.HashKeys).Log(ctx.Background(), t.Entry)
+
+		lines := s.lines()
+		if t.ExpFields == nil {
+			s.Empty(lines, t.Desc)
+			continue
+		}
+		s.Len(lines, 1, t.Desc)
+		for k, v := range t.ExpFields {
+			s.Equal(v, lines[0][k], t.Desc+": "+k)
+		}
+		s.Contains(lines[0], "time", t.Desc)
+	}
+}
+
+func (s *auditSuite) TestLogDisabled() {
+	im := &impl{}
+	im.Log(ctx.Background(), Entry{Client: "1.2.3.4"})
+}
+
+func (s *auditSuite) TestClose() {
+	dir, err := ioutil.TempDir("", "audit")
+	s.Require().NoError(err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "audit.log")
+	*auditLog = path
+	defer func() { *auditLog = "" }()
+
+	im := NewAudit()
+	im.Log(ctx.Background(), Entry{RequestID: "abc", Client: "1.2.3.4", Banned: time.Minute})
+	s.NoError(im.Close())
+
+	// the entries are written to the file before it's closed
+	data, err := ioutil.ReadFile(path)
+	s.Require().NoError(err)
+	s.buf.Write(data)
+	s.Len(s.lines(), 1)
+	s.Equal("abc", s.lines()[0]["request_id"])
+
+	// nothing to close without the file
+	s.NoError((&impl{}).Close())
+	s.NoError(newAudit(s.buf, 0, true).Close())
+}
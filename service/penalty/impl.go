@@ -0,0 +1,189 @@
+package penalty
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"time"
+
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
+
+	"github.com/chihkaiyu/ratelimiter/base/ctx"
+	"github.com/chihkaiyu/ratelimiter/service/redis"
+)
+
+const (
+	bansKey = "penalty:bans"
+
+	// KEYS: rejections, ban, level, bans
+	// ARGV: threshold, window, baseMillisecond, maxMillisecond, decay, key, nowMillisecond
+	// the ban duration is doubled every time the key is banned again before the level decays
+	penalizeScript = `
+local count = redis.call('INCR', KEYS[1])
+if count == 1 then
+	redis.call('EXPIRE', KEYS[1], ARGV[2])
+end
+if count < tonumber(ARGV[1]) then
+	return 0
+end
+
+redis.call('DEL', KEYS[1])
+local level = redis.call('INCR', KEYS[3])
+local duration = math.floor(math.min(tonumber(ARGV[3]) * 2 ^ (level - 1), tonumber(ARGV[4])))
+redis.call('SET', KEYS[2], level, 'PX', duration)
+redis.call('EXPIRE', KEYS[3], math.ceil(duration / 1000) + tonumber(ARGV[5]))
+redis.call('ZREMRANGEBYSCORE', KEYS[4], '-inf', ARGV[7])
+redis.call('ZADD', KEYS[4], tonumber(ARGV[7]) + duration, ARGV[6])
+
+return duration
+`
+
+	// KEYS: rejections, ban, level, bans
+	// ARGV: key
+	liftScript = `
+redis.call('DEL', KEYS[1], KEYS[2], KEYS[3])
+redis.call('ZREM', KEYS[4], ARGV[1])
+return 0
+`
+)
+
+var (
+	timeNow = time.Now
+
+	penaltyThreshold    = flag.Int("penalty_threshold", 0, "ban the key after the number of rejections within penalty_window, 0 to disable penalty")
+	penaltyWindow       = flag.Int("penalty_window", 60, "the window counting rejections (in second)")
+	penaltyBaseDuration = flag.Int("penalty_base_duration", 60, "the duration of the first ban (in second), doubled for every further ban")
+	penaltyMaxDuration  = flag.Int("penalty_max_duration", 86400, "the maximum duration of a ban (in second)")
+	penaltyDecay        = flag.Int("penalty_decay", 86400, "the escalation resets if the key is not banned again within the time after a ban (in second)")
+)
+
+type impl struct {
+	redis          redis.Service
+	penalizeScript *goredis.Script
+	liftScript     *goredis.Script
+	threshold      int
+	window         int
+	baseDuration   time.Duration
+	maxDuration    time.Duration
+	decay          int
+}
+
+func NewPenaltyBox(
+	redis redis.Service,
+) Service {
+	return &impl{
+		redis:          redis,
+		penalizeScript: goredis.NewScript(penalizeScript),
+		liftScript:     goredis.NewScript(liftScript),
+		threshold:      *penaltyThreshold,
+		window:         *penaltyWindow,
+		baseDuration:   time.Duration(*penaltyBaseDuration) * time.Second,
+		maxDuration:    time.Duration(*penaltyMaxDuration) * time.Second,
+		decay:          *penaltyDecay,
+	}
+}
+
+func (im *impl) enabled() bool {
+	return im.threshold > 0
+}
+
+func redisKeys(key string) []string {
+	return []string{
+		fmt.Sprintf("penalty:rejections:%s", key),
+		fmt.Sprintf("penalty:ban:%s", key),
+		fmt.Sprintf("penalty:level:%s", key),
+		bansKey,
+	}
+}
+
+func (im *impl) Banned(context ctx.CTX, key string) (time.Duration, error) {
+	if !im.enabled() {
+		return 0, nil
+	}
+
+	banKey := redisKeys(key)[1]
+	ttl, err := im.redis.TTL(context, banKey)
+	if err != nil {
+		context.WithFields(logrus.Fields{
+			"err": err,
+			"key": banKey,
+		}).Error("redis.TTL failed")
+		return 0, err
+	}
+
+	return ttl, nil
+}
+
+func (im *impl) Penalize(context ctx.CTX, key string) (time.Duration, error) {
+	if !im.enabled() {
+		return 0, nil
+	}
+
+	value, err := im.redis.RunScript(
+		context,
+		im.penalizeScript,
+		redisKeys(key),
+		im.threshold,
+		im.window,
+		im.baseDuration.Milliseconds(),
+		im.maxDuration.Milliseconds(),
+		im.decay,
+		key,
+		timeNow().UnixNano()/int64(time.Millisecond),
+	)
+	if err != nil {
+		context.WithFields(logrus.Fields{
+			"err": err,
+			"key": key,
+		}).Error("redis.RunScript failed")
+		return 0, err
+	}
+
+	duration := time.Duration(value.(int64)) * time.Millisecond
+	if duration > 0 {
+		context.WithFields(logrus.Fields{
+			"key":      key,
+			"duration": duration,
+		}).Warn("key banned")
+	}
+
+	return duration, nil
+}
+
+func (im *impl) List(context ctx.CTX) ([]Ban, error) {
+	now := strconv.FormatInt(timeNow().UnixNano()/int64(time.Millisecond), 10)
+	keys, err := im.redis.ZRangeByScore(context, bansKey, "("+now, "+inf", 0, 0)
+	if err != nil {
+		context.WithField("err", err).Error("redis.ZRangeByScore failed")
+		return nil, err
+	}
+
+	bans := []Ban{}
+	for _, key := range keys {
+		remaining, err := im.Banned(context, key)
+		if err != nil {
+			return nil, err
+		}
+
+		// the ban may be lifted or expired meanwhile
+		if remaining <= 0 {
+			continue
+		}
+		bans = append(bans, Ban{Key: key, Remaining: remaining})
+	}
+
+	return bans, nil
+}
+
+func (im *impl) Lift(context ctx.CTX, key string) error {
+	if _, err := im.redis.RunScript(context, im.liftScript, redisKeys(key), key); err != nil {
+		context.WithFields(logrus.Fields{
+			"err": err,
+			"key": key,
+		}).Error("redis.RunScript failed")
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,127 @@
+package penalty
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/chihkaiyu/ratelimiter/base/ctx"
+	"github.com/chihkaiyu/ratelimiter/base/docker"
+	"github.com/chihkaiyu/ratelimiter/service/redis"
+)
+
+var (
+	mockCTX = ctx.Background()
+	mockNow = time.Date(2021, time.February, 1, 0, 0, 0, 0, time.UTC)
+)
+
+type mockFuncs struct {
+	mock.Mock
+}
+
+func (m *mockFuncs) timeNow() time.Time {
+	args := m.Called()
+	return args.Get(0).(time.Time)
+}
+
+type penaltySuite struct {
+	suite.Suite
+	redisPort string
+	penalty   *impl
+	mockFuncs *mockFuncs
+}
+
+func TestPenaltySuite(t *testing.T) {
+	suite.Run(t, new(penaltySuite))
+}
+
+func (s *penaltySuite) SetupSuite() {
+	ports, err := docker.RunExternal([]string{"redis"})
+	s.NoError(err)
+
+	s.redisPort = ports[0]
+}
+
+func (s *penaltySuite) TearDownSuite() {
+	s.NoError(docker.RemoveExternal())
+}
+
+func (s *penaltySuite) SetupTest() {
+	*penaltyThreshold = 3
+	*penaltyWindow = 60
+	*penaltyBaseDuration = 10
+	*penaltyMaxDuration = 30
+	redis, err := redis.NewRedis("localhost:"+s.redisPort, "")
+	s.Require().NoError(err)
+	s.penalty = NewPenaltyBox(redis).(*impl)
+
+	// mock functions
+	s.mockFuncs = new(mockFuncs)
+	timeNow = s.mockFuncs.timeNow
+}
+
+func (s *penaltySuite) TearDownTest() {
+	s.mockFuncs.AssertExpectations(s.T())
+
+	s.NoError(docker.ClearRedis(s.redisPort))
+}
+
+func (s *penaltySuite) penalize(key string, times int) time.Duration {
+	var duration time.Duration
+	var err error
+	for i := 0; i < times; i++ {
+		s.mockFuncs.On("timeNow").Return(mockNow).Once()
+		duration, err = s.penalty.Penalize(mockCTX, key)
+		s.NoError(err)
+	}
+
+	return duration
+}
+
+func (s *penaltySuite) TestPenalize() {
+	key := "localhost"
+
+	s.Equal(time.Duration(0), s.penalize(key, 2))
+	banned, err := s.penalty.Banned(mockCTX, key)
+	s.NoError(err)
+	s.Equal(time.Duration(0), banned)
+
+	// the duration is doubled until the maximum
+	s.Equal(10*time.Second, s.penalize(key, 1))
+	s.Equal(20*time.Second, s.penalize(key, 3))
+	s.Equal(30*time.Second, s.penalize(key, 3))
+
+	banned, err = s.penalty.Banned(mockCTX, key)
+	s.NoError(err)
+	s.True(banned > 29*time.Second && banned <= 30*time.Second)
+}
+
+func (s *penaltySuite) TestListAndLift() {
+	s.penalize("banned", 3)
+	s.penalize("not-banned", 2)
+
+	s.mockFuncs.On("timeNow").Return(mockNow).Once()
+	bans, err := s.penalty.List(mockCTX)
+	s.NoError(err)
+	s.Len(bans, 1)
+	s.Equal("banned", bans[0].Key)
+
+	s.NoError(s.penalty.Lift(mockCTX, "banned"))
+	banned, err := s.penalty.Banned(mockCTX, "banned")
+	s.NoError(err)
+	s.Equal(time.Duration(0), banned)
+
+	// the escalation is reset after lifted
+	s.Equal(10*time.Second, s.penalize("banned", 3))
+}
+
+func (s *penaltySuite) TestDisabled() {
+	*penaltyThreshold = 0
+	s.penalty = NewPenaltyBox(s.penalty.redis).(*impl)
+
+	duration, err := s.penalty.Penalize(mockCTX, "localhost")
+	s.NoError(err)
+	s.Equal(time.Duration(0), duration)
+}
@@ -0,0 +1,28 @@
+package penalty
+
+import (
+	"time"
+
+	"github.com/chihkaiyu/ratelimiter/base/ctx"
+)
+
+// Ban is a banned key and the remaining duration of the ban
+type Ban struct {
+	Key       string
+	Remaining time.Duration
+}
+
+type Service interface {
+	// Banned returns the remaining duration of the ban of given key, zero if the key is not banned
+	Banned(context ctx.CTX, key string) (time.Duration, error)
+
+	// Penalize records a rejection of given key and bans the key after too many rejections,
+	// returns the duration of the ban, zero if the key is not banned
+	Penalize(context ctx.CTX, key string) (time.Duration, error)
+
+	// List lists all banned keys
+	List(context ctx.CTX) ([]Ban, error)
+
+	// Lift lifts the ban of given key and resets its escalation
+	Lift(context ctx.CTX, key string) error
+}
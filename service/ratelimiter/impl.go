@@ -2,27 +2,165 @@ package ratelimiter
 
 import (
 	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/codes"
 
 	"github.com/chihkaiyu/ratelimiter/base/ctx"
+	"github.com/chihkaiyu/ratelimiter/base/tracing"
+	"github.com/chihkaiyu/ratelimiter/service/ratelimiter/rule"
 	"github.com/chihkaiyu/ratelimiter/service/ratelimiter/strategy"
+	"github.com/chihkaiyu/ratelimiter/service/ratelimiter/strategy/composite"
 	"github.com/chihkaiyu/ratelimiter/service/ratelimiter/strategy/fixedwindow"
 	"github.com/chihkaiyu/ratelimiter/service/ratelimiter/strategy/slidingwindow"
 	"github.com/chihkaiyu/ratelimiter/service/ratelimiter/strategy/tokenbucket"
 	"github.com/chihkaiyu/ratelimiter/service/redis"
 )
 
+const (
+	// DefaultRule is the name of the rule built from flags when there is no rules file
+	DefaultRule = "default"
+
+	// globalKey is the key of levels shared by all requests
+	globalKey = "global"
+
+	strategyComposite = "composite"
+
+	// KEYS: counters of levels
+	// ARGV: ttl, keys of levels
+	trackScript = `
+for i, key in ipairs(KEYS) do
+	redis.call('ZINCRBY', key, 1, ARGV[i + 1])
+	redis.call('EXPIRE', key, ARGV[1])
+end
+return 0
+`
+)
+
 var (
+	timeNow = time.Now
+
+	rulesRefreshInterval     = flag.Int("rules_refresh_interval", 10, "the interval of reloading the rules changed at runtime by admin API (in second), 0 to disable")
+	overridesRefreshInterval = flag.Int("overrides_refresh_interval", 5, "the interval of reloading the overrides (in second), 0 to disable")
+	topConsumersWindow       = flag.Int("top_consumers_window", 0, "the window of counting top consumers of each level (in second), 0 to disable")
+
 	rateLimiterStrategy = flag.String("ratelimiter_strategy", "fixedwindow", "strategy for rate limiting")
+	compositeLimits     = flag.String("composite_limits", "fixedwindow:1:10,fixedwindow:3600:1000", "limits of composite strategy, comma separated strategy:size:limit (refill per second for tokenbucket)")
 )
 
+type level struct {
+	name string
+	key  string
+	// strategyName is the name of the strategy, e.g. fixedwindow or composite
+	strategyName string
+	strategy     strategy.Strategy
+	// maxCost is the smallest limit of the strategies, a request costing more is never allowed.
+	// It's zero if the limits are unknown, e.g. the default rule of a single strategy.
+	maxCost int
+}
+
+// keyOf returns the limited key of the request, false when the level doesn't apply to it
+func (l level) keyOf(descriptor Descriptor) (string, bool) {
+	if l.shared() {
+		return globalKey, true
+	}
+
+	return descriptor.Value(l.key)
+}
+
+// shared returns true if the level is shared by all requests
+func (l level) shared() bool {
+	return l.key == ""
+}
+
+func (l level) decision(ruleName, key string, decision strategy.Decision) Decision {
+	result := Decision{Decision: decision, Rule: ruleName, Level: l.name, Strategy: l.strategyName}
+	if !l.shared() {
+		result.Key = key
+	}
+
+	return result
+}
+
+type limiterRule struct {
+	name   string
+	shadow bool
+	levels []level
+	// config is nil if the rule is built from flags
+	config *rule.Rule
+}
+
 type impl struct {
-	strategy strategy.Strategy
+	redis       redis.Service
+	store       *store
+	trackScript *goredis.Script
+
+	mutex sync.RWMutex
+	// base are the rules from rules file or flags, overridden by the rules changed at runtime
+	base  map[string]*limiterRule
+	rules map[string]*limiterRule
+	// version is the version of the rules changed at runtime
+	version int64
+
+	overrideConfigs  []rule.Override
+	overrides        overrides
+	overridesVersion int64
 }
 
+// NewRateLimiter returns the rate limiter of the rules from rules file, or the default rule
+// built from flags if there is no rule. The rules changed at runtime are loaded from redis
+// and reloaded periodically.
 func NewRateLimiter(
 	redis redis.Service,
 ) Service {
+	config, err := rule.LoadFile()
+	if err != nil {
+		logrus.Panicf("rule.LoadFile failed, err: %v", err)
+	}
+
+	im := &impl{
+		redis:       redis,
+		store:       &store{redis: redis},
+		trackScript: goredis.NewScript(trackScript),
+		base:        newRules(redis, config),
+	}
+	if len(config.Rules) == 0 {
+		im.base = map[string]*limiterRule{
+			DefaultRule: newDefaultRule(redis),
+		}
+	}
+	im.rules = im.base
+
+	// serve the rules from file if redis is unavailable, they are reloaded after redis is back
+	context := ctx.Background()
+	if err := im.reload(context); err != nil {
+		context.WithField("err", err).Warn("im.reload failed")
+	}
+	if err := im.reloadOverrides(context); err != nil {
+		context.WithField("err", err).Warn("im.reloadOverrides failed")
+	}
+	if *rulesRefreshInterval > 0 {
+		go refresh(context, time.Duration(*rulesRefreshInterval)*time.Second, im.reload)
+	}
+	if *overridesRefreshInterval > 0 {
+		go refresh(context, time.Duration(*overridesRefreshInterval)*time.Second, im.reloadOverrides)
+	}
+
+	return im
+}
+
+// newDefaultRule builds the rule limited by IP from flags
+func newDefaultRule(redis redis.Service) *limiterRule {
 	var stra strategy.Strategy
+	var maxCost int
+	strategyName := *rateLimiterStrategy
 	switch *rateLimiterStrategy {
 	case "tokenbucket":
 		stra = tokenbucket.NewTokenBucket(redis)
@@ -30,20 +168,704 @@ func NewRateLimiter(
 		stra = slidingwindow.NewSlidingWindow(redis)
 	case "fixedwindow":
 		stra = fixedwindow.NewFixedWindow(redis)
+	case strategyComposite:
+		limits, err := parseLimits(*compositeLimits)
+		if err != nil {
+			logrus.Panicf("parseLimits failed, err: %v", err)
+		}
+		strategies := []strategy.Strategy{}
+		for _, limit := range limits {
+			// strategies of the same kind must not share redis keys
+			prefix := fmt.Sprintf("%s:%d:%s", limit.Strategy, limit.Size, limitValue(limit))
+			strategies = append(strategies, newStrategy(redis, limit, prefix))
+		}
+		stra = composite.NewComposite(strategies...)
+		maxCost = maxCostOf(limits)
 	default:
 		stra = fixedwindow.NewFixedWindow(redis)
+		strategyName = rule.StrategyFixedWindow
+	}
+
+	return &limiterRule{
+		name: DefaultRule,
+		levels: []level{
+			{name: "ip", key: "ip", strategyName: strategyName, strategy: stra, maxCost: maxCost},
+		},
+	}
+}
+
+func newRules(redis redis.Service, config rule.Config) map[string]*limiterRule {
+	rules := map[string]*limiterRule{}
+	for _, r := range config.Rules {
+		rules[r.Name] = newRule(redis, r)
+	}
+
+	return rules
+}
+
+func newRule(redis redis.Service, r rule.Rule) *limiterRule {
+	lr := &limiterRule{name: r.Name, shadow: r.Shadow, config: &r}
+	for _, l := range r.Levels {
+		lr.levels = append(lr.levels, newLevel(redis, r.Name, l))
+	}
+
+	return lr
+}
+
+func newLevel(redis redis.Service, ruleName string, l rule.Level) level {
+	strategies := []strategy.Strategy{}
+	for i, limit := range l.Limits {
+		prefix := fmt.Sprintf("%s:%s:%s:%d", limit.Strategy, ruleName, l.Name, i)
+		strategies = append(strategies, newStrategy(redis, limit, prefix))
+	}
+
+	stra, strategyName := strategies[0], l.Limits[0].Strategy
+	if len(strategies) > 1 {
+		stra, strategyName = composite.NewComposite(strategies...), strategyComposite
+	}
+
+	return level{name: l.Name, key: l.Key, strategyName: strategyName, strategy: stra, maxCost: maxCostOf(l.Limits)}
+}
+
+// maxCostOf returns the smallest limit, which is the bucket size for token bucket
+func maxCostOf(limits []rule.Limit) int {
+	maxCost := 0
+	for _, limit := range limits {
+		value := limit.Limit
+		if limit.Strategy == rule.StrategyTokenBucket {
+			value = limit.Size
+		}
+		if maxCost == 0 || value < maxCost {
+			maxCost = value
+		}
+	}
+
+	return maxCost
+}
+
+func newStrategy(redis redis.Service, limit rule.Limit, prefix string) strategy.Strategy {
+	switch limit.Strategy {
+	case rule.StrategyTokenBucket:
+		return tokenbucket.NewTokenBucket(
+			redis, tokenbucket.WithSize(limit.Size), tokenbucket.WithRefill(limit.Refill), tokenbucket.WithMaxDebt(limit.MaxDebt),
+			tokenbucket.WithPrefix(prefix),
+		)
+	case rule.StrategySlidingWindow:
+		return slidingwindow.NewSlidingWindow(
+			redis, slidingwindow.WithSize(limit.Size), slidingwindow.WithLimit(limit.Limit), slidingwindow.WithPrefix(prefix),
+		)
+	default:
+		return fixedwindow.NewFixedWindow(
+			redis, fixedwindow.WithSize(limit.Size), fixedwindow.WithLimit(limit.Limit), fixedwindow.WithPrefix(prefix),
+		)
 	}
-	return &impl{
-		strategy: stra,
+}
+
+func limitValue(limit rule.Limit) string {
+	if limit.Strategy == rule.StrategyTokenBucket {
+		return strconv.FormatFloat(limit.Refill, 'f', -1, 64)
+	}
+
+	return strconv.Itoa(limit.Limit)
+}
+
+// parseLimits parses limits like "fixedwindow:1:10,tokenbucket:60:0.5"
+func parseLimits(limits string) ([]rule.Limit, error) {
+	result := []rule.Limit{}
+	for _, limit := range strings.Split(limits, ",") {
+		fields := strings.Split(strings.TrimSpace(limit), ":")
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("invalid limit: %s", limit)
+		}
+
+		size, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid size of limit %s: %v", limit, err)
+		}
+
+		l := rule.Limit{Strategy: fields[0], Size: size}
+		if l.Strategy == rule.StrategyTokenBucket {
+			l.Refill, err = strconv.ParseFloat(fields[2], 64)
+		} else {
+			l.Limit, err = strconv.Atoi(fields[2])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid limit %s: %v", limit, err)
+		}
+
+		if err := l.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid limit %s: %v", limit, err)
+		}
+		result = append(result, l)
+	}
+
+	return result, nil
+}
+
+// rule returns the rule of given name
+func (im *impl) rule(context ctx.CTX, name string) (*limiterRule, error) {
+	im.mutex.RLock()
+	defer im.mutex.RUnlock()
+
+	r, ok := im.rules[name]
+	if !ok {
+		context.WithField("rule", name).Error("rule not found")
+		return nil, ErrRuleNotFound
 	}
+
+	return r, nil
 }
 
-func (im *impl) AcquireByIP(context ctx.CTX, ip string) (bool, int, error) {
-	permit, count, err := im.strategy.Acquire(context, ip)
+// ruleFor returns the rule of given name with the overrides of the request applied
+func (im *impl) ruleFor(context ctx.CTX, name string, descriptor Descriptor) (*limiterRule, error) {
+	r, err := im.rule(context, name)
 	if err != nil {
-		context.WithField("err", err).Error("strategy.acquire failed")
-		return false, 0, err
+		return nil, err
+	}
+
+	im.mutex.RLock()
+	defer im.mutex.RUnlock()
+	return im.overrides.apply(r, descriptor, timeNow()), nil
+}
+
+func (im *impl) Acquire(context ctx.CTX, ruleName string, descriptor Descriptor) (Decision, error) {
+	return im.AcquireN(context, ruleName, descriptor, 1)
+}
+
+func (im *impl) Wait(context ctx.CTX, ruleName string, descriptor Descriptor, n int) (Decision, error) {
+	return Wait(context, im, ruleName, descriptor, n)
+}
+
+func (im *impl) AcquireN(context ctx.CTX, ruleName string, descriptor Descriptor, n int) (Decision, error) {
+	r, err := im.ruleFor(context, ruleName, descriptor)
+	if err != nil {
+		return Decision{}, err
+	}
+
+	decision, err := r.acquire(context, descriptor, n)
+	if err != nil {
+		decisionCounter.WithLabelValues(r.name, decision.Strategy, resultError).Inc()
+		return Decision{}, err
+	}
+	im.track(context, r, descriptor)
+
+	decision.Shadow = r.shadow
+	if r.shadow && !decision.Allowed {
+		context.WithFields(logrus.Fields{
+			"rule":   r.name,
+			"level":  decision.Level,
+			"key":    decision.Key,
+			"count":  decision.Count,
+			"limit":  decision.Limit,
+			"reason": decision.Reason(),
+		}).Info("request would have been rejected by shadow rule")
+		decision.Allowed = true
+		decision.WouldBlock = true
+	}
+	decisionCounter.WithLabelValues(r.name, decision.Strategy, resultOf(decision)).Inc()
+
+	return decision, nil
+}
+
+func (im *impl) Release(context ctx.CTX, ruleName string, descriptor Descriptor, n int) error {
+	r, err := im.ruleFor(context, ruleName, descriptor)
+	if err != nil {
+		return err
+	}
+
+	levels := []level{}
+	for _, l := range r.levels {
+		if _, ok := l.keyOf(descriptor); ok {
+			levels = append(levels, l)
+		}
+	}
+	release(context, levels, descriptor, n)
+
+	return nil
+}
+
+func (r *limiterRule) acquire(context ctx.CTX, descriptor Descriptor, n int) (Decision, error) {
+	// evaluate from the narrowest level, so a request denied by its own limit
+	// doesn't touch the wider levels shared by others
+	result := Decision{Decision: strategy.Decision{Allowed: true}, Rule: r.name}
+	// a request costing more than a limit is never allowed, it's denied without being counted,
+	// so it can't use up the limit of the key for others
+	for _, l := range r.levels {
+		if key, ok := l.keyOf(descriptor); ok && l.maxCost > 0 && n > l.maxCost {
+			return l.decision(r.name, key, strategy.Decision{Allowed: false, Count: n, Limit: l.maxCost}), nil
+		}
+	}
+
+	levels, keys := []level{}, []string{}
+	for i := len(r.levels) - 1; i >= 0; i-- {
+		// the level doesn't apply to the request without the key
+		if key, ok := r.levels[i].keyOf(descriptor); ok {
+			levels, keys = append(levels, r.levels[i]), append(keys, key)
+		}
+	}
+	if chainer, ok := chainerOf(levels); ok {
+		return r.acquireChain(context, chainer, levels, keys, n)
+	}
+
+	acquired := []level{}
+	for i, l := range levels {
+		key := keys[i]
+		decision, err := l.acquire(context, r.name, key, n)
+		if err != nil {
+			context.WithFields(logrus.Fields{
+				"err":   err,
+				"rule":  r.name,
+				"level": l.name,
+			}).Error("strategy.Acquire failed")
+			release(context, acquired, descriptor, n)
+			return l.decision(r.name, key, decision), err
+		}
+
+		if !decision.Allowed {
+			release(context, acquired, descriptor, n)
+			return l.decision(r.name, key, decision), nil
+		}
+
+		if len(acquired) == 0 || decision.MoreRestrictive(result.Decision) {
+			result = l.decision(r.name, key, decision)
+		}
+		acquired = append(acquired, l)
+	}
+
+	return result, nil
+}
+
+// chainerOf returns the chainer of the levels if they're all of the same strategy which could be chained,
+// so they're acquired in a single script. Mixed strategies, e.g. composite, are acquired one by one.
+func chainerOf(levels []level) (strategy.Chainer, bool) {
+	if len(levels) < 2 {
+		return nil, false
+	}
+	for _, l := range levels {
+		if l.strategyName != levels[0].strategyName {
+			return nil, false
+		}
+	}
+
+	chainer, ok := levels[0].strategy.(strategy.Chainer)
+	return chainer, ok
+}
+
+// acquireChain acquires n permits from the levels in a single script, either all levels count
+// the request or none of them
+func (r *limiterRule) acquireChain(context ctx.CTX, chainer strategy.Chainer, levels []level, keys []string, n int) (Decision, error) {
+	strategyName := levels[0].strategyName
+	context, span := tracing.Start(context, "strategy.AcquireChain",
+		tracing.Rule.String(r.name),
+		tracing.Strategy.String(strategyName),
+	)
+	defer span.End()
+
+	links := []strategy.Link{}
+	for i, l := range levels {
+		links = append(links, strategy.Link{Strategy: l.strategy, Key: keys[i]})
+	}
+	decisions, err := chainer.AcquireChain(context, links, n)
+	if err != nil {
+		context.WithFields(logrus.Fields{
+			"err":  err,
+			"rule": r.name,
+		}).Error("strategy.AcquireChain failed")
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		span.SetAttributes(tracing.Result.String(resultError))
+		return Decision{Rule: r.name, Strategy: strategyName}, err
+	}
+
+	var result Decision
+	for i, decision := range decisions {
+		if i == 0 || decision.MoreRestrictive(result.Decision) {
+			result = levels[i].decision(r.name, keys[i], decision)
+		}
+	}
+
+	span.SetAttributes(
+		tracing.Level.String(result.Level),
+		tracing.Result.String(resultOf(result)),
+		tracing.Remaining.Int(result.Remaining),
+	)
+	return result, nil
+}
+
+// acquire acquires n permits from the strategy of the level in a span
+func (l level) acquire(context ctx.CTX, ruleName, key string, n int) (strategy.Decision, error) {
+	context, span := tracing.Start(context, "strategy.Acquire",
+		tracing.Rule.String(ruleName),
+		tracing.Level.String(l.name),
+		tracing.Strategy.String(l.strategyName),
+		tracing.KeyHash.String(tracing.HashKey(key)),
+	)
+	defer span.End()
+
+	decision, err := l.strategy.Acquire(context, key, n)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		span.SetAttributes(tracing.Result.String(resultError))
+		return decision, err
+	}
+
+	result := resultAllowed
+	if !decision.Allowed {
+		result = resultDenied
+	}
+	span.SetAttributes(tracing.Result.String(result), tracing.Remaining.Int(decision.Remaining))
+
+	return decision, nil
+}
+
+// release gives back n permits granted by given levels
+func release(context ctx.CTX, levels []level, descriptor Descriptor, n int) {
+	for _, l := range levels {
+		key, _ := l.keyOf(descriptor)
+		if err := l.strategy.Release(context, key, n); err != nil {
+			context.WithFields(logrus.Fields{
+				"err":   err,
+				"level": l.name,
+			}).Error("strategy.Release failed")
+		}
+	}
+}
+
+func (im *impl) Rules() []rule.Rule {
+	im.mutex.RLock()
+	defer im.mutex.RUnlock()
+
+	rules := []rule.Rule{}
+	for _, r := range im.rules {
+		if r.config != nil {
+			rules = append(rules, *r.config)
+		}
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].Name < rules[j].Name })
+
+	return rules
+}
+
+func (im *impl) SetRule(context ctx.CTX, r rule.Rule) error {
+	if err := r.Validate(); err != nil {
+		return err
+	}
+
+	if err := im.store.save(context, r.Name, &r); err != nil {
+		context.WithField("err", err).Error("store.save failed")
+		return err
+	}
+
+	return im.reload(context)
+}
+
+func (im *impl) DeleteRule(context ctx.CTX, name string) error {
+	if _, err := im.rule(context, name); err != nil {
+		return err
+	}
+
+	if err := im.store.save(context, name, nil); err != nil {
+		context.WithField("err", err).Error("store.save failed")
+		return err
+	}
+
+	return im.reload(context)
+}
+
+// reload applies the rules changed at runtime if they have been changed since last reload
+func (im *impl) reload(context ctx.CTX) error {
+	version, err := im.store.version(context, rulesVersionKey)
+	if err != nil {
+		context.WithField("err", err).Error("store.version failed")
+		return err
+	}
+
+	im.mutex.RLock()
+	current := im.version
+	im.mutex.RUnlock()
+	if version == current {
+		return nil
+	}
+
+	stored, err := im.store.load(context)
+	if err != nil {
+		context.WithField("err", err).Error("store.load failed")
+		return err
+	}
+
+	im.mutex.Lock()
+	defer im.mutex.Unlock()
+	im.rules = im.apply(stored)
+	im.version = version
+	// overrides are bound to the levels of rules
+	im.overrides = newOverrides(im.redis, im.rules, im.overrideConfigs)
+	context.WithField("version", version).Info("rules reloaded")
+
+	return nil
+}
+
+// apply returns the base rules overridden by the rules changed at runtime
+func (im *impl) apply(stored map[string]*rule.Rule) map[string]*limiterRule {
+	rules := map[string]*limiterRule{}
+	for name, r := range im.base {
+		rules[name] = r
+	}
+	for name, r := range stored {
+		if r == nil {
+			delete(rules, name)
+			continue
+		}
+		rules[name] = newRule(im.redis, *r)
+	}
+
+	return rules
+}
+
+// refresh reloads periodically
+func refresh(context ctx.CTX, interval time.Duration, reload func(ctx.CTX) error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := reload(context); err != nil {
+			context.WithField("err", err).Error("reload failed")
+		}
+	}
+}
+
+func (im *impl) Overrides() []rule.Override {
+	im.mutex.RLock()
+	defer im.mutex.RUnlock()
+
+	result := append([]rule.Override{}, im.overrideConfigs...)
+	sort.Slice(result, func(i, j int) bool {
+		return overrideField(result[i].Rule, result[i].Level, result[i].Pattern) <
+			overrideField(result[j].Rule, result[j].Level, result[j].Pattern)
+	})
+
+	return result
+}
+
+func (im *impl) SetOverride(context ctx.CTX, o rule.Override) error {
+	if err := o.Validate(); err != nil {
+		return err
+	}
+
+	r, err := im.rule(context, o.Rule)
+	if err != nil {
+		return err
+	}
+	if _, ok := keyedLevel(r, o.Level); !ok {
+		return ErrLevelNotFound
+	}
+
+	if err := im.store.saveOverride(context, o); err != nil {
+		context.WithField("err", err).Error("store.saveOverride failed")
+		return err
+	}
+
+	return im.reloadOverrides(context)
+}
+
+func (im *impl) DeleteOverride(context ctx.CTX, ruleName, levelName, pattern string) error {
+	found := false
+	im.mutex.RLock()
+	for _, o := range im.overrideConfigs {
+		if o.Rule == ruleName && o.Level == levelName && o.Pattern == pattern {
+			found = true
+		}
+	}
+	im.mutex.RUnlock()
+	if !found {
+		return ErrOverrideNotFound
+	}
+
+	if err := im.store.deleteOverride(context, ruleName, levelName, pattern); err != nil {
+		context.WithField("err", err).Error("store.deleteOverride failed")
+		return err
+	}
+
+	return im.reloadOverrides(context)
+}
+
+// reloadOverrides applies the overrides if they have been changed since last reload
+func (im *impl) reloadOverrides(context ctx.CTX) error {
+	version, err := im.store.version(context, overridesVersionKey)
+	if err != nil {
+		context.WithField("err", err).Error("store.version failed")
+		return err
+	}
+
+	im.mutex.RLock()
+	current := im.overridesVersion
+	im.mutex.RUnlock()
+	if version == current {
+		return nil
+	}
+
+	configs, err := im.store.loadOverrides(context, timeNow())
+	if err != nil {
+		context.WithField("err", err).Error("store.loadOverrides failed")
+		return err
+	}
+
+	im.mutex.Lock()
+	defer im.mutex.Unlock()
+	im.overrideConfigs = configs
+	im.overrides = newOverrides(im.redis, im.rules, configs)
+	im.overridesVersion = version
+	context.WithField("version", version).Info("overrides reloaded")
+
+	return nil
+}
+
+func (im *impl) Inspect(context ctx.CTX, ruleName string, descriptor Descriptor) ([]Decision, error) {
+	r, err := im.ruleFor(context, ruleName, descriptor)
+	if err != nil {
+		return nil, err
+	}
+
+	decisions := []Decision{}
+	for _, l := range r.levels {
+		key, ok := l.keyOf(descriptor)
+		if !ok {
+			continue
+		}
+
+		decision, err := l.strategy.Peek(context, key)
+		if err != nil {
+			context.WithFields(logrus.Fields{
+				"err":   err,
+				"rule":  r.name,
+				"level": l.name,
+			}).Error("strategy.Peek failed")
+			return nil, err
+		}
+		decisions = append(decisions, l.decision(r.name, key, decision))
+	}
+
+	return decisions, nil
+}
+
+func (im *impl) Reset(context ctx.CTX, ruleName string, descriptor Descriptor) error {
+	r, err := im.ruleFor(context, ruleName, descriptor)
+	if err != nil {
+		return err
+	}
+
+	for _, l := range r.levels {
+		// shared levels are not reset by the key of a client
+		if l.shared() {
+			continue
+		}
+		key, ok := l.keyOf(descriptor)
+		if !ok {
+			continue
+		}
+
+		if err := l.strategy.Reset(context, key); err != nil {
+			context.WithFields(logrus.Fields{
+				"err":   err,
+				"rule":  r.name,
+				"level": l.name,
+			}).Error("strategy.Reset failed")
+			return err
+		}
+	}
+
+	return nil
+}
+
+// topKey returns the key of the counters of the level in the window of given time
+func topKey(ruleName, levelName string, now time.Time) string {
+	window := now.Unix() / int64(*topConsumersWindow)
+	return fmt.Sprintf("top:%s:%s:%d", ruleName, levelName, window)
+}
+
+// track counts the request for the keys of the levels
+func (im *impl) track(context ctx.CTX, r *limiterRule, descriptor Descriptor) {
+	if *topConsumersWindow <= 0 {
+		return
+	}
+
+	now := timeNow()
+	keys := []string{}
+	args := []interface{}{*topConsumersWindow * 2}
+	for _, l := range r.levels {
+		if l.shared() {
+			continue
+		}
+		key, ok := l.keyOf(descriptor)
+		if !ok {
+			continue
+		}
+		keys = append(keys, topKey(r.name, l.name, now))
+		args = append(args, key)
+	}
+	if len(keys) == 0 {
+		return
+	}
+
+	if _, err := im.redis.RunScript(context, im.trackScript, keys, args...); err != nil {
+		context.WithFields(logrus.Fields{
+			"err":  err,
+			"rule": r.name,
+		}).Error("redis.RunScript failed")
+	}
+}
+
+func (im *impl) TopConsumers(context ctx.CTX, ruleName, levelName string, n int) ([]Consumer, error) {
+	r, err := im.rule(context, ruleName)
+	if err != nil {
+		return nil, err
+	}
+
+	found := false
+	for _, l := range r.levels {
+		if l.name == levelName && !l.shared() {
+			found = true
+		}
+	}
+	if !found {
+		context.WithFields(logrus.Fields{
+			"rule":  ruleName,
+			"level": levelName,
+		}).Error("level not found")
+		return nil, ErrLevelNotFound
+	}
+
+	consumers := []Consumer{}
+	if *topConsumersWindow <= 0 {
+		return consumers, nil
+	}
+
+	// sum up the current and the previous windows, so the result isn't empty at the beginning of a window
+	now := timeNow()
+	requests := map[string]int{}
+	for _, t := range []time.Time{now, now.Add(-time.Duration(*topConsumersWindow) * time.Second)} {
+		members, err := im.redis.ZRevRangeWithScores(context, topKey(ruleName, levelName, t), 0, n-1)
+		if err != nil {
+			context.WithField("err", err).Error("redis.ZRevRangeWithScores failed")
+			return nil, err
+		}
+		for _, m := range members {
+			requests[m.Member.(string)] += int(m.Score)
+		}
+	}
+
+	for key, count := range requests {
+		consumers = append(consumers, Consumer{Key: key, Requests: count})
+	}
+	sort.Slice(consumers, func(i, j int) bool {
+		if consumers[i].Requests != consumers[j].Requests {
+			return consumers[i].Requests > consumers[j].Requests
+		}
+		return consumers[i].Key < consumers[j].Key
+	})
+	if len(consumers) > n {
+		consumers = consumers[:n]
 	}
 
-	return permit, count, nil
+	return consumers, nil
 }
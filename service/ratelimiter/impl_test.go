@@ -0,0 +1,435 @@
+package ratelimiter
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/sdk/export/trace/tracetest"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+
+	"github.com/chihkaiyu/ratelimiter/base/ctx"
+	"github.com/chihkaiyu/ratelimiter/base/tracing"
+	"github.com/chihkaiyu/ratelimiter/service/ratelimiter/rule"
+	"github.com/chihkaiyu/ratelimiter/service/ratelimiter/strategy"
+)
+
+var (
+	mockCTX = ctx.Background()
+)
+
+type mockStrategy struct {
+	mock.Mock
+}
+
+func (m *mockStrategy) Acquire(context ctx.CTX, key string, n int) (strategy.Decision, error) {
+	args := m.Called(context, key, n)
+	return args.Get(0).(strategy.Decision), args.Error(1)
+}
+
+func (m *mockStrategy) Release(context ctx.CTX, key string, n int) error {
+	args := m.Called(context, key, n)
+	return args.Error(0)
+}
+
+func (m *mockStrategy) Peek(context ctx.CTX, key string) (strategy.Decision, error) {
+	args := m.Called(context, key)
+	return args.Get(0).(strategy.Decision), args.Error(1)
+}
+
+func (m *mockStrategy) Reset(context ctx.CTX, key string) error {
+	args := m.Called(context, key)
+	return args.Error(0)
+}
+
+// mockChainer is a strategy acquiring the levels in a single script
+type mockChainer struct {
+	mockStrategy
+}
+
+func (m *mockChainer) AcquireChain(context ctx.CTX, links []strategy.Link, n int) ([]strategy.Decision, error) {
+	keys := []string{}
+	for _, link := range links {
+		keys = append(keys, link.Key)
+	}
+	args := m.Called(keys, n)
+	decisions, _ := args.Get(0).([]strategy.Decision)
+	return decisions, args.Error(1)
+}
+
+type rateLimiterSuite struct {
+	suite.Suite
+	limiter *impl
+	global  *mockStrategy
+	tenant  *mockStrategy
+	user    *mockStrategy
+}
+
+func TestRateLimiterSuite(t *testing.T) {
+	suite.Run(t, new(rateLimiterSuite))
+}
+
+func (s *rateLimiterSuite) SetupTest() {
+	s.global = new(mockStrategy)
+	s.tenant = new(mockStrategy)
+	s.user = new(mockStrategy)
+	s.limiter = &impl{
+		rules: map[string]*limiterRule{
+			"api": {
+				name: "api",
+				levels: []level{
+					{name: "global", strategy: s.global},
+					{name: "tenant", key: "tenant", strategy: s.tenant},
+					{name: "user", key: "user", strategy: s.user},
+				},
+			},
+		},
+	}
+}
+
+func (s *rateLimiterSuite) TearDownTest() {
+	s.global.AssertExpectations(s.T())
+	s.tenant.AssertExpectations(s.T())
+	s.user.AssertExpectations(s.T())
+}
+
+func (s *rateLimiterSuite) TestAcquire() {
+	allowed := func(remaining int) strategy.Decision {
+		return strategy.Decision{Allowed: true, Limit: 100, Count: 100 - remaining, Remaining: remaining}
+	}
+	denied := strategy.Decision{Allowed: false, Limit: 100, Count: 101, RetryAfter: time.Second}
+	descriptor := Entries{"tenant": "acme", "user": "bob"}
+
+	tests := []struct {
+		Desc       string
+		Descriptor Descriptor
+		SetupTest  func()
+		Exp        Decision
+		ExpReason  string
+	}{
+		{
+			Desc:       "all levels allowed",
+			Descriptor: descriptor,
+			SetupTest: func() {
+				s.user.On("Acquire", mock.Anything, "bob", 1).Return(allowed(50), nil).Once()
+				s.tenant.On("Acquire", mock.Anything, "acme", 1).Return(allowed(10), nil).Once()
+				s.global.On("Acquire", mock.Anything, globalKey, 1).Return(allowed(90), nil).Once()
+			},
+			Exp: Decision{Decision: allowed(10), Rule: "api", Level: "tenant", Key: "acme"},
+		},
+		{
+			Desc:       "denied by user doesn't touch the others",
+			Descriptor: descriptor,
+			SetupTest: func() {
+				s.user.On("Acquire", mock.Anything, "bob", 1).Return(denied, nil).Once()
+			},
+			Exp:       Decision{Decision: denied, Rule: "api", Level: "user", Key: "bob"},
+			ExpReason: "user limit of rule api exceeded",
+		},
+		{
+			Desc:       "denied by global releases the others",
+			Descriptor: descriptor,
+			SetupTest: func() {
+				s.user.On("Acquire", mock.Anything, "bob", 1).Return(allowed(50), nil).Once()
+				s.tenant.On("Acquire", mock.Anything, "acme", 1).Return(allowed(10), nil).Once()
+				s.global.On("Acquire", mock.Anything, globalKey, 1).Return(denied, nil).Once()
+				s.user.On("Release", mockCTX, "bob", 1).Return(nil).Once()
+				s.tenant.On("Release", mockCTX, "acme", 1).Return(nil).Once()
+			},
+			Exp:       Decision{Decision: denied, Rule: "api", Level: "global"},
+			ExpReason: "global limit of rule api exceeded",
+		},
+		{
+			Desc:       "level without key is skipped",
+			Descriptor: Entries{"user": "bob"},
+			SetupTest: func() {
+				s.user.On("Acquire", mock.Anything, "bob", 1).Return(allowed(50), nil).Once()
+				s.global.On("Acquire", mock.Anything, globalKey, 1).Return(allowed(90), nil).Once()
+			},
+			Exp: Decision{Decision: allowed(50), Rule: "api", Level: "user", Key: "bob"},
+		},
+	}
+
+	for _, test := range tests {
+		s.SetupTest()
+		test.SetupTest()
+
+		act, err := s.limiter.Acquire(mockCTX, "api", test.Descriptor)
+		s.NoError(err, test.Desc)
+		s.Equal(test.Exp, act, test.Desc)
+		s.Equal(test.ExpReason, act.Reason(), test.Desc)
+
+		s.TearDownTest()
+	}
+}
+
+func (s *rateLimiterSuite) TestAcquireN() {
+	allowed := strategy.Decision{Allowed: true, Limit: 100, Count: 5, Remaining: 95}
+	denied := strategy.Decision{Allowed: false, Limit: 100, Count: 101, RetryAfter: time.Second}
+	s.user.On("Acquire", mock.Anything, "bob", 5).Return(allowed, nil).Once()
+	s.global.On("Acquire", mock.Anything, globalKey, 5).Return(denied, nil).Once()
+	s.user.On("Release", mockCTX, "bob", 5).Return(nil).Once()
+
+	act, err := s.limiter.AcquireN(mockCTX, "api", Entries{"user": "bob"}, 5)
+	s.NoError(err)
+	s.False(act.Allowed)
+	s.Equal("global", act.Level)
+}
+
+func (s *rateLimiterSuite) TestAcquireChain() {
+	global, user := new(mockChainer), new(mockChainer)
+	s.limiter.rules["chained"] = &limiterRule{
+		name: "chained",
+		levels: []level{
+			{name: "global", strategyName: "fixedwindow", strategy: global},
+			{name: "user", key: "user", strategyName: "fixedwindow", strategy: user},
+		},
+	}
+	allowed := func(remaining int) strategy.Decision {
+		return strategy.Decision{Allowed: true, Limit: 100, Count: 100 - remaining, Remaining: remaining}
+	}
+	denied := strategy.Decision{Allowed: false, Limit: 100, Count: 101, RetryAfter: time.Second}
+
+	// the levels are acquired from the narrowest by the strategy of it
+	user.On("AcquireChain", []string{"bob", globalKey}, 2).Return([]strategy.Decision{allowed(50), allowed(10)}, nil).Once()
+	act, err := s.limiter.AcquireN(mockCTX, "chained", Entries{"user": "bob"}, 2)
+	s.NoError(err)
+	s.Equal(Decision{Decision: allowed(10), Rule: "chained", Level: "global", Strategy: "fixedwindow"}, act)
+
+	user.On("AcquireChain", []string{"bob", globalKey}, 1).Return([]strategy.Decision{allowed(50), denied}, nil).Once()
+	act, err = s.limiter.Acquire(mockCTX, "chained", Entries{"user": "bob"})
+	s.NoError(err)
+	s.Equal(Decision{Decision: denied, Rule: "chained", Level: "global", Strategy: "fixedwindow"}, act)
+
+	user.On("AcquireChain", []string{"bob", globalKey}, 1).Return(nil, fmt.Errorf("redis down")).Once()
+	_, err = s.limiter.Acquire(mockCTX, "chained", Entries{"user": "bob"})
+	s.Error(err)
+
+	// a single level isn't chained
+	global.On("Acquire", mock.Anything, globalKey, 1).Return(allowed(10), nil).Once()
+	act, err = s.limiter.Acquire(mockCTX, "chained", Entries{})
+	s.NoError(err)
+	s.True(act.Allowed)
+
+	// mixed strategies are acquired one by one
+	s.limiter.rules["chained"].levels[0].strategyName = "tokenbucket"
+	user.On("Acquire", mock.Anything, "bob", 1).Return(allowed(50), nil).Once()
+	global.On("Acquire", mock.Anything, globalKey, 1).Return(allowed(10), nil).Once()
+	act, err = s.limiter.Acquire(mockCTX, "chained", Entries{"user": "bob"})
+	s.NoError(err)
+	s.True(act.Allowed)
+
+	global.AssertExpectations(s.T())
+	user.AssertExpectations(s.T())
+}
+
+func (s *rateLimiterSuite) TestRelease() {
+	s.user.On("Release", mockCTX, "bob", 2).Return(nil).Once()
+	s.global.On("Release", mockCTX, globalKey, 2).Return(nil).Once()
+
+	// the level without key doesn't apply
+	s.NoError(s.limiter.Release(mockCTX, "api", Entries{"user": "bob"}, 2))
+	s.Equal(ErrRuleNotFound, s.limiter.Release(mockCTX, "not-exist", Entries{"user": "bob"}, 2))
+}
+
+func (s *rateLimiterSuite) TestAcquireNExceedsLimit() {
+	s.limiter.rules["api"].levels[1].maxCost = 10
+
+	// denied without touching any level
+	act, err := s.limiter.AcquireN(mockCTX, "api", Entries{"tenant": "acme", "user": "bob"}, 11)
+	s.NoError(err)
+	s.False(act.Allowed)
+	s.Equal("tenant", act.Level)
+	s.Equal("acme", act.Key)
+	s.Equal(10, act.Limit)
+
+	// the level doesn't apply to the request without the key
+	allowed := strategy.Decision{Allowed: true, Limit: 100, Count: 11, Remaining: 89}
+	s.user.On("Acquire", mock.Anything, "bob", 11).Return(allowed, nil).Once()
+	s.global.On("Acquire", mock.Anything, globalKey, 11).Return(allowed, nil).Once()
+	act, err = s.limiter.AcquireN(mockCTX, "api", Entries{"user": "bob"}, 11)
+	s.NoError(err)
+	s.True(act.Allowed)
+}
+
+func (s *rateLimiterSuite) TestMaxCostOf() {
+	s.Equal(10, maxCostOf([]rule.Limit{
+		{Strategy: rule.StrategyFixedWindow, Size: 1, Limit: 20},
+		{Strategy: rule.StrategyTokenBucket, Size: 10, Refill: 0.5},
+		{Strategy: rule.StrategySlidingWindow, Size: 60, Limit: 100},
+	}))
+}
+
+func (s *rateLimiterSuite) TestAcquireRuleNotFound() {
+	_, err := s.limiter.Acquire(mockCTX, "not-exist", Entries{})
+	s.Equal(ErrRuleNotFound, err)
+}
+
+func (s *rateLimiterSuite) TestParseLimits() {
+	act, err := parseLimits("fixedwindow:1:10, tokenbucket:60:0.5")
+	s.NoError(err)
+	s.Equal([]rule.Limit{
+		{Strategy: rule.StrategyFixedWindow, Size: 1, Limit: 10},
+		{Strategy: rule.StrategyTokenBucket, Size: 60, Refill: 0.5},
+	}, act)
+
+	_, err = parseLimits("fixedwindow:1")
+	s.EqualError(err, "invalid limit: fixedwindow:1")
+}
+
+func (s *rateLimiterSuite) TestAcquireShadow() {
+	s.limiter.rules["candidate"] = &limiterRule{
+		name:   "candidate",
+		shadow: true,
+		levels: []level{
+			{name: "user", key: "user", strategy: s.user},
+		},
+	}
+	denied := strategy.Decision{Allowed: false, Limit: 100, Count: 101, RetryAfter: time.Second}
+	s.user.On("Acquire", mock.Anything, "bob", 1).Return(denied, nil).Once()
+
+	act, err := s.limiter.Acquire(mockCTX, "candidate", Entries{"user": "bob"})
+	s.NoError(err)
+	s.True(act.Allowed)
+	s.True(act.WouldBlock)
+	s.True(act.Shadow)
+	s.Equal("user limit of rule candidate exceeded", act.Reason())
+	s.Equal(float64(1), testutil.ToFloat64(decisionCounter.WithLabelValues("candidate", "", resultWouldBlock)))
+}
+
+func (s *rateLimiterSuite) TestAcquireSpans() {
+	exporter := tracetest.NewInMemoryExporter()
+	shutdown := tracing.Register(sdktrace.WithSyncer(exporter))
+	defer shutdown(mockCTX)
+
+	s.limiter.rules["api"].levels[2].strategyName = "fixedwindow"
+	allowed := strategy.Decision{Allowed: true, Limit: 10, Count: 3, Remaining: 7}
+	s.user.On("Acquire", mock.Anything, "bob", 1).Return(allowed, nil).Once()
+	s.global.On("Acquire", mock.Anything, globalKey, 1).Return(allowed, nil).Once()
+
+	context, parent := tracing.Start(mockCTX, "parent")
+	_, err := s.limiter.Acquire(context, "api", Entries{"user": "bob"})
+	s.NoError(err)
+	parent.End()
+
+	spans := exporter.GetSpans()
+	s.Len(spans, 3)
+	span := spans[0]
+	s.Equal("strategy.Acquire", span.Name)
+	s.Equal(spans[2].SpanContext.SpanID, span.ParentSpanID)
+	s.ElementsMatch([]label.KeyValue{
+		tracing.Rule.String("api"),
+		tracing.Level.String("user"),
+		tracing.Strategy.String("fixedwindow"),
+		tracing.KeyHash.String(tracing.HashKey("bob")),
+		tracing.Result.String(resultAllowed),
+		tracing.Remaining.Int(7),
+	}, span.Attributes)
+}
+
+func (s *rateLimiterSuite) TestInspect() {
+	allowed := strategy.Decision{Allowed: true, Limit: 100, Count: 10, Remaining: 90}
+	s.user.On("Peek", mockCTX, "bob").Return(allowed, nil).Once()
+	s.global.On("Peek", mockCTX, globalKey).Return(allowed, nil).Once()
+
+	act, err := s.limiter.Inspect(mockCTX, "api", Entries{"user": "bob"})
+	s.NoError(err)
+	s.Equal([]Decision{
+		{Decision: allowed, Rule: "api", Level: "global"},
+		{Decision: allowed, Rule: "api", Level: "user", Key: "bob"},
+	}, act)
+}
+
+func (s *rateLimiterSuite) TestReset() {
+	s.tenant.On("Reset", mockCTX, "acme").Return(nil).Once()
+	s.user.On("Reset", mockCTX, "bob").Return(nil).Once()
+
+	s.NoError(s.limiter.Reset(mockCTX, "api", Entries{"tenant": "acme", "user": "bob"}))
+	s.Equal(ErrRuleNotFound, s.limiter.Reset(mockCTX, "not-exist", Entries{}))
+}
+
+func (s *rateLimiterSuite) TestApply() {
+	s.limiter.base = map[string]*limiterRule{
+		"api":    s.limiter.rules["api"],
+		"legacy": {name: "legacy"},
+	}
+	updated := &rule.Rule{
+		Name: "api",
+		Levels: []rule.Level{
+			{Name: "ip", Key: "ip", Limits: []rule.Limit{{Strategy: rule.StrategyFixedWindow, Size: 1, Limit: 10}}},
+		},
+	}
+
+	act := s.limiter.apply(map[string]*rule.Rule{
+		"api":    updated,
+		"legacy": nil,
+	})
+	s.Len(act, 1)
+	s.Equal(updated, act["api"].config)
+	s.Equal("ip", act["api"].levels[0].name)
+	s.Equal(rule.StrategyFixedWindow, act["api"].levels[0].strategyName)
+}
+
+func (s *rateLimiterSuite) TestRules() {
+	s.limiter.rules["b"] = &limiterRule{name: "b", config: &rule.Rule{Name: "b"}}
+	s.limiter.rules["a"] = &limiterRule{name: "a", config: &rule.Rule{Name: "a"}}
+
+	// the rule without config is built from flags
+	s.Equal([]rule.Rule{{Name: "a"}, {Name: "b"}}, s.limiter.Rules())
+}
+
+func (s *rateLimiterSuite) TestTopConsumersLevelNotFound() {
+	_, err := s.limiter.TopConsumers(mockCTX, "api", "global", 10)
+	s.Equal(ErrLevelNotFound, err)
+
+	act, err := s.limiter.TopConsumers(mockCTX, "api", "user", 10)
+	s.NoError(err)
+	s.Empty(act)
+}
+
+func (s *rateLimiterSuite) TestNewOverrides() {
+	limits := []rule.Limit{{Strategy: rule.StrategyFixedWindow, Size: 1, Limit: 1000}}
+	act := newOverrides(nil, s.limiter.rules, []rule.Override{
+		{Rule: "api", Level: "tenant", Pattern: "a*", Limits: limits},
+		{Rule: "api", Level: "tenant", Pattern: "acme-*", Limits: limits},
+		{Rule: "api", Level: "tenant", Pattern: "acme", Limits: limits},
+		{Rule: "api", Level: "global", Pattern: "*", Limits: limits},
+		{Rule: "not-exist", Level: "tenant", Pattern: "*", Limits: limits},
+	})
+
+	// overrides of shared levels and unknown rules are ignored
+	s.Len(act, 1)
+	patterns := []string{}
+	for _, o := range act[overridesKeyOf("api", "tenant")] {
+		patterns = append(patterns, o.config.Pattern)
+	}
+	s.Equal([]string{"acme", "acme-*", "a*"}, patterns)
+	s.Equal("tenant", act[overridesKeyOf("api", "tenant")][0].level.key)
+}
+
+func (s *rateLimiterSuite) TestAcquireOverride() {
+	now := time.Now()
+	past := now.Add(-time.Minute)
+	premium, expired := new(mockStrategy), new(mockStrategy)
+	s.limiter.overrides = overrides{
+		overridesKeyOf("api", "tenant"): {
+			{config: rule.Override{Pattern: "acme", ExpiresAt: &past}, level: level{name: "tenant", key: "tenant", strategy: expired}},
+			{config: rule.Override{Pattern: "ac*"}, level: level{name: "tenant", key: "tenant", strategy: premium}},
+		},
+	}
+	allowed := strategy.Decision{Allowed: true, Limit: 1000, Count: 1, Remaining: 999}
+	s.user.On("Acquire", mock.Anything, "bob", 1).Return(allowed, nil).Once()
+	premium.On("Acquire", mock.Anything, "acme", 1).Return(allowed, nil).Once()
+	s.global.On("Acquire", mock.Anything, globalKey, 1).Return(allowed, nil).Once()
+
+	_, err := s.limiter.Acquire(mockCTX, "api", Entries{"tenant": "acme", "user": "bob"})
+	s.NoError(err)
+	premium.AssertExpectations(s.T())
+	expired.AssertExpectations(s.T())
+	// the rule itself isn't changed
+	s.Equal(s.tenant, s.limiter.rules["api"].levels[1].strategy)
+}
@@ -0,0 +1,32 @@
+package ratelimiter
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	resultAllowed    = "allowed"
+	resultDenied     = "denied"
+	resultWouldBlock = "would_block"
+	resultError      = "error"
+)
+
+var (
+	decisionCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ratelimiter",
+		Name:      "decisions_total",
+		Help:      "The number of decisions by rule, strategy and result (allowed, denied, would_block or error).",
+	}, []string{"rule", "strategy", "result"})
+)
+
+func resultOf(decision Decision) string {
+	switch {
+	case decision.WouldBlock:
+		return resultWouldBlock
+	case decision.Allowed:
+		return resultAllowed
+	default:
+		return resultDenied
+	}
+}
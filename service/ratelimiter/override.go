@@ -0,0 +1,135 @@
+package ratelimiter
+
+import (
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/chihkaiyu/ratelimiter/service/ratelimiter/rule"
+	"github.com/chihkaiyu/ratelimiter/service/redis"
+)
+
+// override is an override with the level built from its limits
+type override struct {
+	config rule.Override
+	level  level
+}
+
+// overrides are the overrides by rule and level, keys are matched by the overrides in order
+type overrides map[string][]*override
+
+func overridesKeyOf(ruleName, levelName string) string {
+	return ruleName + ":" + levelName
+}
+
+// newOverrides builds the overrides of the levels in given rules, the ones of unknown levels are ignored
+func newOverrides(redis redis.Service, rules map[string]*limiterRule, configs []rule.Override) overrides {
+	result := overrides{}
+	for _, config := range configs {
+		l, ok := keyedLevel(rules[config.Rule], config.Level)
+		if !ok {
+			logrus.WithFields(logrus.Fields{
+				"rule":  config.Rule,
+				"level": config.Level,
+			}).Warn("override of unknown level is ignored")
+			continue
+		}
+
+		// the strategies share the counters with the level, so the count isn't reset by overriding
+		o := &override{
+			config: config,
+			level:  newLevel(redis, config.Rule, rule.Level{Name: l.name, Key: l.key, Limits: config.Limits}),
+		}
+		k := overridesKeyOf(config.Rule, config.Level)
+		result[k] = append(result[k], o)
+	}
+
+	// keys are matched exactly first, then by the longer glob
+	for _, list := range result {
+		sort.Slice(list, func(i, j int) bool {
+			iGlob, jGlob := isGlob(list[i].config.Pattern), isGlob(list[j].config.Pattern)
+			if iGlob != jGlob {
+				return !iGlob
+			}
+			if len(list[i].config.Pattern) != len(list[j].config.Pattern) {
+				return len(list[i].config.Pattern) > len(list[j].config.Pattern)
+			}
+			return list[i].config.Pattern < list[j].config.Pattern
+		})
+	}
+
+	return result
+}
+
+// keyedLevel returns the level of given name limited by key
+func keyedLevel(r *limiterRule, name string) (level, bool) {
+	if r == nil {
+		return level{}, false
+	}
+
+	for _, l := range r.levels {
+		if l.name == name && !l.shared() {
+			return l, true
+		}
+	}
+
+	return level{}, false
+}
+
+func isGlob(pattern string) bool {
+	return strings.ContainsAny(pattern, `*?[\`)
+}
+
+// match returns the override of the key, nil if there is none
+func (overs overrides) match(ruleName, levelName, key string, now time.Time) *override {
+	for _, o := range overs[overridesKeyOf(ruleName, levelName)] {
+		if o.config.Expired(now) {
+			continue
+		}
+		if o.config.Pattern == key {
+			return o
+		}
+		if matched, _ := path.Match(o.config.Pattern, key); matched {
+			return o
+		}
+	}
+
+	return nil
+}
+
+// apply returns the rule whose levels are replaced by the overrides of the request
+func (overs overrides) apply(r *limiterRule, descriptor Descriptor, now time.Time) *limiterRule {
+	if len(overs) == 0 {
+		return r
+	}
+
+	var levels []level
+	for i, l := range r.levels {
+		if l.shared() {
+			continue
+		}
+		key, ok := l.keyOf(descriptor)
+		if !ok {
+			continue
+		}
+		o := overs.match(r.name, l.name, key, now)
+		if o == nil {
+			continue
+		}
+
+		if levels == nil {
+			levels = append([]level{}, r.levels...)
+		}
+		levels[i] = o.level
+	}
+	if levels == nil {
+		return r
+	}
+
+	overridden := *r
+	overridden.levels = levels
+	return &overridden
+}
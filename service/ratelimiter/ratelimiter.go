@@ -1,10 +1,128 @@
 package ratelimiter
 
 import (
+	"errors"
+	"fmt"
+
 	"github.com/chihkaiyu/ratelimiter/base/ctx"
+	"github.com/chihkaiyu/ratelimiter/service/ratelimiter/rule"
+	"github.com/chihkaiyu/ratelimiter/service/ratelimiter/strategy"
+)
+
+var (
+	// ErrRuleNotFound is returned when acquiring with an unknown rule
+	ErrRuleNotFound = errors.New("rule not found")
+	// ErrLevelNotFound is returned when the rule has no such level limited by key
+	ErrLevelNotFound = errors.New("level not found")
+	// ErrOverrideNotFound is returned when deleting an unknown override
+	ErrOverrideNotFound = errors.New("override not found")
 )
 
+// Limiter makes the decisions, it's implemented by Service and the client of the remote service
+type Limiter interface {
+	// Acquire accquires the permission of given rule from rate limiter. The levels of the same
+	// strategy are acquired atomically in a single script. Levels of mixed strategies are acquired
+	// one by one and the permits are given back when a level denies, so concurrent requests may be
+	// denied by the permits which are given back later.
+	Acquire(context ctx.CTX, rule string, descriptor Descriptor) (Decision, error)
+
+	// AcquireN accquires n permits at once, n is the cost of the request
+	AcquireN(context ctx.CTX, rule string, descriptor Descriptor, n int) (Decision, error)
+}
+
+// Releaser gives back the permits granted to a request, e.g. when another rule rejects it
+type Releaser interface {
+	// Release gives back n permits of the levels of the rule applying to the request
+	Release(context ctx.CTX, rule string, descriptor Descriptor, n int) error
+}
+
 type Service interface {
-	// AccquireByIP accquires the permission from rate limiter
-	AcquireByIP(context ctx.CTX, ip string) (bool, int, error)
+	Limiter
+	Releaser
+
+	// Wait acquires n permits, it waits until they're allowed instead of being denied, see Wait
+	Wait(context ctx.CTX, rule string, descriptor Descriptor, n int) (Decision, error)
+
+	// Reserve takes n permits in advance, they're valid at the time to act of the reservation. Every
+	// level applying to the request must support reservation, e.g. token bucket, or it fails with
+	// strategy.ErrReserveNotSupported.
+	Reserve(context ctx.CTX, rule string, descriptor Descriptor, n int) (*Reservation, error)
+
+	// Rules lists the rules, the default rule built from flags is not listed
+	Rules() []rule.Rule
+
+	// SetRule creates or updates the rule, the change is persisted and applied to all replicas
+	SetRule(context ctx.CTX, r rule.Rule) error
+
+	// DeleteRule deletes the rule, the change is persisted and applied to all replicas
+	DeleteRule(context ctx.CTX, name string) error
+
+	// Inspect returns the state of every level of the rule for the request without acquiring
+	Inspect(context ctx.CTX, rule string, descriptor Descriptor) ([]Decision, error)
+
+	// Reset clears the counters of the levels of the rule limited by the keys of the request,
+	// shared levels are not reset
+	Reset(context ctx.CTX, rule string, descriptor Descriptor) error
+
+	// Overrides lists the overrides
+	Overrides() []rule.Override
+
+	// SetOverride creates or updates the override of the keys matching the pattern in a level,
+	// the change is persisted and applied to all replicas
+	SetOverride(context ctx.CTX, o rule.Override) error
+
+	// DeleteOverride deletes the override, the change is persisted and applied to all replicas
+	DeleteOverride(context ctx.CTX, rule, level, pattern string) error
+
+	// TopConsumers returns at most n keys making most requests to the level of the rule recently,
+	// it's empty if top consumers are not counted
+	TopConsumers(context ctx.CTX, rule, level string, n int) ([]Consumer, error)
+}
+
+// Consumer is a key and the number of its requests
+type Consumer struct {
+	Key      string
+	Requests int
+}
+
+// Descriptor describes a request by the values of keys
+type Descriptor interface {
+	// Value returns the value of given key, false when the request doesn't have the key
+	Value(key string) (string, bool)
+}
+
+// Entries is a descriptor of fixed key-value pairs
+type Entries map[string]string
+
+// Value implements Descriptor
+func (e Entries) Value(key string) (string, bool) {
+	value, ok := e[key]
+	return value, ok
+}
+
+// Decision is the result of acquiring a permission of a rule
+type Decision struct {
+	strategy.Decision
+	// Rule is the name of the rule
+	Rule string
+	// Level is the name of the level which made the decision
+	Level string
+	// Strategy is the name of the strategy of the level
+	Strategy string
+	// Key is the limited key of the level, empty if the level is shared by all requests
+	Key string
+	// WouldBlock is true when the rule is in shadow mode and the request would have been rejected,
+	// the request is allowed in this case
+	WouldBlock bool
+	// Shadow is true when the rule is in shadow mode
+	Shadow bool
+}
+
+// Reason returns why the request is (or would have been) rejected, empty when allowed
+func (d Decision) Reason() string {
+	if d.Allowed && !d.WouldBlock {
+		return ""
+	}
+
+	return fmt.Sprintf("%s limit of rule %s exceeded", d.Level, d.Rule)
 }
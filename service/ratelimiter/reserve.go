@@ -0,0 +1,132 @@
+package ratelimiter
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/chihkaiyu/ratelimiter/base/ctx"
+	"github.com/chihkaiyu/ratelimiter/service/ratelimiter/strategy"
+)
+
+// Reservation is the permits taken in advance by Reserve
+type Reservation struct {
+	// Decision is allowed if the permits are reserved, it's the level whose permits are valid at last,
+	// or the level denying the reservation
+	Decision
+	// TimeToAct is when the reserved permits are valid
+	TimeToAct time.Time
+
+	mutex      sync.Mutex
+	levels     []level
+	descriptor Descriptor
+	n          int
+}
+
+// Delay returns the time to wait before acting, zero if the permits are valid now
+func (r *Reservation) Delay() time.Duration {
+	if delay := r.TimeToAct.Sub(timeNow()); delay > 0 {
+		return delay
+	}
+
+	return 0
+}
+
+// Cancel gives back the reserved permits, it should only be called if the permits are not used.
+// Canceling a denied or canceled reservation does nothing.
+func (r *Reservation) Cancel(context ctx.CTX) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for len(r.levels) > 0 {
+		l := r.levels[0]
+		key, _ := l.keyOf(r.descriptor)
+		if err := l.strategy.Release(context, key, r.n); err != nil {
+			context.WithFields(logrus.Fields{
+				"err":   err,
+				"level": l.name,
+			}).Error("strategy.Release failed")
+			return err
+		}
+		r.levels = r.levels[1:]
+	}
+
+	return nil
+}
+
+func (im *impl) Reserve(context ctx.CTX, ruleName string, descriptor Descriptor, n int) (*Reservation, error) {
+	r, err := im.ruleFor(context, ruleName, descriptor)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.reserve(context, descriptor, n)
+}
+
+// reserve reserves n permits from every level applying to the request, nothing is reserved if
+// any level denies it
+func (r *limiterRule) reserve(context ctx.CTX, descriptor Descriptor, n int) (*Reservation, error) {
+	type reserving struct {
+		level
+		key      string
+		reserver strategy.Reserver
+	}
+	levels := []reserving{}
+	for i := len(r.levels) - 1; i >= 0; i-- {
+		l := r.levels[i]
+		key, ok := l.keyOf(descriptor)
+		if !ok {
+			continue
+		}
+
+		reserver, ok := l.strategy.(strategy.Reserver)
+		if !ok {
+			return nil, strategy.ErrReserveNotSupported
+		}
+		levels = append(levels, reserving{level: l, key: key, reserver: reserver})
+	}
+
+	now := timeNow()
+	reservation := &Reservation{
+		Decision:   Decision{Decision: strategy.Decision{Allowed: true}, Rule: r.name},
+		TimeToAct:  now,
+		descriptor: descriptor,
+		n:          n,
+	}
+	for _, l := range levels {
+		decision, err := l.reserver.Reserve(context, l.key, n)
+		if err != nil {
+			context.WithFields(logrus.Fields{
+				"err":   err,
+				"rule":  r.name,
+				"level": l.name,
+			}).Error("strategy.Reserve failed")
+			release(context, reservation.levels, descriptor, n)
+			return nil, err
+		}
+
+		if !decision.Allowed {
+			release(context, reservation.levels, descriptor, n)
+			denied := &Reservation{Decision: l.decision(r.name, l.key, decision), TimeToAct: now}
+			if r.shadow {
+				context.WithFields(logrus.Fields{
+					"rule":   r.name,
+					"level":  l.name,
+					"reason": denied.Reason(),
+				}).Info("reservation would have been denied by shadow rule")
+				denied.Allowed = true
+				denied.WouldBlock = true
+			}
+			return denied, nil
+		}
+
+		if len(reservation.levels) == 0 || decision.RetryAfter > reservation.RetryAfter {
+			reservation.Decision = l.decision(r.name, l.key, decision)
+		}
+		reservation.levels = append(reservation.levels, l.level)
+	}
+	reservation.TimeToAct = now.Add(reservation.RetryAfter)
+
+	return reservation, nil
+}
@@ -0,0 +1,114 @@
+package ratelimiter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/chihkaiyu/ratelimiter/base/ctx"
+	"github.com/chihkaiyu/ratelimiter/service/ratelimiter/strategy"
+)
+
+type mockReserver struct {
+	mockStrategy
+}
+
+func (m *mockReserver) Reserve(context ctx.CTX, key string, n int) (strategy.Decision, error) {
+	args := m.Called(context, key, n)
+	return args.Get(0).(strategy.Decision), args.Error(1)
+}
+
+type reserveSuite struct {
+	suite.Suite
+	limiter *impl
+	global  *mockReserver
+	user    *mockReserver
+	now     time.Time
+}
+
+func TestReserveSuite(t *testing.T) {
+	suite.Run(t, new(reserveSuite))
+}
+
+func (s *reserveSuite) SetupTest() {
+	s.global = new(mockReserver)
+	s.user = new(mockReserver)
+	s.limiter = &impl{
+		rules: map[string]*limiterRule{
+			"api": {
+				name: "api",
+				levels: []level{
+					{name: "global", strategy: s.global},
+					{name: "user", key: "user", strategy: s.user},
+				},
+			},
+			"mixed": {
+				name: "mixed",
+				levels: []level{
+					{name: "global", strategy: new(mockStrategy)},
+					{name: "user", key: "user", strategy: s.user},
+				},
+			},
+		},
+	}
+
+	s.now = time.Unix(1600000000, 0)
+	timeNow = func() time.Time { return s.now }
+}
+
+func (s *reserveSuite) TearDownTest() {
+	s.global.AssertExpectations(s.T())
+	s.user.AssertExpectations(s.T())
+	timeNow = time.Now
+}
+
+func (s *reserveSuite) TestReserve() {
+	user := strategy.Decision{Allowed: true, Count: 12, Limit: 10, RetryAfter: 2 * time.Second}
+	global := strategy.Decision{Allowed: true, Count: 50, Limit: 100, Remaining: 50}
+	s.user.On("Reserve", mock.Anything, "bob", 3).Return(user, nil).Once()
+	s.global.On("Reserve", mock.Anything, globalKey, 3).Return(global, nil).Once()
+
+	reservation, err := s.limiter.Reserve(mockCTX, "api", Entries{"user": "bob"}, 3)
+	s.Require().NoError(err)
+	s.True(reservation.Allowed)
+	s.Equal("user", reservation.Level)
+	s.Equal("bob", reservation.Key)
+	s.Equal(s.now.Add(2*time.Second), reservation.TimeToAct)
+	s.now = s.now.Add(time.Second)
+	s.Equal(time.Second, reservation.Delay())
+
+	// canceled once
+	s.user.On("Release", mockCTX, "bob", 3).Return(nil).Once()
+	s.global.On("Release", mockCTX, globalKey, 3).Return(nil).Once()
+	s.NoError(reservation.Cancel(mockCTX))
+	s.NoError(reservation.Cancel(mockCTX))
+}
+
+func (s *reserveSuite) TestReserveDenied() {
+	user := strategy.Decision{Allowed: true, Count: 12, Limit: 10, RetryAfter: 2 * time.Second}
+	global := strategy.Decision{Allowed: false, Count: 200, Limit: 100, RetryAfter: time.Minute}
+	s.user.On("Reserve", mock.Anything, "bob", 3).Return(user, nil).Once()
+	s.global.On("Reserve", mock.Anything, globalKey, 3).Return(global, nil).Once()
+	s.user.On("Release", mock.Anything, "bob", 3).Return(nil).Once()
+
+	reservation, err := s.limiter.Reserve(mockCTX, "api", Entries{"user": "bob"}, 3)
+	s.Require().NoError(err)
+	s.False(reservation.Allowed)
+	s.Equal("global", reservation.Level)
+	s.Equal(time.Minute, reservation.RetryAfter)
+	s.Equal("global limit of rule api exceeded", reservation.Reason())
+
+	// nothing to give back
+	s.NoError(reservation.Cancel(mockCTX))
+}
+
+func (s *reserveSuite) TestReserveError() {
+	_, err := s.limiter.Reserve(mockCTX, "not-exist", Entries{"user": "bob"}, 1)
+	s.Equal(ErrRuleNotFound, err)
+
+	// nothing is reserved if any level can't reserve
+	_, err = s.limiter.Reserve(mockCTX, "mixed", Entries{"user": "bob"}, 1)
+	s.Equal(strategy.ErrReserveNotSupported, err)
+}
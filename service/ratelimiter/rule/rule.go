@@ -0,0 +1,303 @@
+package rule
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	// StrategyFixedWindow is the name of fixed window strategy
+	StrategyFixedWindow = "fixedwindow"
+	// StrategySlidingWindow is the name of sliding window strategy
+	StrategySlidingWindow = "slidingwindow"
+	// StrategyTokenBucket is the name of token bucket strategy
+	StrategyTokenBucket = "tokenbucket"
+
+	// ResponseJSON is the type of JSON response
+	ResponseJSON = "json"
+	// ResponseText is the type of plain text response
+	ResponseText = "text"
+	// ResponseHTML is the type of HTML response
+	ResponseHTML = "html"
+	// ResponseRedirect is the type of response redirecting to the location in body
+	ResponseRedirect = "redirect"
+)
+
+var (
+	rulesFile = flag.String("rules_file", "", "the path of rules config in JSON, the default rule is built from flags if there is no rule")
+)
+
+// Config is the rules config
+type Config struct {
+	Rules []Rule `json:"rules"`
+	// Allowlist exempts requests from rate limiting
+	Allowlist AccessList `json:"allowlist"`
+	// Denylist rejects requests before rate limiting
+	Denylist AccessList `json:"denylist"`
+	// Responses are the responses of the requests rejected by the rules, keyed by the rule name
+	Responses map[string]Response `json:"responses,omitempty"`
+	// FailureResponse is the response when the rate limiter fails
+	FailureResponse *Response `json:"failure_response,omitempty"`
+}
+
+// Response is the template of the response, the placeholders in body are replaced by the values
+// of the decision, e.g. ${limit}, ${remaining}, ${retry_after} and ${request_id}
+type Response struct {
+	// Type is json, text, html or redirect
+	Type string `json:"type"`
+	// Status is the status code, it's set by the API if it's 0
+	Status int `json:"status,omitempty"`
+	// Body is the body, or the location for redirect
+	Body string `json:"body"`
+}
+
+// AccessList is a list of clients
+type AccessList struct {
+	// IPs are IPs or CIDRs, e.g. "10.0.0.1" or "10.0.0.0/8"
+	IPs     []string `json:"ips,omitempty"`
+	APIKeys []string `json:"api_keys,omitempty"`
+}
+
+// Rule is a named set of nested limits, a request must pass every level of the rule
+type Rule struct {
+	Name string `json:"name"`
+	// Shadow evaluates the rule and updates its counters without rejecting any request,
+	// the requests would have been rejected are only logged
+	Shadow bool `json:"shadow,omitempty"`
+	// Levels are listed from the widest (e.g. global) to the narrowest (e.g. user)
+	Levels []Level `json:"levels"`
+}
+
+// Level is one level of the rule, e.g. global, tenant or user
+type Level struct {
+	Name string `json:"name"`
+	// Key is the key of request to derive the limited key from, e.g. "ip" or "header:X-Tenant-ID",
+	// the level is shared by all requests if it's empty
+	Key string `json:"key,omitempty"`
+	// Limits are applied together, e.g. 10 per second and 1000 per hour
+	Limits []Limit `json:"limits"`
+}
+
+// Limit is the parameters of a strategy
+type Limit struct {
+	Strategy string `json:"strategy"`
+	// Size is the window size in second, or the bucket size for token bucket
+	Size int `json:"size"`
+	// Limit is the number of requests could be accepted in a window
+	Limit int `json:"limit,omitempty"`
+	// Refill is the number of tokens refilled in one second for token bucket
+	Refill float64 `json:"refill,omitempty"`
+	// MaxDebt is the number of tokens could be reserved in advance for token bucket, it's the size if it's 0
+	MaxDebt int `json:"max_debt,omitempty"`
+}
+
+// Override replaces the limits of a level for the keys matching the pattern, e.g. higher limits for a customer
+type Override struct {
+	Rule  string `json:"rule"`
+	Level string `json:"level"`
+	// Pattern matches the keys of the level, either a key or a glob like "acme-*"
+	Pattern string  `json:"pattern"`
+	Limits  []Limit `json:"limits"`
+	// ExpiresAt is when the override expires, it never expires if it's empty
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// Expired returns true if the override is expired at given time
+func (o Override) Expired(now time.Time) bool {
+	return o.ExpiresAt != nil && !now.Before(*o.ExpiresAt)
+}
+
+// Validate returns error if the override is invalid
+func (o Override) Validate() error {
+	if o.Rule == "" || o.Level == "" {
+		return fmt.Errorf("empty rule or level")
+	}
+	if o.Pattern == "" {
+		return fmt.Errorf("empty pattern")
+	}
+	if _, err := path.Match(o.Pattern, ""); err != nil {
+		return fmt.Errorf("invalid pattern %s: %v", o.Pattern, err)
+	}
+
+	if len(o.Limits) == 0 {
+		return fmt.Errorf("no limit in override")
+	}
+	for _, limit := range o.Limits {
+		if err := limit.Validate(); err != nil {
+			return fmt.Errorf("invalid limit: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// LoadFile loads the rules config from the file set by flag, returns empty config if the flag is empty
+func LoadFile() (Config, error) {
+	if *rulesFile == "" {
+		return Config{}, nil
+	}
+
+	return Load(*rulesFile)
+}
+
+// Load loads the rules config from given JSON file
+func Load(path string) (Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+
+	return Parse(data)
+}
+
+// Parse parses and validates the rules config
+func Parse(data []byte) (Config, error) {
+	config := Config{}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return Config{}, err
+	}
+
+	if err := config.Validate(); err != nil {
+		return Config{}, err
+	}
+
+	return config, nil
+}
+
+// Validate returns error if the config is invalid
+func (c Config) Validate() error {
+	names := map[string]bool{}
+	for _, r := range c.Rules {
+		if names[r.Name] {
+			return fmt.Errorf("duplicated rule: %s", r.Name)
+		}
+		names[r.Name] = true
+
+		if err := r.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Allowlist.Validate(); err != nil {
+		return fmt.Errorf("invalid allowlist: %v", err)
+	}
+	if err := c.Denylist.Validate(); err != nil {
+		return fmt.Errorf("invalid denylist: %v", err)
+	}
+
+	for name, resp := range c.Responses {
+		if err := resp.Validate(); err != nil {
+			return fmt.Errorf("invalid response of rule %s: %v", name, err)
+		}
+	}
+	if c.FailureResponse != nil {
+		if err := c.FailureResponse.Validate(); err != nil {
+			return fmt.Errorf("invalid failure response: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// Validate returns error if the response is invalid
+func (r Response) Validate() error {
+	switch r.Type {
+	case ResponseJSON, ResponseText, ResponseHTML:
+	case ResponseRedirect:
+		if r.Body == "" {
+			return fmt.Errorf("empty location")
+		}
+	default:
+		return fmt.Errorf("unknown type: %s", r.Type)
+	}
+
+	if r.Status != 0 && (r.Status < 100 || r.Status > 599) {
+		return fmt.Errorf("invalid status: %d", r.Status)
+	}
+
+	return nil
+}
+
+// Validate returns error if there is an invalid IP or CIDR in the list
+func (a AccessList) Validate() error {
+	for _, ip := range a.IPs {
+		if strings.Contains(ip, "/") {
+			if _, _, err := net.ParseCIDR(ip); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if net.ParseIP(ip) == nil {
+			return fmt.Errorf("invalid IP: %s", ip)
+		}
+	}
+
+	return nil
+}
+
+// Validate returns error if the rule is invalid
+func (r Rule) Validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("empty rule name")
+	}
+	if len(r.Levels) == 0 {
+		return fmt.Errorf("no level in rule %s", r.Name)
+	}
+
+	names := map[string]bool{}
+	for _, l := range r.Levels {
+		if l.Name == "" {
+			return fmt.Errorf("empty level name in rule %s", r.Name)
+		}
+		if names[l.Name] {
+			return fmt.Errorf("duplicated level %s in rule %s", l.Name, r.Name)
+		}
+		names[l.Name] = true
+
+		if len(l.Limits) == 0 {
+			return fmt.Errorf("no limit in level %s of rule %s", l.Name, r.Name)
+		}
+		for _, limit := range l.Limits {
+			if err := limit.Validate(); err != nil {
+				return fmt.Errorf("invalid limit in level %s of rule %s: %v", l.Name, r.Name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Validate returns error if the limit is invalid
+func (l Limit) Validate() error {
+	if l.Size <= 0 {
+		return fmt.Errorf("size must be positive")
+	}
+	if l.MaxDebt < 0 {
+		return fmt.Errorf("max debt must not be negative")
+	}
+	if l.MaxDebt > 0 && l.Strategy != StrategyTokenBucket {
+		return fmt.Errorf("max debt is only for token bucket")
+	}
+
+	switch l.Strategy {
+	case StrategyFixedWindow, StrategySlidingWindow:
+		if l.Limit <= 0 {
+			return fmt.Errorf("limit must be positive")
+		}
+	case StrategyTokenBucket:
+		if l.Refill <= 0 {
+			return fmt.Errorf("refill must be positive")
+		}
+	default:
+		return fmt.Errorf("unknown strategy: %s", l.Strategy)
+	}
+
+	return nil
+}
@@ -0,0 +1,171 @@
+package rule
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type ruleSuite struct {
+	suite.Suite
+}
+
+func TestRuleSuite(t *testing.T) {
+	suite.Run(t, new(ruleSuite))
+}
+
+func (s *ruleSuite) TestParse() {
+	tests := []struct {
+		Desc   string
+		Input  string
+		Exp    Config
+		ExpErr string
+	}{
+		{
+			Desc: "hierarchical rule",
+			Input: `{"rules": [{"name": "api", "levels": [
+				{"name": "global", "limits": [{"strategy": "tokenbucket", "size": 1000, "refill": 500}]},
+				{"name": "tenant", "key": "header:X-Tenant-ID", "limits": [{"strategy": "slidingwindow", "size": 60, "limit": 600}]},
+				{"name": "user", "key": "ip", "limits": [
+					{"strategy": "fixedwindow", "size": 1, "limit": 10},
+					{"strategy": "fixedwindow", "size": 3600, "limit": 1000}
+				]}
+			]}]}`,
+			Exp: Config{Rules: []Rule{{
+				Name: "api",
+				Levels: []Level{
+					{Name: "global", Limits: []Limit{{Strategy: StrategyTokenBucket, Size: 1000, Refill: 500}}},
+					{Name: "tenant", Key: "header:X-Tenant-ID", Limits: []Limit{{Strategy: StrategySlidingWindow, Size: 60, Limit: 600}}},
+					{Name: "user", Key: "ip", Limits: []Limit{
+						{Strategy: StrategyFixedWindow, Size: 1, Limit: 10},
+						{Strategy: StrategyFixedWindow, Size: 3600, Limit: 1000},
+					}},
+				},
+			}}},
+		},
+		{
+			Desc: "access lists",
+			Input: `{
+				"allowlist": {"ips": ["10.0.0.1", "192.168.0.0/16"], "api_keys": ["health-checker"]},
+				"denylist": {"ips": ["2001:db8::/32"]}
+			}`,
+			Exp: Config{
+				Allowlist: AccessList{IPs: []string{"10.0.0.1", "192.168.0.0/16"}, APIKeys: []string{"health-checker"}},
+				Denylist:  AccessList{IPs: []string{"2001:db8::/32"}},
+			},
+		},
+		{
+			Desc:   "invalid CIDR in access list",
+			Input:  `{"denylist": {"ips": ["10.0.0.0/33"]}}`,
+			ExpErr: "invalid denylist: invalid CIDR address: 10.0.0.0/33",
+		},
+		{
+			Desc:   "invalid json",
+			Input:  `{"rules": [`,
+			ExpErr: "unexpected end of JSON input",
+		},
+		{
+			Desc: "duplicated rule",
+			Input: `{"rules": [
+				{"name": "api", "levels": [{"name": "user", "key": "ip", "limits": [{"strategy": "fixedwindow", "size": 1, "limit": 10}]}]},
+				{"name": "api", "levels": [{"name": "user", "key": "ip", "limits": [{"strategy": "fixedwindow", "size": 1, "limit": 10}]}]}
+			]}`,
+			ExpErr: "duplicated rule: api",
+		},
+		{
+			Desc:   "no level",
+			Input:  `{"rules": [{"name": "api", "levels": []}]}`,
+			ExpErr: "no level in rule api",
+		},
+		{
+			Desc:   "unknown strategy",
+			Input:  `{"rules": [{"name": "api", "levels": [{"name": "user", "limits": [{"strategy": "leakybucket", "size": 1}]}]}]}`,
+			ExpErr: "invalid limit in level user of rule api: unknown strategy: leakybucket",
+		},
+		{
+			Desc:   "token bucket without refill",
+			Input:  `{"rules": [{"name": "api", "levels": [{"name": "user", "limits": [{"strategy": "tokenbucket", "size": 10}]}]}]}`,
+			ExpErr: "invalid limit in level user of rule api: refill must be positive",
+		},
+		{
+			Desc:   "max debt of fixed window",
+			Input:  `{"rules": [{"name": "api", "levels": [{"name": "user", "limits": [{"strategy": "fixedwindow", "size": 10, "limit": 10, "max_debt": 5}]}]}]}`,
+			ExpErr: "invalid limit in level user of rule api: max debt is only for token bucket",
+		},
+		{
+			Desc: "responses",
+			Input: `{"responses": {"api": {"type": "html", "body": "<p>retry after ${retry_after}s</p>"}},
+				"failure_response": {"type": "redirect", "status": 307, "body": "https://status.example.com"}}`,
+			Exp: Config{
+				Responses:       map[string]Response{"api": {Type: ResponseHTML, Body: "<p>retry after ${retry_after}s</p>"}},
+				FailureResponse: &Response{Type: ResponseRedirect, Status: 307, Body: "https://status.example.com"},
+			},
+		},
+		{
+			Desc:   "unknown response type",
+			Input:  `{"responses": {"api": {"type": "xml", "body": "<error/>"}}}`,
+			ExpErr: "invalid response of rule api: unknown type: xml",
+		},
+		{
+			Desc:   "redirect without location",
+			Input:  `{"failure_response": {"type": "redirect"}}`,
+			ExpErr: "invalid failure response: empty location",
+		},
+	}
+
+	for _, test := range tests {
+		act, err := Parse([]byte(test.Input))
+		if test.ExpErr != "" {
+			s.EqualError(err, test.ExpErr, test.Desc)
+			continue
+		}
+
+		s.NoError(err, test.Desc)
+		s.Equal(test.Exp, act, test.Desc)
+	}
+}
+
+func (s *ruleSuite) TestOverride() {
+	now := time.Now()
+	expiresAt := now.Add(time.Hour)
+	limits := []Limit{{Strategy: StrategyFixedWindow, Size: 1, Limit: 100}}
+
+	tests := []struct {
+		Desc       string
+		Override   Override
+		ExpErr     string
+		ExpExpired bool
+	}{
+		{
+			Desc:     "valid",
+			Override: Override{Rule: "api", Level: "tenant", Pattern: "acme-*", Limits: limits, ExpiresAt: &expiresAt},
+		},
+		{
+			Desc:     "invalid pattern",
+			Override: Override{Rule: "api", Level: "tenant", Pattern: "acme-[", Limits: limits},
+			ExpErr:   "invalid pattern acme-[: syntax error in pattern",
+		},
+		{
+			Desc:     "no limit",
+			Override: Override{Rule: "api", Level: "tenant", Pattern: "acme"},
+			ExpErr:   "no limit in override",
+		},
+		{
+			Desc:       "expired",
+			Override:   Override{Rule: "api", Level: "tenant", Pattern: "acme", Limits: limits, ExpiresAt: &now},
+			ExpExpired: true,
+		},
+	}
+
+	for _, test := range tests {
+		err := test.Override.Validate()
+		if test.ExpErr != "" {
+			s.EqualError(err, test.ExpErr, test.Desc)
+			continue
+		}
+
+		s.NoError(err, test.Desc)
+		s.Equal(test.ExpExpired, test.Override.Expired(now), test.Desc)
+	}
+}
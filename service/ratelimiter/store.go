@@ -0,0 +1,184 @@
+package ratelimiter
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/chihkaiyu/ratelimiter/base/ctx"
+	"github.com/chihkaiyu/ratelimiter/service/ratelimiter/rule"
+	"github.com/chihkaiyu/ratelimiter/service/redis"
+)
+
+const (
+	// rulesKey is the hash of rules changed at runtime, a deleted rule is stored as an empty value
+	// so the rule is also deleted if it's in the rules file
+	rulesKey = "ratelimiter:rules"
+	// rulesVersionKey is increased whenever a rule is changed, so replicas know when to reload
+	rulesVersionKey = "ratelimiter:rules:version"
+
+	// overridesKey is the hash of overrides
+	overridesKey = "ratelimiter:overrides"
+	// overridesVersionKey is increased whenever an override is changed
+	overridesVersionKey = "ratelimiter:overrides:version"
+)
+
+// store persists the rules changed at runtime
+type store struct {
+	redis redis.Service
+}
+
+// version returns the version of given version key, zero if nothing has been changed
+func (s *store) version(context ctx.CTX, key string) (int64, error) {
+	value, err := s.redis.Get(context, key)
+	if err == redis.Nil {
+		return 0, nil
+	}
+	if err != nil {
+		context.WithField("err", err).Error("redis.Get failed")
+		return 0, err
+	}
+
+	return strconv.ParseInt(string(value), 10, 64)
+}
+
+// load returns the rules changed at runtime by name, nil for deleted ones. The rules which can't be
+// parsed or are invalid are skipped.
+func (s *store) load(context ctx.CTX) (map[string]*rule.Rule, error) {
+	values, err := s.redis.HGetAll(context, rulesKey)
+	if err != nil {
+		context.WithField("err", err).Error("redis.HGetAll failed")
+		return nil, err
+	}
+
+	rules := map[string]*rule.Rule{}
+	for name, value := range values {
+		if value == "" {
+			rules[name] = nil
+			continue
+		}
+
+		// a bad rule is skipped instead of failing the others, the rule from file is served if any
+		r := &rule.Rule{}
+		if err := json.Unmarshal([]byte(value), r); err != nil {
+			context.WithFields(logrus.Fields{
+				"err":  err,
+				"rule": name,
+			}).Error("json.Unmarshal failed, rule skipped")
+			continue
+		}
+		if err := r.Validate(); err != nil {
+			context.WithFields(logrus.Fields{
+				"err":  err,
+				"rule": name,
+			}).Error("rule.Validate failed, rule skipped")
+			continue
+		}
+		rules[name] = r
+	}
+
+	return rules, nil
+}
+
+// save saves the rule, or deletes the rule of given name if the rule is nil
+func (s *store) save(context ctx.CTX, name string, r *rule.Rule) error {
+	value := []byte{}
+	if r != nil {
+		data, err := json.Marshal(r)
+		if err != nil {
+			context.WithField("err", err).Error("json.Marshal failed")
+			return err
+		}
+		value = data
+	}
+
+	if err := s.redis.HSet(context, rulesKey, name, value); err != nil {
+		context.WithFields(logrus.Fields{
+			"err":  err,
+			"rule": name,
+		}).Error("redis.HSet failed")
+		return err
+	}
+
+	return s.bump(context, rulesVersionKey)
+}
+
+// overrideField returns the field of the override in the hash
+func overrideField(ruleName, levelName, pattern string) string {
+	return fmt.Sprintf("%s:%s:%s", ruleName, levelName, pattern)
+}
+
+// loadOverrides returns the overrides which are not expired, the expired ones are deleted
+func (s *store) loadOverrides(context ctx.CTX, now time.Time) ([]rule.Override, error) {
+	values, err := s.redis.HGetAll(context, overridesKey)
+	if err != nil {
+		context.WithField("err", err).Error("redis.HGetAll failed")
+		return nil, err
+	}
+
+	overrides := []rule.Override{}
+	expired := []string{}
+	for field, value := range values {
+		o := rule.Override{}
+		if err := json.Unmarshal([]byte(value), &o); err != nil {
+			context.WithFields(logrus.Fields{
+				"err":      err,
+				"override": field,
+			}).Error("json.Unmarshal failed, override skipped")
+			continue
+		}
+		if o.Expired(now) {
+			expired = append(expired, field)
+			continue
+		}
+		overrides = append(overrides, o)
+	}
+
+	if len(expired) > 0 {
+		if err := s.redis.HDel(context, overridesKey, expired...); err != nil {
+			// they are deleted next time
+			context.WithField("err", err).Error("redis.HDel failed")
+		}
+	}
+
+	return overrides, nil
+}
+
+// saveOverride creates or updates the override
+func (s *store) saveOverride(context ctx.CTX, o rule.Override) error {
+	data, err := json.Marshal(o)
+	if err != nil {
+		context.WithField("err", err).Error("json.Marshal failed")
+		return err
+	}
+
+	if err := s.redis.HSet(context, overridesKey, overrideField(o.Rule, o.Level, o.Pattern), data); err != nil {
+		context.WithField("err", err).Error("redis.HSet failed")
+		return err
+	}
+
+	return s.bump(context, overridesVersionKey)
+}
+
+// deleteOverride deletes the override
+func (s *store) deleteOverride(context ctx.CTX, ruleName, levelName, pattern string) error {
+	if err := s.redis.HDel(context, overridesKey, overrideField(ruleName, levelName, pattern)); err != nil {
+		context.WithField("err", err).Error("redis.HDel failed")
+		return err
+	}
+
+	return s.bump(context, overridesVersionKey)
+}
+
+// bump increases the version, so replicas know when to reload
+func (s *store) bump(context ctx.CTX, key string) error {
+	if _, err := s.redis.Incr(context, key); err != nil {
+		context.WithField("err", err).Error("redis.Incr failed")
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,86 @@
+package ratelimiter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/chihkaiyu/ratelimiter/base/docker"
+	"github.com/chihkaiyu/ratelimiter/service/ratelimiter/rule"
+	"github.com/chihkaiyu/ratelimiter/service/redis"
+)
+
+type storeSuite struct {
+	suite.Suite
+	redisPort string
+	redis     redis.Service
+	store     *store
+}
+
+func TestStoreSuite(t *testing.T) {
+	suite.Run(t, new(storeSuite))
+}
+
+func (s *storeSuite) SetupSuite() {
+	ports, err := docker.RunExternal([]string{"redis"})
+	s.NoError(err)
+
+	s.redisPort = ports[0]
+}
+
+func (s *storeSuite) TearDownSuite() {
+	s.NoError(docker.RemoveExternal())
+}
+
+func (s *storeSuite) SetupTest() {
+	redis, err := redis.NewRedis("localhost:"+s.redisPort, "")
+	s.Require().NoError(err)
+	s.redis = redis
+	s.store = &store{redis: redis}
+}
+
+func (s *storeSuite) TearDownTest() {
+	s.NoError(s.redis.Close())
+	s.NoError(docker.ClearRedis(s.redisPort))
+}
+
+func (s *storeSuite) TestLoad() {
+	r := rule.Rule{Name: "api", Levels: []rule.Level{
+		{Name: "user", Key: "user", Limits: []rule.Limit{{Strategy: rule.StrategyFixedWindow, Size: 1, Limit: 10}}},
+	}}
+	s.NoError(s.store.save(mockCTX, "api", &r))
+	s.NoError(s.store.save(mockCTX, "deleted", nil))
+	// the bad rules don't fail the others
+	s.NoError(s.redis.HSet(mockCTX, rulesKey, "broken", []byte(`{"name": `)))
+	s.NoError(s.redis.HSet(mockCTX, rulesKey, "invalid", []byte(`{"name": "invalid", "levels": []}`)))
+
+	act, err := s.store.load(mockCTX)
+	s.NoError(err)
+	s.Equal(map[string]*rule.Rule{"api": &r, "deleted": nil}, act)
+
+	version, err := s.store.version(mockCTX, rulesVersionKey)
+	s.NoError(err)
+	s.Equal(int64(2), version)
+
+	// the keys are prefixed
+	value, err := s.redis.Get(mockCTX, "ratelimiter:rules:version")
+	s.NoError(err)
+	s.Equal("2", string(value))
+}
+
+func (s *storeSuite) TestLoadOverrides() {
+	now := time.Unix(1600000000, 0)
+	o := rule.Override{
+		Rule:    "api",
+		Level:   "user",
+		Pattern: "bob",
+		Limits:  []rule.Limit{{Strategy: rule.StrategyFixedWindow, Size: 1, Limit: 100}},
+	}
+	s.NoError(s.store.saveOverride(mockCTX, o))
+	s.NoError(s.redis.HSet(mockCTX, overridesKey, "api:user:broken", []byte(`{"rule": `)))
+
+	act, err := s.store.loadOverrides(mockCTX, now)
+	s.NoError(err)
+	s.Equal([]rule.Override{o}, act)
+}
@@ -0,0 +1,148 @@
+package composite
+
+import (
+	"github.com/sirupsen/logrus"
+
+	"github.com/chihkaiyu/ratelimiter/base/ctx"
+	"github.com/chihkaiyu/ratelimiter/service/ratelimiter/strategy"
+)
+
+type impl struct {
+	strategies []strategy.Strategy
+}
+
+// NewComposite returns a strategy which permits a request only when all given strategies permit it.
+// Strategies are acquired in order, a request denied by one strategy gives back the permits
+// already granted by the previous ones, so it doesn't consume from them.
+func NewComposite(
+	strategies ...strategy.Strategy,
+) strategy.Strategy {
+	return &impl{
+		strategies: strategies,
+	}
+}
+
+func (im *impl) Acquire(context ctx.CTX, key string, n int) (strategy.Decision, error) {
+	var result strategy.Decision
+	for i, stra := range im.strategies {
+		decision, err := stra.Acquire(context, key, n)
+		if err != nil {
+			context.WithFields(logrus.Fields{
+				"err":   err,
+				"index": i,
+			}).Error("strategy.Acquire failed")
+			im.release(context, key, n, i)
+			return strategy.Decision{}, err
+		}
+
+		if !decision.Allowed {
+			im.release(context, key, n, i)
+			return decision, nil
+		}
+
+		// report the most restrictive one among all strategies
+		if i == 0 || decision.MoreRestrictive(result) {
+			result = decision
+		}
+	}
+
+	return result, nil
+}
+
+// Reserve reserves from all strategies, the permits are valid when they're valid in all strategies.
+// It fails with strategy.ErrReserveNotSupported if any strategy can't reserve.
+func (im *impl) Reserve(context ctx.CTX, key string, n int) (strategy.Decision, error) {
+	reservers := []strategy.Reserver{}
+	for _, stra := range im.strategies {
+		reserver, ok := stra.(strategy.Reserver)
+		if !ok {
+			return strategy.Decision{}, strategy.ErrReserveNotSupported
+		}
+		reservers = append(reservers, reserver)
+	}
+
+	var result strategy.Decision
+	for i, reserver := range reservers {
+		decision, err := reserver.Reserve(context, key, n)
+		if err != nil {
+			context.WithFields(logrus.Fields{
+				"err":   err,
+				"index": i,
+			}).Error("strategy.Reserve failed")
+			im.release(context, key, n, i)
+			return strategy.Decision{}, err
+		}
+
+		if !decision.Allowed {
+			im.release(context, key, n, i)
+			return decision, nil
+		}
+
+		// report the one valid at last
+		if i == 0 || decision.RetryAfter > result.RetryAfter {
+			result = decision
+		}
+	}
+
+	return result, nil
+}
+
+func (im *impl) Release(context ctx.CTX, key string, n int) error {
+	for i, stra := range im.strategies {
+		if err := stra.Release(context, key, n); err != nil {
+			context.WithFields(logrus.Fields{
+				"err":   err,
+				"index": i,
+			}).Error("strategy.Release failed")
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (im *impl) Peek(context ctx.CTX, key string) (strategy.Decision, error) {
+	var result strategy.Decision
+	for i, stra := range im.strategies {
+		decision, err := stra.Peek(context, key)
+		if err != nil {
+			context.WithFields(logrus.Fields{
+				"err":   err,
+				"index": i,
+			}).Error("strategy.Peek failed")
+			return strategy.Decision{}, err
+		}
+
+		if i == 0 || decision.MoreRestrictive(result) {
+			result = decision
+		}
+	}
+
+	return result, nil
+}
+
+func (im *impl) Reset(context ctx.CTX, key string) error {
+	for i, stra := range im.strategies {
+		if err := stra.Reset(context, key); err != nil {
+			context.WithFields(logrus.Fields{
+				"err":   err,
+				"index": i,
+			}).Error("strategy.Reset failed")
+			return err
+		}
+	}
+
+	return nil
+}
+
+// release gives back n permits granted by the first count strategies
+func (im *impl) release(context ctx.CTX, key string, n, count int) {
+	for i := 0; i < count; i++ {
+		if err := im.strategies[i].Release(context, key, n); err != nil {
+			context.WithFields(logrus.Fields{
+				"err":   err,
+				"index": i,
+			}).Error("strategy.Release failed")
+		}
+	}
+}
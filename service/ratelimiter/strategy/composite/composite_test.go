@@ -0,0 +1,198 @@
+package composite
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/chihkaiyu/ratelimiter/base/ctx"
+	"github.com/chihkaiyu/ratelimiter/service/ratelimiter/strategy"
+)
+
+var (
+	mockCTX = ctx.Background()
+	mockErr = fmt.Errorf("mock error")
+)
+
+type mockStrategy struct {
+	mock.Mock
+}
+
+func (m *mockStrategy) Acquire(context ctx.CTX, key string, n int) (strategy.Decision, error) {
+	args := m.Called(context, key, n)
+	return args.Get(0).(strategy.Decision), args.Error(1)
+}
+
+func (m *mockStrategy) Release(context ctx.CTX, key string, n int) error {
+	args := m.Called(context, key, n)
+	return args.Error(0)
+}
+
+func (m *mockStrategy) Peek(context ctx.CTX, key string) (strategy.Decision, error) {
+	args := m.Called(context, key)
+	return args.Get(0).(strategy.Decision), args.Error(1)
+}
+
+func (m *mockStrategy) Reset(context ctx.CTX, key string) error {
+	args := m.Called(context, key)
+	return args.Error(0)
+}
+
+type mockReserver struct {
+	mockStrategy
+}
+
+func (m *mockReserver) Reserve(context ctx.CTX, key string, n int) (strategy.Decision, error) {
+	args := m.Called(context, key, n)
+	return args.Get(0).(strategy.Decision), args.Error(1)
+}
+
+type compositeSuite struct {
+	suite.Suite
+	composite *impl
+	burst     *mockStrategy
+	sustained *mockStrategy
+}
+
+func TestCompositeSuite(t *testing.T) {
+	suite.Run(t, new(compositeSuite))
+}
+
+func (s *compositeSuite) SetupTest() {
+	s.burst = new(mockStrategy)
+	s.sustained = new(mockStrategy)
+	s.composite = NewComposite(s.burst, s.sustained).(*impl)
+}
+
+func (s *compositeSuite) TearDownTest() {
+	s.burst.AssertExpectations(s.T())
+	s.sustained.AssertExpectations(s.T())
+}
+
+func (s *compositeSuite) TestAcquire() {
+	key := "localhost"
+	burstAllowed := strategy.Decision{Allowed: true, Count: 1, Limit: 10, Remaining: 9}
+	sustainedAllowed := strategy.Decision{Allowed: true, Count: 998, Limit: 1000, Remaining: 2}
+	burstDenied := strategy.Decision{Allowed: false, Count: 11, Limit: 10, RetryAfter: time.Second}
+	sustainedDenied := strategy.Decision{Allowed: false, Count: 1001, Limit: 1000, RetryAfter: time.Hour}
+
+	tests := []struct {
+		Desc      string
+		SetupTest func()
+		Exp       strategy.Decision
+		ExpErr    error
+	}{
+		{
+			Desc: "all allowed returns the least remaining",
+			SetupTest: func() {
+				s.burst.On("Acquire", mockCTX, key, 2).Return(burstAllowed, nil).Once()
+				s.sustained.On("Acquire", mockCTX, key, 2).Return(sustainedAllowed, nil).Once()
+			},
+			Exp: sustainedAllowed,
+		},
+		{
+			Desc: "first denied doesn't acquire the others",
+			SetupTest: func() {
+				s.burst.On("Acquire", mockCTX, key, 2).Return(burstDenied, nil).Once()
+			},
+			Exp: burstDenied,
+		},
+		{
+			Desc: "second denied releases the first",
+			SetupTest: func() {
+				s.burst.On("Acquire", mockCTX, key, 2).Return(burstAllowed, nil).Once()
+				s.sustained.On("Acquire", mockCTX, key, 2).Return(sustainedDenied, nil).Once()
+				s.burst.On("Release", mockCTX, key, 2).Return(nil).Once()
+			},
+			Exp: sustainedDenied,
+		},
+		{
+			Desc: "second failed releases the first",
+			SetupTest: func() {
+				s.burst.On("Acquire", mockCTX, key, 2).Return(burstAllowed, nil).Once()
+				s.sustained.On("Acquire", mockCTX, key, 2).Return(strategy.Decision{}, mockErr).Once()
+				s.burst.On("Release", mockCTX, key, 2).Return(nil).Once()
+			},
+			ExpErr: mockErr,
+		},
+	}
+
+	for _, test := range tests {
+		s.SetupTest()
+		test.SetupTest()
+
+		act, err := s.composite.Acquire(mockCTX, key, 2)
+		if test.ExpErr != nil {
+			s.EqualError(err, test.ExpErr.Error(), test.Desc)
+		} else {
+			s.NoError(err, test.Desc)
+			s.Equal(test.Exp, act, test.Desc)
+		}
+
+		s.TearDownTest()
+	}
+}
+
+func (s *compositeSuite) TestReserve() {
+	key := "localhost"
+	burst := new(mockReserver)
+	sustained := new(mockReserver)
+	composite := NewComposite(burst, sustained).(*impl)
+
+	burstReserved := strategy.Decision{Allowed: true, Count: 12, Limit: 10, RetryAfter: 2 * time.Second}
+	sustainedReserved := strategy.Decision{Allowed: true, Count: 100, Limit: 1000, Remaining: 900}
+	burst.On("Reserve", mockCTX, key, 2).Return(burstReserved, nil).Once()
+	sustained.On("Reserve", mockCTX, key, 2).Return(sustainedReserved, nil).Once()
+
+	// valid when valid in all strategies
+	act, err := composite.Reserve(mockCTX, key, 2)
+	s.Require().NoError(err)
+	s.Equal(burstReserved, act)
+
+	// the first is released if the second is denied
+	sustainedDenied := strategy.Decision{Allowed: false, Count: 1000, Limit: 1000, RetryAfter: time.Hour}
+	burst.On("Reserve", mockCTX, key, 2).Return(burstReserved, nil).Once()
+	sustained.On("Reserve", mockCTX, key, 2).Return(sustainedDenied, nil).Once()
+	burst.On("Release", mockCTX, key, 2).Return(nil).Once()
+	act, err = composite.Reserve(mockCTX, key, 2)
+	s.Require().NoError(err)
+	s.Equal(sustainedDenied, act)
+
+	burst.AssertExpectations(s.T())
+	sustained.AssertExpectations(s.T())
+
+	// nothing is reserved if any strategy can't reserve
+	_, err = NewComposite(burst, s.sustained).(*impl).Reserve(mockCTX, key, 2)
+	s.Equal(strategy.ErrReserveNotSupported, err)
+}
+
+func (s *compositeSuite) TestRelease() {
+	key := "localhost"
+	s.burst.On("Release", mockCTX, key, 2).Return(nil).Once()
+	s.sustained.On("Release", mockCTX, key, 2).Return(nil).Once()
+
+	s.NoError(s.composite.Release(mockCTX, key, 2))
+}
+
+func (s *compositeSuite) TestPeek() {
+	key := "localhost"
+	burst := strategy.Decision{Allowed: true, Count: 1, Limit: 10, Remaining: 9}
+	sustained := strategy.Decision{Allowed: false, Count: 1000, Limit: 1000, RetryAfter: time.Hour}
+	s.burst.On("Peek", mockCTX, key).Return(burst, nil).Once()
+	s.sustained.On("Peek", mockCTX, key).Return(sustained, nil).Once()
+
+	act, err := s.composite.Peek(mockCTX, key)
+	s.NoError(err)
+	s.Equal(sustained, act)
+}
+
+func (s *compositeSuite) TestReset() {
+	key := "localhost"
+	s.burst.On("Reset", mockCTX, key).Return(nil).Once()
+	s.sustained.On("Reset", mockCTX, key).Return(nil).Once()
+
+	s.NoError(s.composite.Reset(mockCTX, key))
+}
@@ -3,8 +3,10 @@ package fixedwindow
 import (
 	"flag"
 	"fmt"
+	"strconv"
 	"time"
 
+	goredis "github.com/go-redis/redis/v8"
 	"github.com/sirupsen/logrus"
 
 	"github.com/chihkaiyu/ratelimiter/base/ctx"
@@ -12,6 +14,42 @@ import (
 	"github.com/chihkaiyu/ratelimiter/service/redis"
 )
 
+const (
+	defaultPrefix = "fixed_window"
+
+	// ARGV: n
+	// decrease the counter only when the window still exists,
+	// or we would create a negative counter for a new window
+	releaseScript = `
+if redis.call('EXISTS', KEYS[1]) == 1 then
+	return redis.call('DECRBY', KEYS[1], ARGV[1])
+end
+return 0
+`
+
+	// KEYS: windows of the links
+	// ARGV: n, then limit and size of every link
+	// the windows are counted only when all of them allow the request,
+	// the denied window counts the request like Acquire
+	chainScript = `
+local n = tonumber(ARGV[1])
+local counts = {}
+for i, key in ipairs(KEYS) do
+	counts[i] = tonumber(redis.call('GET', key) or '0') + n
+	if counts[i] > tonumber(ARGV[2 * i]) then
+		redis.call('INCRBY', key, n)
+		redis.call('EXPIRE', key, ARGV[2 * i + 1])
+		return counts
+	end
+end
+for i, key in ipairs(KEYS) do
+	redis.call('INCRBY', key, n)
+	redis.call('EXPIRE', key, ARGV[2 * i + 1])
+end
+return counts
+`
+)
+
 var (
 	timeNow = time.Now
 
@@ -20,32 +58,73 @@ var (
 )
 
 type impl struct {
-	redis  redis.Service
-	size   int
-	litmit int
+	redis         redis.Service
+	releaseScript *goredis.Script
+	chainScript   *goredis.Script
+	prefix        string
+	size          int
+	litmit        int
 }
 
+// Option is an alias for functional argument in NewFixedWindow
+type Option func(*impl)
+
 func NewFixedWindow(
 	redis redis.Service,
+	opts ...Option,
 ) strategy.Strategy {
-	return &impl{
-		redis:  redis,
-		size:   *fixedWindowSize,
-		litmit: *fixedWindowLimit,
+	im := &impl{
+		redis:         redis,
+		releaseScript: goredis.NewScript(releaseScript),
+		chainScript:   goredis.NewScript(chainScript),
+		prefix:        defaultPrefix,
+		size:          *fixedWindowSize,
+		litmit:        *fixedWindowLimit,
+	}
+	for _, opt := range opts {
+		opt(im)
 	}
+
+	return im
 }
 
-func (im *impl) Acquire(context ctx.CTX, key string) (bool, int, error) {
-	now := timeNow()
+// WithSize sets the window size (in second) instead of the flag
+func WithSize(size int) Option {
+	return func(im *impl) {
+		im.size = size
+	}
+}
+
+// WithLimit sets the window limit instead of the flag
+func WithLimit(limit int) Option {
+	return func(im *impl) {
+		im.litmit = limit
+	}
+}
+
+// WithPrefix sets the prefix of redis key, strategies sharing the same redis
+// must have different prefixes
+func WithPrefix(prefix string) Option {
+	return func(im *impl) {
+		im.prefix = prefix
+	}
+}
+
+func (im *impl) redisKey(key string, now time.Time) string {
 	window := now.Unix() / int64(im.size)
-	redisKey := fmt.Sprintf("fixed_window:%s:%d", key, window)
-	value, err := im.redis.Incr(context, redisKey)
+	return fmt.Sprintf("%s:%s:%d", im.prefix, key, window)
+}
+
+func (im *impl) Acquire(context ctx.CTX, key string, n int) (strategy.Decision, error) {
+	now := timeNow()
+	redisKey := im.redisKey(key, now)
+	value, err := im.redis.IncrBy(context, redisKey, int64(n))
 	if err != nil && err != redis.Nil {
 		context.WithFields(logrus.Fields{
 			"err": err,
 			"key": redisKey,
-		}).Error("redis.Incr failed")
-		return false, 0, err
+		}).Error("redis.IncrBy failed")
+		return strategy.Decision{}, err
 	}
 	defer func() {
 		// we don't need the window after changing to another window
@@ -57,9 +136,118 @@ func (im *impl) Acquire(context ctx.CTX, key string) (bool, int, error) {
 		}
 	}()
 
-	if value > int64(im.litmit) {
-		return false, int(value), nil
+	return im.decision(now, int(value)), nil
+}
+
+// decision returns the decision of the window with given count of requests
+func (im *impl) decision(now time.Time, count int) strategy.Decision {
+	windowEnd := time.Unix((now.Unix()/int64(im.size)+1)*int64(im.size), 0)
+	decision := strategy.Decision{
+		Allowed:    true,
+		Count:      count,
+		Limit:      im.litmit,
+		Remaining:  im.litmit - count,
+		ResetAfter: windowEnd.Sub(now),
+	}
+	if count > im.litmit {
+		decision.Allowed = false
+		decision.Remaining = 0
+		decision.RetryAfter = decision.ResetAfter
+	}
+
+	return decision
+}
+
+// AcquireChain acquires n permits from the windows of the links in a single script
+func (im *impl) AcquireChain(context ctx.CTX, links []strategy.Link, n int) ([]strategy.Decision, error) {
+	now := timeNow()
+	strategies := []*impl{}
+	keys := []string{}
+	args := []interface{}{n}
+	for _, link := range links {
+		s, ok := link.Strategy.(*impl)
+		if !ok {
+			context.WithField("key", link.Key).Error("strategy of link isn't fixed window")
+			return nil, strategy.ErrChainMismatch
+		}
+		strategies = append(strategies, s)
+		keys = append(keys, s.redisKey(link.Key, now))
+		args = append(args, s.litmit, s.size)
+	}
+
+	value, err := im.redis.RunScript(context, im.chainScript, keys, args...)
+	if err != nil {
+		context.WithFields(logrus.Fields{
+			"err":  err,
+			"keys": keys,
+		}).Error("redis.RunScript failed")
+		return nil, err
+	}
+
+	decisions := []strategy.Decision{}
+	for i, count := range value.([]interface{}) {
+		decisions = append(decisions, strategies[i].decision(now, int(count.(int64))))
+	}
+
+	return decisions, nil
+}
+
+func (im *impl) Release(context ctx.CTX, key string, n int) error {
+	redisKey := im.redisKey(key, timeNow())
+	if _, err := im.redis.RunScript(context, im.releaseScript, []string{redisKey}, n); err != nil {
+		context.WithFields(logrus.Fields{
+			"err": err,
+			"key": redisKey,
+		}).Error("redis.RunScript failed")
+		return err
+	}
+
+	return nil
+}
+
+func (im *impl) Peek(context ctx.CTX, key string) (strategy.Decision, error) {
+	now := timeNow()
+	redisKey := im.redisKey(key, now)
+	value, err := im.redis.Get(context, redisKey)
+	if err == redis.Nil {
+		return im.decision(now, 0), nil
+	}
+	if err != nil {
+		context.WithFields(logrus.Fields{
+			"err": err,
+			"key": redisKey,
+		}).Error("redis.Get failed")
+		return strategy.Decision{}, err
+	}
+
+	count, err := strconv.Atoi(string(value))
+	if err != nil {
+		context.WithFields(logrus.Fields{
+			"err":   err,
+			"value": string(value),
+		}).Error("strconv.Atoi failed")
+		return strategy.Decision{}, err
+	}
+
+	// the next request would be the count+1 one
+	decision := im.decision(now, count+1)
+	decision.Count = count
+	if decision.Allowed {
+		decision.Remaining = im.litmit - count
+	}
+
+	return decision, nil
+}
+
+func (im *impl) Reset(context ctx.CTX, key string) error {
+	redisKey := im.redisKey(key, timeNow())
+	if err := im.redis.Del(context, redisKey); err != nil {
+		context.WithFields(logrus.Fields{
+			"err": err,
+			"key": redisKey,
+		}).Error("redis.Del failed")
+		return err
 	}
 
-	return true, int(value), nil
+	return nil
 }
@@ -9,6 +9,7 @@ import (
 
 	"github.com/chihkaiyu/ratelimiter/base/ctx"
 	"github.com/chihkaiyu/ratelimiter/base/docker"
+	"github.com/chihkaiyu/ratelimiter/service/ratelimiter/strategy"
 	"github.com/chihkaiyu/ratelimiter/service/redis"
 )
 
@@ -26,6 +27,11 @@ func (m *mockFuncs) timeNow() time.Time {
 	return args.Get(0).(time.Time)
 }
 
+// otherStrategy is a strategy of another kind
+type otherStrategy struct {
+	strategy.Strategy
+}
+
 type fixedWindowSuite struct {
 	suite.Suite
 	redisPort   string
@@ -49,10 +55,11 @@ func (s *fixedWindowSuite) TearDownSuite() {
 }
 
 func (s *fixedWindowSuite) SetupTest() {
-	redis := redis.NewRedis("localhost:"+s.redisPort, "")
-	s.fixedWindow = NewFixedWindow(redis).(*impl)
 	*fixedWindowSize = 10
 	*fixedWindowLimit = 5
+	redis, err := redis.NewRedis("localhost:"+s.redisPort, "")
+	s.Require().NoError(err)
+	s.fixedWindow = NewFixedWindow(redis).(*impl)
 
 	// mock functions
 	s.mockFuncs = new(mockFuncs)
@@ -129,17 +136,159 @@ func (s *fixedWindowSuite) TestAccquire() {
 		s.Len(test.Exp, len(test.AccquireTime), test.Desc)
 		s.Len(test.ExpCount, len(test.AccquireTime), test.Desc)
 
-		var act bool
-		var actCount int
-		var err error
 		for i, t := range test.AccquireTime {
 			s.mockFuncs.On("timeNow").Return(t).Once()
-			act, actCount, err = s.fixedWindow.Acquire(mockCTX, key)
+			act, err := s.fixedWindow.Acquire(mockCTX, key, 1)
 			s.NoError(err, test.Desc)
-			s.Equal(test.Exp[i], act, test.Desc)
-			s.Equal(test.ExpCount[i], actCount, test.Desc)
+			s.Equal(test.Exp[i], act.Allowed, test.Desc)
+			s.Equal(test.ExpCount[i], act.Count, test.Desc)
 		}
 
 		s.TearDownTest()
 	}
 }
+
+func (s *fixedWindowSuite) TestRelease() {
+	key := "localhost"
+	for i := 0; i < 5; i++ {
+		s.mockFuncs.On("timeNow").Return(mockNow).Once()
+		act, err := s.fixedWindow.Acquire(mockCTX, key, 1)
+		s.NoError(err)
+		s.True(act.Allowed)
+	}
+
+	s.mockFuncs.On("timeNow").Return(mockNow.Add(1 * time.Second)).Once()
+	s.NoError(s.fixedWindow.Release(mockCTX, key, 1))
+
+	s.mockFuncs.On("timeNow").Return(mockNow.Add(2 * time.Second)).Once()
+	act, err := s.fixedWindow.Acquire(mockCTX, key, 1)
+	s.NoError(err)
+	s.True(act.Allowed)
+	s.Equal(5, act.Count)
+	s.Equal(0, act.Remaining)
+
+	s.mockFuncs.On("timeNow").Return(mockNow.Add(3 * time.Second)).Once()
+	act, err = s.fixedWindow.Acquire(mockCTX, key, 1)
+	s.NoError(err)
+	s.False(act.Allowed)
+	s.Equal(7*time.Second, act.RetryAfter)
+
+	// releasing a window which doesn't exist shouldn't create a negative counter
+	s.mockFuncs.On("timeNow").Return(mockNow.Add(20 * time.Second)).Once()
+	s.NoError(s.fixedWindow.Release(mockCTX, key, 1))
+	s.mockFuncs.On("timeNow").Return(mockNow.Add(21 * time.Second)).Once()
+	act, err = s.fixedWindow.Acquire(mockCTX, key, 1)
+	s.NoError(err)
+	s.Equal(1, act.Count)
+}
+
+func (s *fixedWindowSuite) TestAcquireN() {
+	key := "localhost"
+	s.mockFuncs.On("timeNow").Return(mockNow).Once()
+	act, err := s.fixedWindow.Acquire(mockCTX, key, 3)
+	s.NoError(err)
+	s.True(act.Allowed)
+	s.Equal(3, act.Count)
+	s.Equal(2, act.Remaining)
+
+	s.mockFuncs.On("timeNow").Return(mockNow).Once()
+	act, err = s.fixedWindow.Acquire(mockCTX, key, 3)
+	s.NoError(err)
+	s.False(act.Allowed)
+	s.Equal(6, act.Count)
+
+	s.mockFuncs.On("timeNow").Return(mockNow).Once()
+	s.NoError(s.fixedWindow.Release(mockCTX, key, 3))
+	s.mockFuncs.On("timeNow").Return(mockNow).Once()
+	act, err = s.fixedWindow.Acquire(mockCTX, key, 2)
+	s.NoError(err)
+	s.True(act.Allowed)
+	s.Equal(5, act.Count)
+	s.Equal(0, act.Remaining)
+}
+
+func (s *fixedWindowSuite) TestAcquireChain() {
+	// the user window allows 5 per 10 seconds and the global one 7 per 60 seconds
+	global := NewFixedWindow(s.fixedWindow.redis, WithSize(60), WithLimit(7), WithPrefix("global")).(*impl)
+	links := []strategy.Link{{Strategy: s.fixedWindow, Key: "bob"}, {Strategy: global, Key: "global"}}
+
+	s.mockFuncs.On("timeNow").Return(mockNow).Once()
+	act, err := s.fixedWindow.AcquireChain(mockCTX, links, 4)
+	s.NoError(err)
+	s.Require().Len(act, 2)
+	s.True(act[0].Allowed)
+	s.Equal(1, act[0].Remaining)
+	s.True(act[1].Allowed)
+	s.Equal(3, act[1].Remaining)
+
+	// denied by the user window, the global one isn't evaluated
+	s.mockFuncs.On("timeNow").Return(mockNow).Once()
+	act, err = s.fixedWindow.AcquireChain(mockCTX, links, 2)
+	s.NoError(err)
+	s.Require().Len(act, 1)
+	s.False(act[0].Allowed)
+	s.Equal(6, act[0].Count)
+
+	// denied by the global window, the user window of alice isn't counted
+	links[0].Key = "alice"
+	s.mockFuncs.On("timeNow").Return(mockNow).Once()
+	act, err = s.fixedWindow.AcquireChain(mockCTX, links, 4)
+	s.NoError(err)
+	s.Require().Len(act, 2)
+	s.True(act[0].Allowed)
+	s.False(act[1].Allowed)
+	s.Equal(8, act[1].Count)
+	s.mockFuncs.On("timeNow").Return(mockNow).Once()
+	peek, err := s.fixedWindow.Peek(mockCTX, "alice")
+	s.NoError(err)
+	s.Equal(0, peek.Count)
+
+	s.mockFuncs.On("timeNow").Return(mockNow).Once()
+	_, err = s.fixedWindow.AcquireChain(mockCTX, []strategy.Link{{Strategy: otherStrategy{}, Key: "bob"}}, 1)
+	s.Equal(strategy.ErrChainMismatch, err)
+}
+
+func (s *fixedWindowSuite) TestPeek() {
+	key := "localhost"
+	s.mockFuncs.On("timeNow").Return(mockNow).Once()
+	act, err := s.fixedWindow.Peek(mockCTX, key)
+	s.NoError(err)
+	s.True(act.Allowed)
+	s.Equal(0, act.Count)
+	s.Equal(5, act.Remaining)
+
+	for i := 0; i < 5; i++ {
+		s.mockFuncs.On("timeNow").Return(mockNow).Once()
+		_, err := s.fixedWindow.Acquire(mockCTX, key, 1)
+		s.NoError(err)
+	}
+
+	// peeking doesn't take a permit
+	for i := 0; i < 2; i++ {
+		s.mockFuncs.On("timeNow").Return(mockNow.Add(2 * time.Second)).Once()
+		act, err = s.fixedWindow.Peek(mockCTX, key)
+		s.NoError(err)
+		s.False(act.Allowed)
+		s.Equal(5, act.Count)
+		s.Equal(0, act.Remaining)
+		s.Equal(8*time.Second, act.RetryAfter)
+	}
+}
+
+func (s *fixedWindowSuite) TestReset() {
+	key := "localhost"
+	for i := 0; i < 6; i++ {
+		s.mockFuncs.On("timeNow").Return(mockNow).Once()
+		_, err := s.fixedWindow.Acquire(mockCTX, key, 1)
+		s.NoError(err)
+	}
+
+	s.mockFuncs.On("timeNow").Return(mockNow).Once()
+	s.NoError(s.fixedWindow.Reset(mockCTX, key))
+
+	s.mockFuncs.On("timeNow").Return(mockNow).Once()
+	act, err := s.fixedWindow.Acquire(mockCTX, key, 1)
+	s.NoError(err)
+	s.True(act.Allowed)
+	s.Equal(1, act.Count)
+}
@@ -4,8 +4,10 @@ import (
 	"flag"
 	"fmt"
 	"strconv"
+	"strings"
 	"time"
 
+	goredis "github.com/go-redis/redis/v8"
 	"github.com/sirupsen/logrus"
 
 	"github.com/chihkaiyu/ratelimiter/base/ctx"
@@ -13,6 +15,42 @@ import (
 	"github.com/chihkaiyu/ratelimiter/service/redis"
 )
 
+const (
+	defaultPrefix = "sliding_window"
+
+	// KEYS: windows of the links
+	// ARGV: now, n, then min, limit and size of every link
+	// same as Acquire for every window, but the records are added only when all windows allow
+	// the request. The denied window returns the record which slides out of it last before
+	// accepting n more requests.
+	chainScript = `
+local now = ARGV[1]
+local n = tonumber(ARGV[2])
+local result = {}
+for i, key in ipairs(KEYS) do
+	local min = ARGV[3 * i]
+	local limit = tonumber(ARGV[3 * i + 1])
+	local count = redis.call('ZCOUNT', key, min, now)
+	if count + n > limit then
+		local members = redis.call('ZRANGEBYSCORE', key, min, now, 'LIMIT', count + n - limit - 1, 1)
+		result[i] = {count, members[1] or ''}
+		return result
+	end
+	result[i] = {count, ''}
+end
+
+for i, key in ipairs(KEYS) do
+	redis.call('ZADD', key, now, now)
+	for j = 1, n - 1 do
+		redis.call('ZADD', key, now, now .. ':' .. j)
+	end
+	redis.call('EXPIRE', key, ARGV[3 * i + 2])
+	redis.call('ZREMRANGEBYSCORE', key, '-inf', ARGV[3 * i])
+end
+return result
+`
+)
+
 var (
 	timeNow = time.Now
 
@@ -21,35 +59,74 @@ var (
 )
 
 type impl struct {
-	redis redis.Service
-	size  int
-	limit int
+	redis       redis.Service
+	chainScript *goredis.Script
+	prefix      string
+	size        int
+	limit       int
 }
 
+// Option is an alias for functional argument in NewSlidingWindow
+type Option func(*impl)
+
 func NewSlidingWindow(
 	redis redis.Service,
+	opts ...Option,
 ) strategy.Strategy {
-	return &impl{
-		redis: redis,
-		size:  *slidingWindowSize,
-		limit: *slidingWindowLimit,
+	im := &impl{
+		redis:       redis,
+		chainScript: goredis.NewScript(chainScript),
+		prefix:      defaultPrefix,
+		size:        *slidingWindowSize,
+		limit:       *slidingWindowLimit,
+	}
+	for _, opt := range opts {
+		opt(im)
+	}
+
+	return im
+}
+
+// WithSize sets the window size (in second) instead of the flag
+func WithSize(size int) Option {
+	return func(im *impl) {
+		im.size = size
+	}
+}
+
+// WithLimit sets the window limit instead of the flag
+func WithLimit(limit int) Option {
+	return func(im *impl) {
+		im.limit = limit
+	}
+}
+
+// WithPrefix sets the prefix of redis key, strategies sharing the same redis
+// must have different prefixes
+func WithPrefix(prefix string) Option {
+	return func(im *impl) {
+		im.prefix = prefix
 	}
 }
 
-func (im *impl) Acquire(context ctx.CTX, key string) (bool, int, error) {
+func (im *impl) redisKey(key string) string {
+	return fmt.Sprintf("%s:%s", im.prefix, key)
+}
+
+func (im *impl) Acquire(context ctx.CTX, key string, n int) (strategy.Decision, error) {
 	now := timeNow()
 	from := now.Add(time.Duration(-im.size) * time.Second)
 	min := strconv.FormatInt(from.UnixNano(), 10)
 	max := strconv.FormatInt(now.UnixNano(), 10)
 
-	redisKey := fmt.Sprintf("sliding_window:%s", key)
+	redisKey := im.redisKey(key)
 	count, err := im.redis.ZCount(context, redisKey, min, max)
 	if err != nil && err != redis.Nil {
 		context.WithFields(logrus.Fields{
 			"err": err,
 			"key": redisKey,
 		}).Error("redis.ZCount failed")
-		return false, 0, err
+		return strategy.Decision{}, err
 	}
 	defer func() {
 		// we don't need the window if request doesn't appear in size seconds
@@ -71,17 +148,202 @@ func (im *impl) Acquire(context ctx.CTX, key string) (bool, int, error) {
 		}
 	}()
 
-	if count >= im.limit {
-		return false, count, nil
+	if count+n > im.limit {
+		retryAfter, err := im.retryAfter(context, redisKey, now, min, max, count, n)
+		if err != nil {
+			return strategy.Decision{}, err
+		}
+
+		return strategy.Decision{
+			Allowed:    false,
+			Count:      count,
+			Limit:      im.limit,
+			Remaining:  0,
+			RetryAfter: retryAfter,
+			ResetAfter: time.Duration(im.size) * time.Second,
+		}, nil
 	}
 
-	if err := im.redis.ZAdd(context, redisKey, int(now.UnixNano()), max); err != nil {
+	if err := im.redis.ZAdd(context, redisKey, int(now.UnixNano()), members(max, n)...); err != nil {
 		context.WithFields(logrus.Fields{
 			"err": err,
 			"key": redisKey,
 		}).Error("redis.ZAdd failed")
-		return false, count, err
+		return strategy.Decision{}, err
+	}
+
+	return strategy.Decision{
+		Allowed:    true,
+		Count:      count + n,
+		Limit:      im.limit,
+		Remaining:  im.limit - count - n,
+		ResetAfter: time.Duration(im.size) * time.Second,
+	}, nil
+}
+
+// members returns the records of n requests at the time, the first one is the time itself
+// and the others are suffixed with their index to be unique
+func members(now string, n int) []string {
+	result := []string{now}
+	for i := 1; i < n; i++ {
+		result = append(result, fmt.Sprintf("%s:%d", now, i))
+	}
+
+	return result
+}
+
+// retryAfter returns the time until enough records slide out of the window
+// for accepting n more requests
+func (im *impl) retryAfter(context ctx.CTX, redisKey string, now time.Time, min, max string, count, n int) (time.Duration, error) {
+	members, err := im.redis.ZRangeByScore(context, redisKey, min, max, count+n-im.limit-1, 1)
+	if err != nil {
+		context.WithFields(logrus.Fields{
+			"err": err,
+			"key": redisKey,
+		}).Error("redis.ZRangeByScore failed")
+		return 0, err
+	}
+	if len(members) == 0 {
+		return 0, nil
+	}
+
+	return im.retryAfterOf(context, members[0], now)
+}
+
+// retryAfterOf returns the time until the record slides out of the window
+func (im *impl) retryAfterOf(context ctx.CTX, member string, now time.Time) (time.Duration, error) {
+	nano, err := strconv.ParseInt(strings.SplitN(member, ":", 2)[0], 10, 64)
+	if err != nil {
+		context.WithFields(logrus.Fields{
+			"err":    err,
+			"member": member,
+		}).Error("strconv.ParseInt failed")
+		return 0, err
+	}
+
+	return time.Unix(0, nano).Add(time.Duration(im.size) * time.Second).Sub(now), nil
+}
+
+// AcquireChain acquires n permits from the windows of the links in a single script
+func (im *impl) AcquireChain(context ctx.CTX, links []strategy.Link, n int) ([]strategy.Decision, error) {
+	now := timeNow()
+	max := strconv.FormatInt(now.UnixNano(), 10)
+	strategies := []*impl{}
+	keys := []string{}
+	args := []interface{}{max, n}
+	for _, link := range links {
+		s, ok := link.Strategy.(*impl)
+		if !ok {
+			context.WithField("key", link.Key).Error("strategy of link isn't sliding window")
+			return nil, strategy.ErrChainMismatch
+		}
+		from := now.Add(time.Duration(-s.size) * time.Second)
+		strategies = append(strategies, s)
+		keys = append(keys, s.redisKey(link.Key))
+		args = append(args, strconv.FormatInt(from.UnixNano(), 10), s.limit, s.size)
+	}
+
+	value, err := im.redis.RunScript(context, im.chainScript, keys, args...)
+	if err != nil {
+		context.WithFields(logrus.Fields{
+			"err":  err,
+			"keys": keys,
+		}).Error("redis.RunScript failed")
+		return nil, err
+	}
+
+	decisions := []strategy.Decision{}
+	for i, v := range value.([]interface{}) {
+		result := v.([]interface{})
+		s, count, member := strategies[i], int(result[0].(int64)), result[1].(string)
+		if count+n <= s.limit {
+			decisions = append(decisions, strategy.Decision{
+				Allowed:    true,
+				Count:      count + n,
+				Limit:      s.limit,
+				Remaining:  s.limit - count - n,
+				ResetAfter: time.Duration(s.size) * time.Second,
+			})
+			continue
+		}
+
+		decision := strategy.Decision{
+			Allowed:    false,
+			Count:      count,
+			Limit:      s.limit,
+			Remaining:  0,
+			ResetAfter: time.Duration(s.size) * time.Second,
+		}
+		if member != "" {
+			if decision.RetryAfter, err = s.retryAfterOf(context, member, now); err != nil {
+				return nil, err
+			}
+		}
+		decisions = append(decisions, decision)
+	}
+
+	return decisions, nil
+}
+
+func (im *impl) Release(context ctx.CTX, key string, n int) error {
+	// records are only added when the request is accepted,
+	// remove the latest n ones to give the permits back
+	redisKey := im.redisKey(key)
+	if err := im.redis.ZRemRangeByRank(context, redisKey, -n, -1); err != nil {
+		context.WithFields(logrus.Fields{
+			"err": err,
+			"key": redisKey,
+		}).Error("redis.ZRemRangeByRank failed")
+		return err
+	}
+
+	return nil
+}
+
+func (im *impl) Peek(context ctx.CTX, key string) (strategy.Decision, error) {
+	now := timeNow()
+	from := now.Add(time.Duration(-im.size) * time.Second)
+	min := strconv.FormatInt(from.UnixNano(), 10)
+	max := strconv.FormatInt(now.UnixNano(), 10)
+
+	redisKey := im.redisKey(key)
+	count, err := im.redis.ZCount(context, redisKey, min, max)
+	if err != nil && err != redis.Nil {
+		context.WithFields(logrus.Fields{
+			"err": err,
+			"key": redisKey,
+		}).Error("redis.ZCount failed")
+		return strategy.Decision{}, err
+	}
+
+	decision := strategy.Decision{
+		Allowed:    count < im.limit,
+		Count:      count,
+		Limit:      im.limit,
+		ResetAfter: time.Duration(im.size) * time.Second,
+	}
+	if decision.Allowed {
+		decision.Remaining = im.limit - count
+		return decision, nil
+	}
+
+	decision.RetryAfter, err = im.retryAfter(context, redisKey, now, min, max, count, 1)
+	if err != nil {
+		return strategy.Decision{}, err
+	}
+
+	return decision, nil
+}
+
+func (im *impl) Reset(context ctx.CTX, key string) error {
+	redisKey := im.redisKey(key)
+	if err := im.redis.Del(context, redisKey); err != nil {
+		context.WithFields(logrus.Fields{
+			"err": err,
+			"key": redisKey,
+		}).Error("redis.Del failed")
+		return err
 	}
 
-	return true, count + 1, nil
+	return nil
 }
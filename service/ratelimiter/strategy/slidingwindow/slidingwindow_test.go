@@ -9,6 +9,7 @@ import (
 
 	"github.com/chihkaiyu/ratelimiter/base/ctx"
 	"github.com/chihkaiyu/ratelimiter/base/docker"
+	"github.com/chihkaiyu/ratelimiter/service/ratelimiter/strategy"
 	"github.com/chihkaiyu/ratelimiter/service/redis"
 )
 
@@ -26,6 +27,11 @@ func (m *mockFuncs) timeNow() time.Time {
 	return args.Get(0).(time.Time)
 }
 
+// otherStrategy is a strategy of another kind
+type otherStrategy struct {
+	strategy.Strategy
+}
+
 type slidingWindowSuite struct {
 	suite.Suite
 	redisPort     string
@@ -49,10 +55,11 @@ func (s *slidingWindowSuite) TearDownSuite() {
 }
 
 func (s *slidingWindowSuite) SetupTest() {
-	redis := redis.NewRedis("localhost:"+s.redisPort, "")
-	s.slidingWindow = NewSlidingWindow(redis).(*impl)
 	*slidingWindowSize = 10
 	*slidingWindowLimit = 5
+	redis, err := redis.NewRedis("localhost:"+s.redisPort, "")
+	s.Require().NoError(err)
+	s.slidingWindow = NewSlidingWindow(redis).(*impl)
 
 	// mock functions
 	s.mockFuncs = new(mockFuncs)
@@ -129,19 +136,158 @@ func (s *slidingWindowSuite) TestAccquire() {
 		s.Len(test.Exp, len(test.AccquireTime))
 		s.Len(test.ExpCount, len(test.AccquireTime))
 
-		var act bool
-		var actCount int
-		var err error
 		for i, t := range test.AccquireTime {
 			s.mockFuncs.On("timeNow").Return(t).Once()
-			act, actCount, err = s.slidingWindow.Acquire(mockCTX, key)
+			act, err := s.slidingWindow.Acquire(mockCTX, key, 1)
 			s.NoError(err, test.Desc)
-			s.Equal(test.Exp[i], act, test.Desc)
+			s.Equal(test.Exp[i], act.Allowed, test.Desc)
 
-			s.Equal(test.ExpCount[i], actCount, test.Desc)
+			s.Equal(test.ExpCount[i], act.Count, test.Desc)
 		}
 
 		s.TearDownTest()
 	}
 
 }
+
+func (s *slidingWindowSuite) TestRelease() {
+	key := "localhost"
+	for i := 0; i < 5; i++ {
+		s.mockFuncs.On("timeNow").Return(mockNow.Add(time.Duration(i) * time.Second)).Once()
+		act, err := s.slidingWindow.Acquire(mockCTX, key, 1)
+		s.NoError(err)
+		s.True(act.Allowed)
+	}
+
+	s.NoError(s.slidingWindow.Release(mockCTX, key, 1))
+
+	s.mockFuncs.On("timeNow").Return(mockNow.Add(5 * time.Second)).Once()
+	act, err := s.slidingWindow.Acquire(mockCTX, key, 1)
+	s.NoError(err)
+	s.True(act.Allowed)
+	s.Equal(5, act.Count)
+	s.Equal(0, act.Remaining)
+
+	// the first record slides out of the window after 10 seconds
+	s.mockFuncs.On("timeNow").Return(mockNow.Add(6 * time.Second)).Once()
+	act, err = s.slidingWindow.Acquire(mockCTX, key, 1)
+	s.NoError(err)
+	s.False(act.Allowed)
+	s.Equal(4*time.Second, act.RetryAfter)
+}
+
+func (s *slidingWindowSuite) TestAcquireN() {
+	key := "localhost"
+	s.mockFuncs.On("timeNow").Return(mockNow).Once()
+	act, err := s.slidingWindow.Acquire(mockCTX, key, 3)
+	s.NoError(err)
+	s.True(act.Allowed)
+	s.Equal(3, act.Count)
+	s.Equal(2, act.Remaining)
+
+	s.mockFuncs.On("timeNow").Return(mockNow.Add(2 * time.Second)).Once()
+	act, err = s.slidingWindow.Acquire(mockCTX, key, 2)
+	s.NoError(err)
+	s.True(act.Allowed)
+	s.Equal(5, act.Count)
+
+	// 2 more requests need the first 2 records sliding out of the window
+	s.mockFuncs.On("timeNow").Return(mockNow.Add(4 * time.Second)).Once()
+	act, err = s.slidingWindow.Acquire(mockCTX, key, 2)
+	s.NoError(err)
+	s.False(act.Allowed)
+	s.Equal(5, act.Count)
+	s.Equal(6*time.Second, act.RetryAfter)
+
+	s.NoError(s.slidingWindow.Release(mockCTX, key, 2))
+	s.mockFuncs.On("timeNow").Return(mockNow.Add(5 * time.Second)).Once()
+	act, err = s.slidingWindow.Acquire(mockCTX, key, 2)
+	s.NoError(err)
+	s.True(act.Allowed)
+	s.Equal(5, act.Count)
+}
+
+func (s *slidingWindowSuite) TestAcquireChain() {
+	// the user window allows 5 per 10 seconds and the global one 7 per 60 seconds
+	global := NewSlidingWindow(s.slidingWindow.redis, WithSize(60), WithLimit(7), WithPrefix("global")).(*impl)
+	links := []strategy.Link{{Strategy: s.slidingWindow, Key: "bob"}, {Strategy: global, Key: "global"}}
+
+	s.mockFuncs.On("timeNow").Return(mockNow).Once()
+	act, err := s.slidingWindow.AcquireChain(mockCTX, links, 4)
+	s.NoError(err)
+	s.Require().Len(act, 2)
+	s.True(act[0].Allowed)
+	s.Equal(1, act[0].Remaining)
+	s.True(act[1].Allowed)
+	s.Equal(3, act[1].Remaining)
+
+	// denied by the user window, the global one isn't evaluated
+	s.mockFuncs.On("timeNow").Return(mockNow.Add(2 * time.Second)).Once()
+	act, err = s.slidingWindow.AcquireChain(mockCTX, links, 2)
+	s.NoError(err)
+	s.Require().Len(act, 1)
+	s.False(act[0].Allowed)
+	s.Equal(4, act[0].Count)
+	s.Equal(8*time.Second, act[0].RetryAfter)
+
+	// denied by the global window, the user window of alice isn't counted
+	links[0].Key = "alice"
+	s.mockFuncs.On("timeNow").Return(mockNow.Add(2 * time.Second)).Once()
+	act, err = s.slidingWindow.AcquireChain(mockCTX, links, 4)
+	s.NoError(err)
+	s.Require().Len(act, 2)
+	s.True(act[0].Allowed)
+	s.False(act[1].Allowed)
+	s.Equal(58*time.Second, act[1].RetryAfter)
+	s.mockFuncs.On("timeNow").Return(mockNow.Add(2 * time.Second)).Once()
+	peek, err := s.slidingWindow.Peek(mockCTX, "alice")
+	s.NoError(err)
+	s.Equal(0, peek.Count)
+
+	s.mockFuncs.On("timeNow").Return(mockNow).Once()
+	_, err = s.slidingWindow.AcquireChain(mockCTX, []strategy.Link{{Strategy: otherStrategy{}, Key: "bob"}}, 1)
+	s.Equal(strategy.ErrChainMismatch, err)
+}
+
+func (s *slidingWindowSuite) TestPeek() {
+	key := "localhost"
+	for i := 0; i < 5; i++ {
+		s.mockFuncs.On("timeNow").Return(mockNow.Add(time.Duration(i) * time.Second)).Once()
+		_, err := s.slidingWindow.Acquire(mockCTX, key, 1)
+		s.NoError(err)
+	}
+
+	// peeking doesn't take a permit
+	for i := 0; i < 2; i++ {
+		s.mockFuncs.On("timeNow").Return(mockNow.Add(5 * time.Second)).Once()
+		act, err := s.slidingWindow.Peek(mockCTX, key)
+		s.NoError(err)
+		s.False(act.Allowed)
+		s.Equal(5, act.Count)
+		s.Equal(5*time.Second, act.RetryAfter)
+	}
+
+	s.mockFuncs.On("timeNow").Return(mockNow.Add(11 * time.Second)).Once()
+	act, err := s.slidingWindow.Peek(mockCTX, key)
+	s.NoError(err)
+	s.True(act.Allowed)
+	s.Equal(4, act.Count)
+	s.Equal(1, act.Remaining)
+}
+
+func (s *slidingWindowSuite) TestReset() {
+	key := "localhost"
+	for i := 0; i < 6; i++ {
+		s.mockFuncs.On("timeNow").Return(mockNow).Once()
+		_, err := s.slidingWindow.Acquire(mockCTX, key, 1)
+		s.NoError(err)
+	}
+
+	s.NoError(s.slidingWindow.Reset(mockCTX, key))
+
+	s.mockFuncs.On("timeNow").Return(mockNow).Once()
+	act, err := s.slidingWindow.Acquire(mockCTX, key, 1)
+	s.NoError(err)
+	s.True(act.Allowed)
+	s.Equal(1, act.Count)
+}
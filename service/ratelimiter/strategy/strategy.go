@@ -1,7 +1,82 @@
 package strategy
 
-import "github.com/chihkaiyu/ratelimiter/base/ctx"
+import (
+	"errors"
+	"time"
+
+	"github.com/chihkaiyu/ratelimiter/base/ctx"
+)
+
+var (
+	// ErrReserveNotSupported is returned when reserving from a strategy which can't take permits in advance
+	ErrReserveNotSupported = errors.New("reserve not supported")
+	// ErrChainMismatch is returned when chaining the strategies of different kinds
+	ErrChainMismatch = errors.New("chained strategies must be of the same kind")
+)
+
+// Decision is the result of acquiring a permit from a strategy
+type Decision struct {
+	// Allowed is true when the permit is granted
+	Allowed bool
+	// Count is the number of requests counted by the strategy
+	Count int
+	// Limit is the maximum number of requests the strategy accepts
+	Limit int
+	// Remaining is the number of requests could still be accepted
+	Remaining int
+	// RetryAfter is the time to wait before the next permit is available, zero when allowed
+	RetryAfter time.Duration
+	// ResetAfter is the time until the strategy is fully reset
+	ResetAfter time.Duration
+}
+
+// MoreRestrictive reports whether d is more restrictive than other
+func (d Decision) MoreRestrictive(other Decision) bool {
+	if d.Allowed != other.Allowed {
+		return !d.Allowed
+	}
+
+	if !d.Allowed {
+		return d.RetryAfter > other.RetryAfter
+	}
+
+	return d.Remaining < other.Remaining
+}
 
 type Strategy interface {
-	Acquire(context ctx.CTX, key string) (bool, int, error)
+	// Acquire acquires n permits of given key at once, n is the cost of the request
+	Acquire(context ctx.CTX, key string, n int) (Decision, error)
+
+	// Release gives back n permits granted by Acquire
+	Release(context ctx.CTX, key string, n int) error
+
+	// Peek returns the current state of given key without taking a permit, the count is the
+	// permits taken and the decision is whether the next request would be allowed
+	Peek(context ctx.CTX, key string) (Decision, error)
+
+	// Reset clears the state of given key
+	Reset(context ctx.CTX, key string) error
+}
+
+// Reserver is implemented by the strategies which could take permits in advance, e.g. token bucket
+type Reserver interface {
+	// Reserve takes n permits even if they're not available yet, the permits of the allowed decision
+	// are valid after its RetryAfter. It's denied if the permits taken in advance would exceed the
+	// bound of the strategy, and the RetryAfter is the time to wait before it could be reserved.
+	Reserve(context ctx.CTX, key string, n int) (Decision, error)
+}
+
+// Link is a key of a strategy acquired in a chain
+type Link struct {
+	Strategy Strategy
+	Key      string
+}
+
+// Chainer is implemented by the strategies which could acquire the keys of several strategies of
+// their kind in a single script, e.g. the levels of a rule, so no other request runs in between
+type Chainer interface {
+	// AcquireChain acquires n permits from the links in order, the strategies of the links must be of
+	// the same kind as the receiver. It stops at the first denied link and the permits of the links
+	// before it aren't taken. The decisions are of the links evaluated, the last one is denied if any.
+	AcquireChain(context ctx.CTX, links []Link, n int) ([]Decision, error)
 }
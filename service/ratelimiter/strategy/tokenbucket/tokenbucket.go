@@ -3,9 +3,12 @@ package tokenbucket
 import (
 	"flag"
 	"fmt"
+	"math"
+	"strconv"
 	"time"
 
 	goredis "github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
 
 	"github.com/chihkaiyu/ratelimiter/base/ctx"
 	"github.com/chihkaiyu/ratelimiter/service/ratelimiter/strategy"
@@ -13,27 +16,111 @@ import (
 )
 
 const (
-	// ARGV: nowTimestamp, nowNanoSecond, refillPerSecond, bucketSize
+	defaultPrefix = "tokenbucket"
+
+	// ARGV: nowTimestamp, nowNanoSecond, refillPerSecond, bucketSize, n
 	// if we return newSize, we wouldn't know there are remaining tokens or not
 	// since the newSize is 0 when there is no tokens or 1 left token
+	// the number of tokens is returned as string since lua number would be truncated to integer
 	script = `
 local newSize = tonumber(ARGV[4])
 local oldData = redis.call('HMGET', KEYS[1], 'ts', 'tsNano', 'tokens')
 if oldData[1] then
 	local secDiff = tonumber(ARGV[1]) - tonumber(oldData[1])
 	local nanosecDiff = tonumber(ARGV[2]) - tonumber(oldData[2])
-	newSize = tonumber(oldData[3]) + tonumber(ARGV[3]) * (secDiff + nanosecDiff / 1000000000)
+	newSize = math.min(tonumber(oldData[3]) + tonumber(ARGV[3]) * (secDiff + nanosecDiff / 1000000000), tonumber(ARGV[4]))
+end
+
+local remain = math.floor(newSize)
+if newSize >= tonumber(ARGV[5]) then
+	newSize = newSize - tonumber(ARGV[5])
+end
+
+redis.call('HMSET', KEYS[1], 'ts', ARGV[1], 'tsNano', ARGV[2], 'tokens', newSize)
+redis.call('EXPIRE', KEYS[1], math.ceil((tonumber(ARGV[4]) - math.min(newSize, 0)) / tonumber(ARGV[3])))
+
+return {remain, tostring(newSize)}
+`
+
+	// KEYS: buckets of the links
+	// ARGV: nowTimestamp, nowNanoSecond, n, then refillPerSecond and bucketSize of every link
+	// same as script for every bucket, but the tokens are taken only when all buckets have enough
+	chainScript = `
+local n = tonumber(ARGV[3])
+local result = {}
+local sizes = {}
+for i, key in ipairs(KEYS) do
+	local refill = tonumber(ARGV[2 * i + 2])
+	local newSize = tonumber(ARGV[2 * i + 3])
+	local oldData = redis.call('HMGET', key, 'ts', 'tsNano', 'tokens')
+	if oldData[1] then
+		local secDiff = tonumber(ARGV[1]) - tonumber(oldData[1])
+		local nanosecDiff = tonumber(ARGV[2]) - tonumber(oldData[2])
+		newSize = math.min(tonumber(oldData[3]) + refill * (secDiff + nanosecDiff / 1000000000), tonumber(ARGV[2 * i + 3]))
+	end
+
+	local remain = math.floor(newSize)
+	if newSize < n then
+		result[i] = {remain, tostring(newSize)}
+		return result
+	end
+	sizes[i] = newSize - n
+	result[i] = {remain, tostring(sizes[i])}
+end
+
+for i, key in ipairs(KEYS) do
+	redis.call('HMSET', key, 'ts', ARGV[1], 'tsNano', ARGV[2], 'tokens', sizes[i])
+	redis.call('EXPIRE', key, math.ceil((tonumber(ARGV[2 * i + 3]) - math.min(sizes[i], 0)) / tonumber(ARGV[2 * i + 2])))
+end
+return result
+`
+
+	// ARGV: nowTimestamp, nowNanoSecond, refillPerSecond, bucketSize, n, maxDebt
+	// same as script but the tokens could go negative down to -maxDebt, the negative tokens are
+	// the debt refilled before the next request is allowed
+	reserveScript = `
+local newSize = tonumber(ARGV[4])
+local oldData = redis.call('HMGET', KEYS[1], 'ts', 'tsNano', 'tokens')
+if oldData[1] then
+	local secDiff = tonumber(ARGV[1]) - tonumber(oldData[1])
+	local nanosecDiff = tonumber(ARGV[2]) - tonumber(oldData[2])
+	newSize = math.min(tonumber(oldData[3]) + tonumber(ARGV[3]) * (secDiff + nanosecDiff / 1000000000), tonumber(ARGV[4]))
 end
 
-local remain = math.min(math.floor(newSize), tonumber(ARGV[4]))
-if newSize >= 1 then
-	newSize = newSize - 1
+local reserved = 0
+if newSize - tonumber(ARGV[5]) >= -tonumber(ARGV[6]) then
+	newSize = newSize - tonumber(ARGV[5])
+	reserved = 1
 end
 
-redis.call('HMSET', KEYS[1], 'ts', ARGV[1], 'tsNano', ARGV[2], 'tokens', newSize) 
-redis.call('EXPIRE', KEYS[1], math.ceil(tonumber(ARGV[4]) / tonumber(ARGV[3])))
+redis.call('HMSET', KEYS[1], 'ts', ARGV[1], 'tsNano', ARGV[2], 'tokens', newSize)
+redis.call('EXPIRE', KEYS[1], math.ceil((tonumber(ARGV[4]) - math.min(newSize, 0)) / tonumber(ARGV[3])))
 
-return remain
+return {reserved, tostring(newSize)}
+`
+
+	// ARGV: nowTimestamp, nowNanoSecond, refillPerSecond, bucketSize
+	// same as script without taking a token
+	peekScript = `
+local newSize = tonumber(ARGV[4])
+local oldData = redis.call('HMGET', KEYS[1], 'ts', 'tsNano', 'tokens')
+if oldData[1] then
+	local secDiff = tonumber(ARGV[1]) - tonumber(oldData[1])
+	local nanosecDiff = tonumber(ARGV[2]) - tonumber(oldData[2])
+	newSize = math.min(tonumber(oldData[3]) + tonumber(ARGV[3]) * (secDiff + nanosecDiff / 1000000000), tonumber(ARGV[4]))
+end
+
+return tostring(newSize)
+`
+
+	// ARGV: bucketSize, n
+	// put the tokens back only when the bucket still exists
+	releaseScript = `
+local tokens = redis.call('HGET', KEYS[1], 'tokens')
+if tokens then
+	redis.call('HSET', KEYS[1], 'tokens', math.min(tonumber(tokens) + tonumber(ARGV[2]), tonumber(ARGV[1])))
+end
+return 0
 `
 )
 
@@ -45,46 +132,307 @@ var (
 )
 
 type impl struct {
-	redis       redis.Service
-	redisScript *goredis.Script
-	size        int
-	refill      float64
+	redis         redis.Service
+	redisScript   *goredis.Script
+	peekScript    *goredis.Script
+	releaseScript *goredis.Script
+	reserveScript *goredis.Script
+	chainScript   *goredis.Script
+	prefix        string
+	size          int
+	refill        float64
+	// maxDebt is the number of tokens could be reserved in advance, it's the size if it's 0
+	maxDebt int
 }
 
+// Option is an alias for functional argument in NewTokenBucket
+type Option func(*impl)
+
 func NewTokenBucket(
 	redis redis.Service,
+	opts ...Option,
 ) strategy.Strategy {
-	return &impl{
-		redis:       redis,
-		redisScript: goredis.NewScript(script),
-		size:        *bucketSize,
-		refill:      *refillPerSecond,
+	im := &impl{
+		redis:         redis,
+		redisScript:   goredis.NewScript(script),
+		peekScript:    goredis.NewScript(peekScript),
+		releaseScript: goredis.NewScript(releaseScript),
+		reserveScript: goredis.NewScript(reserveScript),
+		chainScript:   goredis.NewScript(chainScript),
+		prefix:        defaultPrefix,
+		size:          *bucketSize,
+		refill:        *refillPerSecond,
+	}
+	for _, opt := range opts {
+		opt(im)
+	}
+
+	return im
+}
+
+// WithSize sets the bucket size instead of the flag
+func WithSize(size int) Option {
+	return func(im *impl) {
+		im.size = size
+	}
+}
+
+// WithRefill sets the number of tokens refilled per second instead of the flag
+func WithRefill(refill float64) Option {
+	return func(im *impl) {
+		im.refill = refill
 	}
 }
 
-func (im *impl) Acquire(context ctx.CTX, key string) (bool, int, error) {
+// WithMaxDebt sets the number of tokens could be reserved in advance, the bucket size by default
+func WithMaxDebt(maxDebt int) Option {
+	return func(im *impl) {
+		im.maxDebt = maxDebt
+	}
+}
+
+// WithPrefix sets the prefix of redis key, strategies sharing the same redis
+// must have different prefixes
+func WithPrefix(prefix string) Option {
+	return func(im *impl) {
+		im.prefix = prefix
+	}
+}
+
+func (im *impl) redisKey(key string) string {
+	return fmt.Sprintf("%s:%s", im.prefix, key)
+}
+
+// durationOf returns the time to refill given number of tokens
+func (im *impl) durationOf(tokens float64) time.Duration {
+	if tokens <= 0 {
+		return 0
+	}
+
+	return time.Duration(math.Ceil(tokens / im.refill * float64(time.Second)))
+}
+
+func (im *impl) Acquire(context ctx.CTX, key string, n int) (strategy.Decision, error) {
 	now := timeNow()
 	nano := now.Nanosecond()
 
-	redisKey := fmt.Sprintf("tokenbucket:%s", key)
-	remain, err := im.redis.RunScript(
+	redisKey := im.redisKey(key)
+	value, err := im.redis.RunScript(
 		context,
 		im.redisScript,
 		[]string{redisKey},
 		now.Unix(),
 		nano,
-		*refillPerSecond,
-		*bucketSize,
+		im.refill,
+		im.size,
+		n,
 	)
 	if err != nil {
 		context.WithField("err", err).Error("redis.RunScript failed")
-		return false, 0, err
+		return strategy.Decision{}, err
 	}
 
-	if remain.(int64) <= 0 {
-		return false, *bucketSize, nil
+	return im.decisionOf(context, value, n)
+}
+
+// decisionOf returns the decision of the result of script, which is the tokens before taking n tokens
+// and the tokens left
+func (im *impl) decisionOf(context ctx.CTX, value interface{}, n int) (strategy.Decision, error) {
+	result := value.([]interface{})
+	remain := result[0].(int64)
+	tokens, err := strconv.ParseFloat(result[1].(string), 64)
+	if err != nil {
+		context.WithFields(logrus.Fields{
+			"err":    err,
+			"tokens": result[1],
+		}).Error("strconv.ParseFloat failed")
+		return strategy.Decision{}, err
+	}
+
+	if remain < int64(n) {
+		return strategy.Decision{
+			Allowed:    false,
+			Count:      im.size,
+			Limit:      im.size,
+			Remaining:  0,
+			RetryAfter: im.durationOf(float64(n) - tokens),
+			ResetAfter: im.durationOf(float64(im.size) - tokens),
+		}, nil
 	}
 
 	// we use the number of tokens taken from the bucket as the number of requests
-	return true, *bucketSize - int(remain.(int64)) + 1, nil
+	return strategy.Decision{
+		Allowed:    true,
+		Count:      im.size - int(remain) + n,
+		Limit:      im.size,
+		Remaining:  int(remain) - n,
+		ResetAfter: im.durationOf(float64(im.size) - tokens),
+	}, nil
+}
+
+// AcquireChain acquires n tokens from the buckets of the links in a single script
+func (im *impl) AcquireChain(context ctx.CTX, links []strategy.Link, n int) ([]strategy.Decision, error) {
+	now := timeNow()
+	strategies := []*impl{}
+	keys := []string{}
+	args := []interface{}{now.Unix(), now.Nanosecond(), n}
+	for _, link := range links {
+		s, ok := link.Strategy.(*impl)
+		if !ok {
+			context.WithField("key", link.Key).Error("strategy of link isn't token bucket")
+			return nil, strategy.ErrChainMismatch
+		}
+		strategies = append(strategies, s)
+		keys = append(keys, s.redisKey(link.Key))
+		args = append(args, s.refill, s.size)
+	}
+
+	value, err := im.redis.RunScript(context, im.chainScript, keys, args...)
+	if err != nil {
+		context.WithFields(logrus.Fields{
+			"err":  err,
+			"keys": keys,
+		}).Error("redis.RunScript failed")
+		return nil, err
+	}
+
+	decisions := []strategy.Decision{}
+	for i, result := range value.([]interface{}) {
+		decision, err := strategies[i].decisionOf(context, result, n)
+		if err != nil {
+			return nil, err
+		}
+		decisions = append(decisions, decision)
+	}
+
+	return decisions, nil
+}
+
+// Reserve takes n tokens in advance, the tokens could go negative down to -maxDebt. The reserved
+// tokens are valid after the debt is refilled.
+func (im *impl) Reserve(context ctx.CTX, key string, n int) (strategy.Decision, error) {
+	maxDebt := im.maxDebt
+	if maxDebt == 0 {
+		maxDebt = im.size
+	}
+
+	now := timeNow()
+	redisKey := im.redisKey(key)
+	value, err := im.redis.RunScript(
+		context,
+		im.reserveScript,
+		[]string{redisKey},
+		now.Unix(),
+		now.Nanosecond(),
+		im.refill,
+		im.size,
+		n,
+		maxDebt,
+	)
+	if err != nil {
+		context.WithFields(logrus.Fields{
+			"err": err,
+			"key": redisKey,
+		}).Error("redis.RunScript failed")
+		return strategy.Decision{}, err
+	}
+
+	result := value.([]interface{})
+	reserved := result[0].(int64) == 1
+	tokens, err := strconv.ParseFloat(result[1].(string), 64)
+	if err != nil {
+		context.WithFields(logrus.Fields{
+			"err":    err,
+			"tokens": result[1],
+		}).Error("strconv.ParseFloat failed")
+		return strategy.Decision{}, err
+	}
+
+	remain := int(math.Floor(tokens))
+	if !reserved {
+		return strategy.Decision{
+			Allowed:    false,
+			Count:      im.size - remain,
+			Limit:      im.size,
+			Remaining:  0,
+			RetryAfter: im.durationOf(float64(n-maxDebt) - tokens),
+			ResetAfter: im.durationOf(float64(im.size) - tokens),
+		}, nil
+	}
+
+	decision := strategy.Decision{
+		Allowed:    true,
+		Count:      im.size - remain,
+		Limit:      im.size,
+		Remaining:  remain,
+		RetryAfter: im.durationOf(-tokens),
+		ResetAfter: im.durationOf(float64(im.size) - tokens),
+	}
+	if decision.Remaining < 0 {
+		decision.Remaining = 0
+	}
+
+	return decision, nil
+}
+
+func (im *impl) Release(context ctx.CTX, key string, n int) error {
+	redisKey := im.redisKey(key)
+	if _, err := im.redis.RunScript(context, im.releaseScript, []string{redisKey}, im.size, n); err != nil {
+		context.WithFields(logrus.Fields{
+			"err": err,
+			"key": redisKey,
+		}).Error("redis.RunScript failed")
+		return err
+	}
+
+	return nil
+}
+
+func (im *impl) Peek(context ctx.CTX, key string) (strategy.Decision, error) {
+	now := timeNow()
+	redisKey := im.redisKey(key)
+	value, err := im.redis.RunScript(context, im.peekScript, []string{redisKey}, now.Unix(), now.Nanosecond(), im.refill, im.size)
+	if err != nil {
+		context.WithFields(logrus.Fields{
+			"err": err,
+			"key": redisKey,
+		}).Error("redis.RunScript failed")
+		return strategy.Decision{}, err
+	}
+
+	tokens, err := strconv.ParseFloat(value.(string), 64)
+	if err != nil {
+		context.WithFields(logrus.Fields{
+			"err":    err,
+			"tokens": value,
+		}).Error("strconv.ParseFloat failed")
+		return strategy.Decision{}, err
+	}
+
+	remain := int(math.Floor(tokens))
+	decision := strategy.Decision{
+		Allowed:    remain >= 1,
+		Count:      im.size - remain,
+		Limit:      im.size,
+		Remaining:  remain,
+		ResetAfter: im.durationOf(float64(im.size) - tokens),
+	}
+	if !decision.Allowed {
+		decision.RetryAfter = im.durationOf(1 - tokens)
+	}
+
+	return decision, nil
+}
+
+func (im *impl) Reset(context ctx.CTX, key string) error {
+	redisKey := im.redisKey(key)
+	if err := im.redis.Del(context, redisKey); err != nil {
+		context.WithFields(logrus.Fields{
+			"err": err,
+			"key": redisKey,
+		}).Error("redis.Del failed")
+		return err
+	}
+
+	return nil
 }
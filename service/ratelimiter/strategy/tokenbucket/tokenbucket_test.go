@@ -9,6 +9,7 @@ import (
 
 	"github.com/chihkaiyu/ratelimiter/base/ctx"
 	"github.com/chihkaiyu/ratelimiter/base/docker"
+	"github.com/chihkaiyu/ratelimiter/service/ratelimiter/strategy"
 	"github.com/chihkaiyu/ratelimiter/service/redis"
 )
 
@@ -26,6 +27,11 @@ func (m *mockFuncs) timeNow() time.Time {
 	return args.Get(0).(time.Time)
 }
 
+// otherStrategy is a strategy of another kind
+type otherStrategy struct {
+	strategy.Strategy
+}
+
 type tokenBucketSuite struct {
 	suite.Suite
 	redisPort   string
@@ -49,10 +55,11 @@ func (s *tokenBucketSuite) TearDownSuite() {
 }
 
 func (s *tokenBucketSuite) SetupTest() {
-	redis := redis.NewRedis("localhost:"+s.redisPort, "")
-	s.tokenBucket = NewTokenBucket(redis).(*impl)
 	*bucketSize = 5
 	*refillPerSecond = 0.1
+	redis, err := redis.NewRedis("localhost:"+s.redisPort, "")
+	s.Require().NoError(err)
+	s.tokenBucket = NewTokenBucket(redis).(*impl)
 
 	// mock functions
 	s.mockFuncs = new(mockFuncs)
@@ -132,18 +139,213 @@ func (s *tokenBucketSuite) TestAccquire() {
 		s.Len(test.Exp, len(test.AccquireTime))
 		s.Len(test.ExpCount, len(test.AccquireTime))
 
-		var act bool
-		var actCount int
-		var err error
 		for i, t := range test.AccquireTime {
 			s.mockFuncs.On("timeNow").Return(t).Once()
-			act, actCount, err = s.tokenBucket.Acquire(mockCTX, key)
+			act, err := s.tokenBucket.Acquire(mockCTX, key, 1)
 			s.NoError(err, test.Desc)
-			s.Equal(test.Exp[i], act, test.Desc)
-			s.Equal(test.ExpCount[i], actCount, test.Desc)
+			s.Equal(test.Exp[i], act.Allowed, test.Desc)
+			s.Equal(test.ExpCount[i], act.Count, test.Desc)
 		}
 
 		s.TearDownTest()
 	}
 
 }
+
+func (s *tokenBucketSuite) TestRelease() {
+	key := "localhost"
+	for i := 0; i < 5; i++ {
+		s.mockFuncs.On("timeNow").Return(mockNow).Once()
+		act, err := s.tokenBucket.Acquire(mockCTX, key, 1)
+		s.NoError(err)
+		s.True(act.Allowed)
+	}
+
+	s.mockFuncs.On("timeNow").Return(mockNow).Once()
+	act, err := s.tokenBucket.Acquire(mockCTX, key, 1)
+	s.NoError(err)
+	s.False(act.Allowed)
+	s.Equal(10*time.Second, act.RetryAfter)
+
+	s.NoError(s.tokenBucket.Release(mockCTX, key, 1))
+
+	s.mockFuncs.On("timeNow").Return(mockNow).Once()
+	act, err = s.tokenBucket.Acquire(mockCTX, key, 1)
+	s.NoError(err)
+	s.True(act.Allowed)
+	s.Equal(0, act.Remaining)
+}
+
+func (s *tokenBucketSuite) TestAcquireN() {
+	key := "localhost"
+	s.mockFuncs.On("timeNow").Return(mockNow).Once()
+	act, err := s.tokenBucket.Acquire(mockCTX, key, 3)
+	s.NoError(err)
+	s.True(act.Allowed)
+	s.Equal(3, act.Count)
+	s.Equal(2, act.Remaining)
+
+	// a denied request doesn't take any token
+	s.mockFuncs.On("timeNow").Return(mockNow).Once()
+	act, err = s.tokenBucket.Acquire(mockCTX, key, 3)
+	s.NoError(err)
+	s.False(act.Allowed)
+	s.Equal(10*time.Second, act.RetryAfter)
+
+	s.mockFuncs.On("timeNow").Return(mockNow).Once()
+	act, err = s.tokenBucket.Acquire(mockCTX, key, 2)
+	s.NoError(err)
+	s.True(act.Allowed)
+	s.Equal(0, act.Remaining)
+
+	s.NoError(s.tokenBucket.Release(mockCTX, key, 2))
+	s.mockFuncs.On("timeNow").Return(mockNow).Once()
+	act, err = s.tokenBucket.Acquire(mockCTX, key, 2)
+	s.NoError(err)
+	s.True(act.Allowed)
+}
+
+func (s *tokenBucketSuite) TestAcquireChain() {
+	// the user bucket has 5 tokens refilled 0.1 per second and the global one has 7 refilled 1 per second
+	global := NewTokenBucket(s.tokenBucket.redis, WithSize(7), WithRefill(1), WithPrefix("global")).(*impl)
+	links := []strategy.Link{{Strategy: s.tokenBucket, Key: "bob"}, {Strategy: global, Key: "global"}}
+
+	s.mockFuncs.On("timeNow").Return(mockNow).Once()
+	act, err := s.tokenBucket.AcquireChain(mockCTX, links, 4)
+	s.NoError(err)
+	s.Require().Len(act, 2)
+	s.True(act[0].Allowed)
+	s.Equal(1, act[0].Remaining)
+	s.True(act[1].Allowed)
+	s.Equal(3, act[1].Remaining)
+
+	// denied by the user bucket, the global one isn't evaluated
+	s.mockFuncs.On("timeNow").Return(mockNow).Once()
+	act, err = s.tokenBucket.AcquireChain(mockCTX, links, 2)
+	s.NoError(err)
+	s.Require().Len(act, 1)
+	s.False(act[0].Allowed)
+	s.Equal(10*time.Second, act[0].RetryAfter)
+
+	// denied by the global bucket, no token is taken from the user bucket of alice
+	links[0].Key = "alice"
+	s.mockFuncs.On("timeNow").Return(mockNow).Once()
+	act, err = s.tokenBucket.AcquireChain(mockCTX, links, 4)
+	s.NoError(err)
+	s.Require().Len(act, 2)
+	s.True(act[0].Allowed)
+	s.False(act[1].Allowed)
+	s.Equal(time.Second, act[1].RetryAfter)
+	s.mockFuncs.On("timeNow").Return(mockNow).Once()
+	peek, err := s.tokenBucket.Peek(mockCTX, "alice")
+	s.NoError(err)
+	s.Equal(5, peek.Remaining)
+
+	s.mockFuncs.On("timeNow").Return(mockNow).Once()
+	_, err = s.tokenBucket.AcquireChain(mockCTX, []strategy.Link{{Strategy: otherStrategy{}, Key: "bob"}}, 1)
+	s.Equal(strategy.ErrChainMismatch, err)
+}
+
+func (s *tokenBucketSuite) TestReserve() {
+	key := "localhost"
+	s.mockFuncs.On("timeNow").Return(mockNow).Once()
+	act, err := s.tokenBucket.Reserve(mockCTX, key, 3)
+	s.NoError(err)
+	s.True(act.Allowed)
+	s.Equal(time.Duration(0), act.RetryAfter)
+	s.Equal(2, act.Remaining)
+
+	// the tokens go negative, the reserved tokens are valid after the debt is refilled
+	s.mockFuncs.On("timeNow").Return(mockNow).Once()
+	act, err = s.tokenBucket.Reserve(mockCTX, key, 4)
+	s.NoError(err)
+	s.True(act.Allowed)
+	s.Equal(20*time.Second, act.RetryAfter)
+	s.Equal(7, act.Count)
+	s.Equal(0, act.Remaining)
+
+	s.mockFuncs.On("timeNow").Return(mockNow).Once()
+	act, err = s.tokenBucket.Acquire(mockCTX, key, 1)
+	s.NoError(err)
+	s.False(act.Allowed)
+	s.Equal(30*time.Second, act.RetryAfter)
+
+	// the debt is bounded by the bucket size
+	s.mockFuncs.On("timeNow").Return(mockNow).Once()
+	act, err = s.tokenBucket.Reserve(mockCTX, key, 4)
+	s.NoError(err)
+	s.False(act.Allowed)
+	s.Equal(10*time.Second, act.RetryAfter)
+
+	// canceling gives back the tokens
+	s.NoError(s.tokenBucket.Release(mockCTX, key, 4))
+	s.mockFuncs.On("timeNow").Return(mockNow).Once()
+	act, err = s.tokenBucket.Acquire(mockCTX, key, 2)
+	s.NoError(err)
+	s.True(act.Allowed)
+}
+
+func (s *tokenBucketSuite) TestReserveMaxDebt() {
+	key := "localhost"
+	WithMaxDebt(1)(s.tokenBucket)
+
+	s.mockFuncs.On("timeNow").Return(mockNow).Once()
+	act, err := s.tokenBucket.Reserve(mockCTX, key, 6)
+	s.NoError(err)
+	s.True(act.Allowed)
+	s.Equal(10*time.Second, act.RetryAfter)
+
+	s.mockFuncs.On("timeNow").Return(mockNow.Add(5 * time.Second)).Once()
+	act, err = s.tokenBucket.Reserve(mockCTX, key, 1)
+	s.NoError(err)
+	s.False(act.Allowed)
+	s.Equal(5*time.Second, act.RetryAfter)
+}
+
+func (s *tokenBucketSuite) TestPeek() {
+	key := "localhost"
+	s.mockFuncs.On("timeNow").Return(mockNow).Once()
+	act, err := s.tokenBucket.Peek(mockCTX, key)
+	s.NoError(err)
+	s.True(act.Allowed)
+	s.Equal(5, act.Remaining)
+
+	for i := 0; i < 5; i++ {
+		s.mockFuncs.On("timeNow").Return(mockNow).Once()
+		_, err := s.tokenBucket.Acquire(mockCTX, key, 1)
+		s.NoError(err)
+	}
+
+	// peeking doesn't take a token
+	for i := 0; i < 2; i++ {
+		s.mockFuncs.On("timeNow").Return(mockNow.Add(5 * time.Second)).Once()
+		act, err = s.tokenBucket.Peek(mockCTX, key)
+		s.NoError(err)
+		s.False(act.Allowed)
+		s.Equal(5, act.Count)
+		s.Equal(5*time.Second, act.RetryAfter)
+	}
+
+	s.mockFuncs.On("timeNow").Return(mockNow.Add(10 * time.Second)).Once()
+	act, err = s.tokenBucket.Peek(mockCTX, key)
+	s.NoError(err)
+	s.True(act.Allowed)
+	s.Equal(1, act.Remaining)
+}
+
+func (s *tokenBucketSuite) TestReset() {
+	key := "localhost"
+	for i := 0; i < 6; i++ {
+		s.mockFuncs.On("timeNow").Return(mockNow).Once()
+		_, err := s.tokenBucket.Acquire(mockCTX, key, 1)
+		s.NoError(err)
+	}
+
+	s.NoError(s.tokenBucket.Reset(mockCTX, key))
+
+	s.mockFuncs.On("timeNow").Return(mockNow).Once()
+	act, err := s.tokenBucket.Acquire(mockCTX, key, 1)
+	s.NoError(err)
+	s.True(act.Allowed)
+	s.Equal(4, act.Remaining)
+}
@@ -0,0 +1,66 @@
+package ratelimiter
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/chihkaiyu/ratelimiter/base/ctx"
+)
+
+const (
+	// minWait is the least time to wait before acquiring again
+	minWait = 10 * time.Millisecond
+	// waitJitter is the max fraction of the wait added randomly, so the waiters denied together
+	// don't acquire again at the same time
+	waitJitter = 0.1
+)
+
+var (
+	// ErrWaitExceedsDeadline is returned by Wait when the permits can't be acquired before the deadline
+	ErrWaitExceedsDeadline = errors.New("wait exceeds deadline")
+	// ErrExceedsLimit is returned by Wait when n is more than the limit, so it's never allowed
+	ErrExceedsLimit = errors.New("permits exceed limit")
+)
+
+// Wait acquires n permits from the limiter, it sleeps for the retry-after of the denied decision with
+// jitter and acquires again until they're allowed. It stops when the context is done, and returns
+// ErrWaitExceedsDeadline without sleeping if the retry-after is beyond the deadline of the context.
+// The last denied decision is returned with the error.
+func Wait(context ctx.CTX, limiter Limiter, rule string, descriptor Descriptor, n int) (Decision, error) {
+	for {
+		decision, err := limiter.AcquireN(context, rule, descriptor, n)
+		if err != nil {
+			return Decision{}, err
+		}
+		if decision.Allowed {
+			return decision, nil
+		}
+		if decision.Limit > 0 && n > decision.Limit {
+			return decision, ErrExceedsLimit
+		}
+
+		wait := decision.RetryAfter
+		if wait < minWait {
+			wait = minWait
+		}
+		sleep := wait + time.Duration(rand.Int63n(int64(float64(wait)*waitJitter)+1))
+		if deadline, ok := context.Deadline(); ok {
+			now := timeNow()
+			if now.Add(wait).After(deadline) {
+				return decision, ErrWaitExceedsDeadline
+			}
+			if now.Add(sleep).After(deadline) {
+				sleep = deadline.Sub(now)
+			}
+		}
+
+		timer := time.NewTimer(sleep)
+		select {
+		case <-timer.C:
+		case <-context.Done():
+			timer.Stop()
+			return decision, context.Err()
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package ratelimiter
+
+import (
+	gocontext "context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/chihkaiyu/ratelimiter/base/ctx"
+	"github.com/chihkaiyu/ratelimiter/service/ratelimiter/strategy"
+)
+
+type mockLimiter struct {
+	mock.Mock
+}
+
+func (m *mockLimiter) Acquire(context ctx.CTX, rule string, descriptor Descriptor) (Decision, error) {
+	return m.AcquireN(context, rule, descriptor, 1)
+}
+
+func (m *mockLimiter) AcquireN(context ctx.CTX, rule string, descriptor Descriptor, n int) (Decision, error) {
+	args := m.Called(rule, descriptor, n)
+	return args.Get(0).(Decision), args.Error(1)
+}
+
+type waitSuite struct {
+	suite.Suite
+	limiter *mockLimiter
+}
+
+func TestWaitSuite(t *testing.T) {
+	suite.Run(t, new(waitSuite))
+}
+
+func (s *waitSuite) SetupTest() {
+	s.limiter = new(mockLimiter)
+}
+
+func (s *waitSuite) TearDownTest() {
+	s.limiter.AssertExpectations(s.T())
+}
+
+func denied(retryAfter time.Duration) Decision {
+	return Decision{Decision: strategy.Decision{Allowed: false, Count: 10, Limit: 10, RetryAfter: retryAfter}, Rule: "api", Level: "user"}
+}
+
+func (s *waitSuite) TestWait() {
+	allowed := Decision{Decision: strategy.Decision{Allowed: true, Count: 10, Limit: 10}, Rule: "api", Level: "user"}
+	descriptor := Entries{"user": "bob"}
+	s.limiter.On("AcquireN", "api", descriptor, 3).Return(denied(20*time.Millisecond), nil).Once()
+	// retry-after 0 still waits a little
+	s.limiter.On("AcquireN", "api", descriptor, 3).Return(denied(0), nil).Once()
+	s.limiter.On("AcquireN", "api", descriptor, 3).Return(allowed, nil).Once()
+
+	start := time.Now()
+	decision, err := Wait(mockCTX, s.limiter, "api", descriptor, 3)
+	s.Require().NoError(err)
+	s.Equal(allowed, decision)
+	elapsed := time.Since(start)
+	s.True(elapsed >= 20*time.Millisecond+minWait, elapsed)
+}
+
+func (s *waitSuite) TestWaitError() {
+	s.limiter.On("AcquireN", "broken", mock.Anything, 1).Return(Decision{}, fmt.Errorf("redis down")).Once()
+	s.limiter.On("AcquireN", "api", mock.Anything, 11).Return(denied(time.Second), nil).Once()
+	s.limiter.On("AcquireN", "api", mock.Anything, 1).Return(denied(time.Second), nil).Twice()
+
+	withTimeout := func(d time.Duration) ctx.CTX {
+		context, _ := ctx.WithTimeout(mockCTX, d)
+		return context
+	}
+	canceled := func() ctx.CTX {
+		c, cancel := gocontext.WithCancel(gocontext.Background())
+		time.AfterFunc(20*time.Millisecond, cancel)
+		return ctx.WithContext(c, nil)
+	}
+
+	tests := []struct {
+		Desc    string
+		Context ctx.CTX
+		Rule    string
+		N       int
+		ExpErr  error
+	}{
+		{
+			Desc:    "limiter failed",
+			Context: mockCTX,
+			Rule:    "broken",
+			N:       1,
+			ExpErr:  fmt.Errorf("redis down"),
+		},
+		{
+			Desc:    "never allowed",
+			Context: mockCTX,
+			Rule:    "api",
+			N:       11,
+			ExpErr:  ErrExceedsLimit,
+		},
+		{
+			Desc:    "retry-after beyond deadline",
+			Context: withTimeout(time.Second / 2),
+			Rule:    "api",
+			N:       1,
+			ExpErr:  ErrWaitExceedsDeadline,
+		},
+		{
+			Desc:    "canceled while waiting",
+			Context: canceled(),
+			Rule:    "api",
+			N:       1,
+			ExpErr:  gocontext.Canceled,
+		},
+	}
+
+	for _, t := range tests {
+		start := time.Now()
+		_, err := Wait(t.Context, s.limiter, t.Rule, Entries{"user": "bob"}, t.N)
+		s.Equal(t.ExpErr, err, t.Desc)
+		s.True(time.Since(start) < time.Second/2, t.Desc)
+	}
+}
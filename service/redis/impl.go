@@ -1,34 +1,123 @@
 package redis
 
 import (
+	"flag"
+	"sync"
 	"time"
 
 	"github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
 
 	"github.com/chihkaiyu/ratelimiter/base/ctx"
 )
 
+const (
+	maxBackoff = 30 * time.Second
+)
+
+var (
+	connectRetries = flag.Int("redis_connect_retries", 5, "the number of retries connecting to redis at startup")
+	connectBackoff = flag.Int("redis_connect_backoff", 500, "the backoff before the first retry connecting to redis (in millisecond), doubled for every further retry")
+	degradedStart  = flag.Bool("redis_degraded_start", false, "start without redis if it's unreachable after retries, and keep reconnecting in background")
+)
+
+// DegradedStart returns true if redis_degraded_start is set, the server is meant to serve without
+// redis by the failure policy then
+func DegradedStart() bool {
+	return *degradedStart
+}
+
 type impl struct {
 	client *redis.Client
+	// closed is closed when the client is closed, to stop reconnecting
+	closed    chan struct{}
+	closeOnce sync.Once
 }
 
-func NewRedis(addr, password string) Service {
-	client := redis.NewClient(&redis.Options{
-		Addr:     addr,
-		Password: password,
-	})
+// NewRedis connects to the redis server, retrying with exponential backoff. It returns error if the
+// server is unreachable after retries, unless degraded start is enabled, in which case the service
+// is returned and keeps reconnecting in background. Commands fail before the server is reachable.
+func NewRedis(addr, password string) (Service, error) {
+	im := &impl{
+		client: redis.NewClient(&redis.Options{
+			Addr:     addr,
+			Password: password,
+		}),
+		closed: make(chan struct{}),
+	}
 
-	_, err := client.Ping(ctx.Background()).Result()
-	if err != nil {
-		panic(err)
+	context := ctx.Background()
+	err := im.connect(context, *connectRetries)
+	if err == nil {
+		return im, nil
 	}
-	return &impl{
-		client: client,
+
+	if !*degradedStart {
+		context.WithFields(logrus.Fields{
+			"err":  err,
+			"addr": addr,
+		}).Error("im.connect failed")
+		im.client.Close()
+		return nil, err
+	}
+
+	context.WithFields(logrus.Fields{
+		"err":  err,
+		"addr": addr,
+	}).Warn("redis is unreachable, start in degraded mode")
+	go im.connect(context, -1)
+
+	return im, nil
+}
+
+// connect pings the server until it's reachable, retries forever if retries is negative
+func (im *impl) connect(context ctx.CTX, retries int) error {
+	var err error
+	for attempt := 0; ; attempt++ {
+		err = im.client.Ping(context).Err()
+		reportUp(err)
+		if err == nil {
+			if attempt > 0 {
+				context.WithField("attempt", attempt).Info("redis connected")
+			}
+			return nil
+		}
+		if retries >= 0 && attempt >= retries {
+			return err
+		}
+
+		d := backoff(attempt)
+		context.WithFields(logrus.Fields{
+			"err":     err,
+			"attempt": attempt,
+			"backoff": d,
+		}).Warn("client.Ping failed, retrying")
+		select {
+		case <-time.After(d):
+		case <-im.closed:
+			return err
+		}
 	}
 }
 
+// backoff returns the duration before the retry of given attempt
+func backoff(attempt int) time.Duration {
+	d := time.Duration(*connectBackoff) * time.Millisecond
+	for i := 0; i < attempt && d < maxBackoff; i++ {
+		d *= 2
+	}
+	if d > maxBackoff {
+		return maxBackoff
+	}
+
+	return d
+}
+
 func (im *impl) Ping(context ctx.CTX) error {
+	defer observe("ping")()
+
 	_, err := im.client.Ping(context).Result()
+	reportUp(err)
 	if err != nil {
 		context.WithField("err", err).Error("client.Ping failed")
 		return err
@@ -38,6 +127,8 @@ func (im *impl) Ping(context ctx.CTX) error {
 }
 
 func (im *impl) RunScript(context ctx.CTX, script *redis.Script, keys []string, args ...interface{}) (interface{}, error) {
+	defer observe("script")()
+
 	value, err := script.Run(context, im.client, keys, args).Result()
 	if err != nil && err != redis.Nil {
 		context.WithField("err", err).Error("script.Run failed")
@@ -47,9 +138,13 @@ func (im *impl) RunScript(context ctx.CTX, script *redis.Script, keys []string,
 }
 
 func (im *impl) Get(context ctx.CTX, key string) ([]byte, error) {
+	defer observe("get")()
+
 	value, err := im.client.Get(context, key).Bytes()
 	if err != nil {
-		context.WithField("err", err).Error("client.Get failed")
+		if err != redis.Nil {
+			context.WithField("err", err).Error("client.Get failed")
+		}
 		return []byte{}, err
 	}
 
@@ -57,6 +152,8 @@ func (im *impl) Get(context ctx.CTX, key string) ([]byte, error) {
 }
 
 func (im *impl) Set(context ctx.CTX, key string, value []byte, ttl time.Duration) error {
+	defer observe("set")()
+
 	if err := im.client.Set(context, key, value, ttl).Err(); err != nil {
 		context.WithField("err", err).Error("client.Set failed")
 		return err
@@ -65,7 +162,20 @@ func (im *impl) Set(context ctx.CTX, key string, value []byte, ttl time.Duration
 	return nil
 }
 
+func (im *impl) Del(context ctx.CTX, keys ...string) error {
+	defer observe("del")()
+
+	if err := im.client.Del(context, keys...).Err(); err != nil {
+		context.WithField("err", err).Error("client.Del failed")
+		return err
+	}
+
+	return nil
+}
+
 func (im *impl) Incr(context ctx.CTX, key string) (int64, error) {
+	defer observe("incr")()
+
 	value, err := im.client.Incr(context, key).Result()
 	if err != nil {
 		context.WithField("err", err).Error("client.Incr failed")
@@ -75,7 +185,33 @@ func (im *impl) Incr(context ctx.CTX, key string) (int64, error) {
 	return value, nil
 }
 
+func (im *impl) IncrBy(context ctx.CTX, key string, value int64) (int64, error) {
+	defer observe("incrby")()
+
+	value, err := im.client.IncrBy(context, key, value).Result()
+	if err != nil {
+		context.WithField("err", err).Error("client.IncrBy failed")
+		return 0, err
+	}
+
+	return value, nil
+}
+
+func (im *impl) Decr(context ctx.CTX, key string) (int64, error) {
+	defer observe("decr")()
+
+	value, err := im.client.Decr(context, key).Result()
+	if err != nil {
+		context.WithField("err", err).Error("client.Decr failed")
+		return 0, err
+	}
+
+	return value, nil
+}
+
 func (im *impl) Expire(context ctx.CTX, key string, ttl time.Duration) error {
+	defer observe("expire")()
+
 	_, err := im.client.Expire(context, key, ttl).Result()
 	if err != nil {
 		context.WithField("err", err).Error("client.Expire failed")
@@ -85,11 +221,68 @@ func (im *impl) Expire(context ctx.CTX, key string, ttl time.Duration) error {
 	return nil
 }
 
-func (im *impl) ZAdd(context ctx.CTX, key string, score int, member string) error {
-	_, err := im.client.ZAdd(context, key, &redis.Z{
-		Score:  float64(score),
-		Member: member,
-	}).Result()
+func (im *impl) TTL(context ctx.CTX, key string) (time.Duration, error) {
+	defer observe("pttl")()
+
+	ttl, err := im.client.PTTL(context, key).Result()
+	if err != nil {
+		context.WithField("err", err).Error("client.PTTL failed")
+		return 0, err
+	}
+
+	// negative value means the key doesn't exist or has no TTL
+	if ttl < 0 {
+		return 0, nil
+	}
+
+	return ttl, nil
+}
+
+func (im *impl) HSet(context ctx.CTX, key, field string, value []byte) error {
+	defer observe("hset")()
+
+	if err := im.client.HSet(context, key, field, value).Err(); err != nil {
+		context.WithField("err", err).Error("client.HSet failed")
+		return err
+	}
+
+	return nil
+}
+
+func (im *impl) HGetAll(context ctx.CTX, key string) (map[string]string, error) {
+	defer observe("hgetall")()
+
+	values, err := im.client.HGetAll(context, key).Result()
+	if err != nil {
+		context.WithField("err", err).Error("client.HGetAll failed")
+		return nil, err
+	}
+
+	return values, nil
+}
+
+func (im *impl) HDel(context ctx.CTX, key string, fields ...string) error {
+	defer observe("hdel")()
+
+	if err := im.client.HDel(context, key, fields...).Err(); err != nil {
+		context.WithField("err", err).Error("client.HDel failed")
+		return err
+	}
+
+	return nil
+}
+
+func (im *impl) ZAdd(context ctx.CTX, key string, score int, members ...string) error {
+	defer observe("zadd")()
+
+	zs := make([]*redis.Z, 0, len(members))
+	for _, member := range members {
+		zs = append(zs, &redis.Z{
+			Score:  float64(score),
+			Member: member,
+		})
+	}
+	_, err := im.client.ZAdd(context, key, zs...).Result()
 	if err != nil {
 		context.WithField("err", err).Error("client.ZAdd failed")
 		return err
@@ -99,6 +292,8 @@ func (im *impl) ZAdd(context ctx.CTX, key string, score int, member string) erro
 }
 
 func (im *impl) ZRange(context ctx.CTX, key string, start, end int) ([]string, error) {
+	defer observe("zrange")()
+
 	members, err := im.client.ZRange(context, key, int64(start), int64(end)).Result()
 	if err != nil {
 		context.WithField("err", err).Error("client.ZRange failed")
@@ -108,7 +303,26 @@ func (im *impl) ZRange(context ctx.CTX, key string, start, end int) ([]string, e
 	return members, nil
 }
 
+func (im *impl) ZRangeByScore(context ctx.CTX, key string, min, max string, offset, count int) ([]string, error) {
+	defer observe("zrangebyscore")()
+
+	members, err := im.client.ZRangeByScore(context, key, &redis.ZRangeBy{
+		Min:    min,
+		Max:    max,
+		Offset: int64(offset),
+		Count:  int64(count),
+	}).Result()
+	if err != nil {
+		context.WithField("err", err).Error("client.ZRangeByScore failed")
+		return []string{}, err
+	}
+
+	return members, nil
+}
+
 func (im *impl) ZCount(context ctx.CTX, key string, min, max string) (int, error) {
+	defer observe("zcount")()
+
 	count, err := im.client.ZCount(context, key, min, max).Result()
 	if err != nil {
 		context.WithField("err", err).Error("client.ZCount failed")
@@ -119,6 +333,8 @@ func (im *impl) ZCount(context ctx.CTX, key string, min, max string) (int, error
 }
 
 func (im *impl) ZRemRangeByScore(context ctx.CTX, key string, min, max string) error {
+	defer observe("zremrangebyscore")()
+
 	_, err := im.client.ZRemRangeByScore(context, key, min, max).Result()
 	if err != nil {
 		context.WithField("err", err).Error("client.ZRemRangeByScore failed")
@@ -126,3 +342,36 @@ func (im *impl) ZRemRangeByScore(context ctx.CTX, key string, min, max string) e
 	}
 	return nil
 }
+
+func (im *impl) ZRevRangeWithScores(context ctx.CTX, key string, start, end int) ([]Z, error) {
+	defer observe("zrevrange")()
+
+	members, err := im.client.ZRevRangeWithScores(context, key, int64(start), int64(end)).Result()
+	if err != nil {
+		context.WithField("err", err).Error("client.ZRevRangeWithScores failed")
+		return nil, err
+	}
+
+	return members, nil
+}
+
+func (im *impl) ZRemRangeByRank(context ctx.CTX, key string, start, end int) error {
+	defer observe("zremrangebyrank")()
+
+	_, err := im.client.ZRemRangeByRank(context, key, int64(start), int64(end)).Result()
+	if err != nil {
+		context.WithField("err", err).Error("client.ZRemRangeByRank failed")
+		return err
+	}
+	return nil
+}
+
+func (im *impl) Close() error {
+	im.closeOnce.Do(func() { close(im.closed) })
+	if err := im.client.Close(); err != nil {
+		logrus.WithField("err", err).Error("client.Close failed")
+		return err
+	}
+
+	return nil
+}
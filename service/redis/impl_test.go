@@ -1,11 +1,13 @@
 package redis
 
 import (
+	"net"
 	"strconv"
 	"testing"
 	"time"
 
 	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/suite"
 
 	"github.com/chihkaiyu/ratelimiter/base/ctx"
@@ -41,7 +43,9 @@ func (s *redisSuite) TearDownSuite() {
 }
 
 func (s *redisSuite) SetupTest() {
-	s.redis = NewRedis(s.addr, "").(*impl)
+	r, err := NewRedis(s.addr, "")
+	s.Require().NoError(err)
+	s.redis = r.(*impl)
 }
 
 func (s *redisSuite) TearDownTest() {
@@ -49,8 +53,10 @@ func (s *redisSuite) TearDownTest() {
 }
 
 func (s *redisSuite) TestPing() {
+	upGauge.Set(0)
 	err := s.redis.Ping(mockCTX)
 	s.NoError(err)
+	s.Equal(float64(1), testutil.ToFloat64(upGauge))
 }
 
 func (s *redisSuite) TestGet() {
@@ -102,6 +108,38 @@ func (s *redisSuite) TestSet() {
 	s.Equal([]byte("test"), act)
 }
 
+func (s *redisSuite) TestDel() {
+	s.NoError(s.redis.Set(mockCTX, "tmp1", []byte("test"), 30*time.Minute))
+	s.NoError(s.redis.Set(mockCTX, "tmp2", []byte("test"), 30*time.Minute))
+
+	err := s.redis.Del(mockCTX, "tmp1", "tmp2", "missing")
+	s.NoError(err)
+
+	_, err = s.redis.Get(mockCTX, "tmp1")
+	s.Equal(redis.Nil, err)
+	_, err = s.redis.Get(mockCTX, "tmp2")
+	s.Equal(redis.Nil, err)
+}
+
+func (s *redisSuite) TestHSet() {
+	s.NoError(s.redis.HSet(mockCTX, "tmp", "a", []byte("1")))
+	s.NoError(s.redis.HSet(mockCTX, "tmp", "b", []byte("2")))
+	s.NoError(s.redis.HSet(mockCTX, "tmp", "a", []byte("3")))
+
+	values, err := s.redis.HGetAll(mockCTX, "tmp")
+	s.NoError(err)
+	s.Equal(map[string]string{"a": "3", "b": "2"}, values)
+
+	s.NoError(s.redis.HDel(mockCTX, "tmp", "a", "missing"))
+	values, err = s.redis.HGetAll(mockCTX, "tmp")
+	s.NoError(err)
+	s.Equal(map[string]string{"b": "2"}, values)
+
+	values, err = s.redis.HGetAll(mockCTX, "missing")
+	s.NoError(err)
+	s.Empty(values)
+}
+
 func (s *redisSuite) TestRunScript() {
 	script := `
 redis.call('HSET', KEYS[1], 'timestamp', ARGV[1])
@@ -130,11 +168,53 @@ func (s *redisSuite) TestIncr() {
 	s.Equal(int64(1), value)
 }
 
+func (s *redisSuite) TestIncrBy() {
+	_, err := s.redis.client.Set(mockCTX, "tmp", []byte("10"), 30*time.Minute).Result()
+	s.NoError(err)
+	value, err := s.redis.IncrBy(mockCTX, "tmp", 5)
+	s.NoError(err)
+	s.Equal(int64(15), value)
+
+	value, err = s.redis.IncrBy(mockCTX, "not-exist", 3)
+	s.NoError(err)
+	s.Equal(int64(3), value)
+}
+
+func (s *redisSuite) TestDecr() {
+	_, err := s.redis.client.Set(mockCTX, "tmp", []byte("10"), 30*time.Minute).Result()
+	s.NoError(err)
+	value, err := s.redis.Decr(mockCTX, "tmp")
+	s.NoError(err)
+	s.Equal(int64(9), value)
+
+	value, err = s.redis.Decr(mockCTX, "not-exist")
+	s.NoError(err)
+	s.Equal(int64(-1), value)
+}
+
 func (s *redisSuite) TestExpire() {
 	err := s.redis.Expire(mockCTX, "tmp", 1*time.Second)
 	s.NoError(err)
 }
 
+func (s *redisSuite) TestTTL() {
+	err := s.redis.Set(mockCTX, "tmp", []byte("test"), 30*time.Minute)
+	s.NoError(err)
+	ttl, err := s.redis.TTL(mockCTX, "tmp")
+	s.NoError(err)
+	s.True(ttl > 29*time.Minute && ttl <= 30*time.Minute)
+
+	err = s.redis.Set(mockCTX, "no-ttl", []byte("test"), 0)
+	s.NoError(err)
+	ttl, err = s.redis.TTL(mockCTX, "no-ttl")
+	s.NoError(err)
+	s.Equal(time.Duration(0), ttl)
+
+	ttl, err = s.redis.TTL(mockCTX, "not-exist")
+	s.NoError(err)
+	s.Equal(time.Duration(0), ttl)
+}
+
 func (s *redisSuite) TestZAdd() {
 	key := "tmp"
 	err := s.redis.ZAdd(mockCTX, key, 5, "4")
@@ -149,6 +229,8 @@ func (s *redisSuite) TestZAdd() {
 	s.NoError(err)
 	err = s.redis.ZAdd(mockCTX, key, 999, "6")
 	s.NoError(err)
+	err = s.redis.ZAdd(mockCTX, key, 1000, "7", "8")
+	s.NoError(err)
 
 	members, err := s.redis.ZRange(mockCTX, key, 0, 2)
 	s.NoError(err)
@@ -159,7 +241,7 @@ func (s *redisSuite) TestZAdd() {
 
 	members, err = s.redis.ZRange(mockCTX, key, 0, 10)
 	s.NoError(err)
-	s.Len(members, 6)
+	s.Len(members, 8)
 	for i := 0; i < len(members); i++ {
 		s.Equal(strconv.FormatInt(int64(i+1), 10), members[i])
 	}
@@ -226,3 +308,98 @@ func (s *redisSuite) TestZRemRangeByScore() {
 	s.NoError(err)
 	s.Equal(0, count)
 }
+
+func (s *redisSuite) TestZRangeByScore() {
+	key := "tmp"
+	for i := 1; i <= 6; i++ {
+		err := s.redis.ZAdd(mockCTX, key, i*10, strconv.Itoa(i))
+		s.NoError(err)
+	}
+
+	members, err := s.redis.ZRangeByScore(mockCTX, key, "20", "50", 0, 10)
+	s.NoError(err)
+	s.Equal([]string{"2", "3", "4", "5"}, members)
+
+	members, err = s.redis.ZRangeByScore(mockCTX, key, "20", "50", 1, 2)
+	s.NoError(err)
+	s.Equal([]string{"3", "4"}, members)
+
+	members, err = s.redis.ZRangeByScore(mockCTX, key, "100", "inf", 0, 1)
+	s.NoError(err)
+	s.Len(members, 0)
+}
+
+func (s *redisSuite) TestZRemRangeByRank() {
+	key := "tmp"
+	for i := 1; i <= 6; i++ {
+		err := s.redis.ZAdd(mockCTX, key, i, strconv.Itoa(i))
+		s.NoError(err)
+	}
+
+	err := s.redis.ZRemRangeByRank(mockCTX, key, -1, -1)
+	s.NoError(err)
+	members, err := s.redis.ZRange(mockCTX, key, 0, -1)
+	s.NoError(err)
+	s.Equal([]string{"1", "2", "3", "4", "5"}, members)
+
+	err = s.redis.ZRemRangeByRank(mockCTX, key, 0, 1)
+	s.NoError(err)
+	members, err = s.redis.ZRange(mockCTX, key, 0, -1)
+	s.NoError(err)
+	s.Equal([]string{"3", "4", "5"}, members)
+}
+
+func (s *redisSuite) TestZRevRangeWithScores() {
+	key := "tmp"
+	for i := 1; i <= 3; i++ {
+		err := s.redis.ZAdd(mockCTX, key, i, strconv.Itoa(i))
+		s.NoError(err)
+	}
+
+	members, err := s.redis.ZRevRangeWithScores(mockCTX, key, 0, 1)
+	s.NoError(err)
+	s.Equal([]Z{{Score: 3, Member: "3"}, {Score: 2, Member: "2"}}, members)
+}
+
+type connectSuite struct {
+	suite.Suite
+	addr string
+}
+
+func TestConnectSuite(t *testing.T) {
+	suite.Run(t, new(connectSuite))
+}
+
+func (s *connectSuite) SetupTest() {
+	// an address nothing listens to
+	listener, err := net.Listen("tcp", "localhost:0")
+	s.Require().NoError(err)
+	s.addr = listener.Addr().String()
+	s.NoError(listener.Close())
+
+	*connectRetries = 2
+	*connectBackoff = 1
+	*degradedStart = false
+}
+
+func (s *connectSuite) TestNewRedisUnreachable() {
+	r, err := NewRedis(s.addr, "")
+	s.Error(err)
+	s.Nil(r)
+}
+
+func (s *connectSuite) TestNewRedisDegradedStart() {
+	*degradedStart = true
+	r, err := NewRedis(s.addr, "")
+	s.NoError(err)
+	s.Equal(float64(0), testutil.ToFloat64(upGauge))
+	s.Error(r.Ping(mockCTX))
+	s.NoError(r.Close())
+}
+
+func (s *connectSuite) TestBackoff() {
+	*connectBackoff = 500
+	s.Equal(500*time.Millisecond, backoff(0))
+	s.Equal(2*time.Second, backoff(2))
+	s.Equal(maxBackoff, backoff(10))
+}
@@ -0,0 +1,42 @@
+package redis
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	callDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "ratelimiter",
+		Subsystem: "redis",
+		Name:      "call_duration_seconds",
+		Help:      "The latency of redis calls by operation.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"operation"})
+	upGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "ratelimiter",
+		Subsystem: "redis",
+		Name:      "up",
+		Help:      "1 if redis was reachable by the last connecting attempt or ping, 0 otherwise.",
+	})
+)
+
+// observe starts timing the operation, call the returned function when the operation is done
+func observe(operation string) func() {
+	start := time.Now()
+	return func() {
+		callDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	}
+}
+
+// reportUp sets upGauge by the error of reaching redis
+func reportUp(err error) {
+	if err != nil {
+		upGauge.Set(0)
+		return
+	}
+
+	upGauge.Set(1)
+}
@@ -12,6 +12,9 @@ var (
 	Nil = redis.Nil
 )
 
+// Z is a member of sorted set with its score
+type Z = redis.Z
+
 type Service interface {
 	// Ping pings the redis server, return error when failed
 	Ping(context ctx.CTX) error
@@ -25,20 +28,55 @@ type Service interface {
 	// Set sets the value of given key with TTL
 	Set(context ctx.CTX, key string, value []byte, ttl time.Duration) error
 
+	// Del deletes given keys
+	Del(context ctx.CTX, keys ...string) error
+
 	// Incr increases by one of given key
 	Incr(context ctx.CTX, key string) (int64, error)
 
+	// IncrBy increases by value of given key
+	IncrBy(context ctx.CTX, key string, value int64) (int64, error)
+
+	// Decr decreases by one of given key
+	Decr(context ctx.CTX, key string) (int64, error)
+
 	// Expire sets the TTL of given key
 	Expire(context ctx.CTX, key string, ttl time.Duration) error
 
-	// ZAdd adds member score to sorted set of given key
-	ZAdd(context ctx.CTX, key string, score int, member string) error
+	// TTL returns the remaining time to live of given key, zero if the key doesn't exist or has no TTL
+	TTL(context ctx.CTX, key string) (time.Duration, error)
+
+	// HSet sets the field of the hash of given key
+	HSet(context ctx.CTX, key, field string, value []byte) error
+
+	// HGetAll returns all fields of the hash of given key
+	HGetAll(context ctx.CTX, key string) (map[string]string, error)
+
+	// HDel deletes the fields of the hash of given key
+	HDel(context ctx.CTX, key string, fields ...string) error
+
+	// ZAdd adds members with the same score to sorted set of given key
+	ZAdd(context ctx.CTX, key string, score int, members ...string) error
 
 	// ZCount counts the member whose score are between given min and max score
 	ZCount(context ctx.CTX, key string, min, max string) (int, error)
 
 	ZRange(context ctx.CTX, key string, start, end int) ([]string, error)
 
+	// ZRangeByScore returns at most count members (all if both offset and count are 0)
+	// whose scores are between given min and max, skipping offset members
+	ZRangeByScore(context ctx.CTX, key string, min, max string, offset, count int) ([]string, error)
+
+	// ZRevRangeWithScores returns the members with scores whose ranks are between given start and end,
+	// ordered from the highest score
+	ZRevRangeWithScores(context ctx.CTX, key string, start, end int) ([]Z, error)
+
+	// ZRemRangeByRank removes the member whose ranks are between given start and end
+	ZRemRangeByRank(context ctx.CTX, key string, start, end int) error
+
 	// ZRemRangeByScore removes the member whose scores are between given min and max
 	ZRemRangeByScore(context ctx.CTX, key string, min, max string) error
+
+	// Close closes the connections to the redis server
+	Close() error
 }